@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"backapp-server/entity"
+	"backapp-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const contextAccessKey = "access"
+
+// currentUser returns the user set by AuthMiddleware
+func currentUser(c *gin.Context) *entity.User {
+	if value, ok := c.Get(contextUserKey); ok {
+		if user, ok := value.(*entity.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// requireRole rejects users whose role grants fewer privileges than role.
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil || !service.RoleAtLeast(user.Role, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires " + role + " role"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentAccess returns the scopes of the signed-in user, loaded once per request.
+func currentAccess(c *gin.Context) (*service.UserAccess, error) {
+	if value, ok := c.Get(contextAccessKey); ok {
+		return value.(*service.UserAccess), nil
+	}
+	user := currentUser(c)
+	if user == nil {
+		return nil, service.ErrSessionNotFound
+	}
+	access, err := service.ServiceGetUserAccess(user)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(contextAccessKey, access)
+	return access, nil
+}
+
+// requireScope aborts with 403 unless allowed reports access to the resource in the path.
+// Requests with a malformed or unknown id pass through so the handler reports it.
+func requireScope(param string, allowed func(access *service.UserAccess, id uint) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access, err := currentAccess(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if access.Unrestricted() {
+			c.Next()
+			return
+		}
+		id, err := strconv.ParseUint(c.Param(param), 10, 32)
+		if err != nil {
+			c.Next()
+			return
+		}
+		if !allowed(access, uint(id)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "access denied"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireServerScope guards routes whose :id is a server
+var requireServerScope = requireScope("id", func(access *service.UserAccess, id uint) bool {
+	return access.CanAccessServer(id)
+})
+
+// requireProfileScope guards routes whose :id is a backup profile
+var requireProfileScope = requireScope("id", func(access *service.UserAccess, id uint) bool {
+	return access.CanAccessProfile(id)
+})
+
+// requireRunScope guards routes whose :id is a backup run
+var requireRunScope = requireScope("id", func(access *service.UserAccess, id uint) bool {
+	run, err := service.ServiceGetBackupRun(id)
+	return err != nil || access.CanAccessProfile(run.BackupProfileID)
+})
+
+// requireFileScope guards routes whose :fileId is a backup file
+var requireFileScope = requireScope("fileId", func(access *service.UserAccess, id uint) bool {
+	file, err := service.ServiceGetBackupFile(id)
+	if err != nil {
+		return true
+	}
+	run, err := service.ServiceGetBackupRun(file.BackupRunID)
+	return err != nil || access.CanAccessProfile(run.BackupProfileID)
+})
+
+// requireRestoreScope guards routes whose :id is a restore job
+var requireRestoreScope = requireScope("id", func(access *service.UserAccess, id uint) bool {
+	job, err := service.ServiceGetRestoreJob(id)
+	if err != nil {
+		return true
+	}
+	run, err := service.ServiceGetBackupRun(job.BackupRunID)
+	return err != nil || access.CanAccessProfile(run.BackupProfileID)
+})
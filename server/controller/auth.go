@@ -0,0 +1,436 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+	"backapp-server/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	sessionCookieName = "backapp_session"
+	csrfCookieName    = "backapp_csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+
+	contextSessionKey  = "session"
+	contextUserKey     = "user"
+	contextAPITokenKey = "api_token"
+)
+
+// publicPaths can be reached without a session
+var publicPaths = map[string]bool{
+	"/health":             true,
+	"/login":              true,
+	"/api/v1/health":      true,
+	"/api/v1/auth/login":  true,
+	"/api/v1/auth/setup":  true,
+	"/api/v1/auth/status": true,
+}
+
+// AuthMiddleware requires a valid session for every route except the public ones.
+// State-changing requests must also echo the session's CSRF token in the X-CSRF-Token header.
+// API routes alternatively accept a personal API token as "Authorization: Bearer <token>".
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if publicPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			user, token, err := service.ServiceAuthenticateAPIToken(strings.TrimSpace(bearer))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidAPIToken.Error()})
+				return
+			}
+			c.Set(contextAPITokenKey, token)
+			c.Set(contextUserKey, user)
+			c.Next()
+			return
+		}
+
+		token, _ := c.Cookie(sessionCookieName)
+		session, err := service.ServiceGetSession(token)
+		if err != nil {
+			rejectUnauthenticated(c)
+			return
+		}
+
+		if !isSafeMethod(c.Request.Method) && !service.ValidCSRFToken(session, c.GetHeader(csrfHeaderName)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid or missing CSRF token"})
+			return
+		}
+
+		c.Set(contextSessionKey, session)
+		c.Set(contextUserKey, session.User)
+		c.Next()
+	}
+}
+
+func rejectUnauthenticated(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") || !isSafeMethod(c.Request.Method) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+	c.Abort()
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requireSession rejects requests authenticated with an API token
+func requireSession(c *gin.Context) {
+	if currentSession(c) == nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "requires a signed-in session, API tokens are not allowed"})
+		return
+	}
+	c.Next()
+}
+
+// currentSession returns the session set by AuthMiddleware, nil for API token requests
+func currentSession(c *gin.Context) *entity.Session {
+	if value, ok := c.Get(contextSessionKey); ok {
+		if session, ok := value.(*entity.Session); ok {
+			return session
+		}
+	}
+	return nil
+}
+
+func setSessionCookies(c *gin.Context, token string, session *entity.Session) {
+	secure := c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+	maxAge := int(session.ExpiresAt.Sub(session.CreatedAt).Seconds())
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Readable by the frontend, which sends it back in the X-CSRF-Token header
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    session.CSRFToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookies(c *gin.Context) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(c.Writer, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1})
+	}
+}
+
+// ---- v1: Auth ----
+
+func handleLoginPage(c *gin.Context) {
+	token, _ := c.Cookie(sessionCookieName)
+	if _, err := service.ServiceGetSession(token); err == nil {
+		c.Redirect(http.StatusFound, safeRedirectTarget(c.Query("next")))
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(loginPageHTML))
+}
+
+// safeRedirectTarget only allows redirects to local paths
+func safeRedirectTarget(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func handleAuthStatus(c *gin.Context) {
+	required, err := service.ServiceSetupRequired()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"setup_required": required})
+}
+
+func handleAuthLogin(c *gin.Context) {
+	var input struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, session, err := service.ServiceLogin(input.Username, input.Password, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidCredentials):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrTooManyLoginAttempts):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	setSessionCookies(c, token, session)
+	c.JSON(http.StatusOK, gin.H{"user": session.User, "csrf_token": session.CSRFToken})
+}
+
+func handleAuthSetup(c *gin.Context) {
+	var input struct {
+		SetupToken string `json:"setup_token"`
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := service.ServiceSetupAdmin(input.SetupToken, input.Username, input.Password); err != nil {
+		switch {
+		case errors.Is(err, service.ErrSetupAlreadyCompleted):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidSetupToken):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	token, session, err := service.ServiceLogin(input.Username, input.Password, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setSessionCookies(c, token, session)
+	c.JSON(http.StatusCreated, gin.H{"user": session.User, "csrf_token": session.CSRFToken})
+}
+
+func handleAuthLogout(c *gin.Context) {
+	token, _ := c.Cookie(sessionCookieName)
+	if err := service.ServiceLogout(token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+func handleAuthMe(c *gin.Context) {
+	session := currentSession(c)
+	if session == nil {
+		c.JSON(http.StatusOK, gin.H{"user": currentUser(c)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": session.User, "csrf_token": session.CSRFToken})
+}
+
+func handleAuthChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	session := currentSession(c)
+	if err := service.ServiceChangePassword(session.UserID, input.CurrentPassword, input.NewPassword, session.ID); err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "current password is incorrect"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password changed"})
+}
+
+// ---- v1: Users ----
+
+func handleUsersList(c *gin.Context) {
+	users, err := service.ServiceListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func handleUsersCreate(c *gin.Context) {
+	var input struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Role == "" {
+		input.Role = service.RoleViewer
+	}
+	user, err := service.ServiceCreateUser(input.Username, input.Password, input.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+func handleUserDelete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := service.ServiceDeleteUser(uint(id)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "user deleted"})
+}
+
+func handleUserUpdate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := service.ServiceUpdateUserRole(uint(id), input.Role)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func handleUserScopesGet(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, err := service.ServiceGetUser(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	scopes, err := service.ServiceListUserScopes(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, scopes)
+}
+
+func handleUserScopesSet(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var scopes []entity.UserScope
+	if err := c.ShouldBindJSON(&scopes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
+		return
+	}
+	result, err := service.ServiceSetUserScopes(uint(id), scopes)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ---- v1: API tokens ----
+
+func handleAPITokensList(c *gin.Context) {
+	tokens, err := service.ServiceListAPITokens(currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func handleAllAPITokensList(c *gin.Context) {
+	tokens, err := service.ServiceListAPITokens(0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func handleAPITokensCreate(c *gin.Context) {
+	var input struct {
+		Name      string     `json:"name"`
+		Role      string     `json:"role"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, token, err := service.ServiceCreateAPIToken(currentUser(c), input.Name, input.Role, input.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// The secret is only returned once
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "api_token": token})
+}
+
+func handleAPITokenRevoke(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	token, err := service.ServiceRevokeAPIToken(uint(id), currentUser(c))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, token)
+}
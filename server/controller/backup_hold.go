@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backapp-server/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ---- v1: Holds ----
+
+func handleHoldsList(c *gin.Context) {
+	runs, err := service.ServiceListHolds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	visible := runs[:0]
+	for _, run := range runs {
+		if access.CanAccessProfile(run.BackupProfileID) {
+			visible = append(visible, run)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+func handleBackupRunHold(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var input struct {
+		Reason    string     `json:"reason"`
+		HoldUntil *time.Time `json:"hold_until"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	run, err := service.ServicePlaceHold(uint(id), input.Reason, requestAuthor(c), input.HoldUntil)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup run not found"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+func handleBackupRunReleaseHold(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	run, err := service.ServiceReleaseHold(uint(id), requestAuthor(c))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup run not found"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// requestAuthor names the user placing or releasing a hold or cancelling a run
+func requestAuthor(c *gin.Context) string {
+	if user := currentUser(c); user != nil {
+		return user.Username
+	}
+	return "unknown"
+}
+
+// deletionErrorStatus reports a deletion refused because of a hold or a WORM lock as a conflict
+func deletionErrorStatus(err error) int {
+	if errors.Is(err, service.ErrBackupRunHeld) || errors.Is(err, service.ErrBackupRunLocked) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
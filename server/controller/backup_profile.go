@@ -19,7 +19,18 @@ func handleBackupProfilesList(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, profiles)
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	visible := profiles[:0]
+	for _, profile := range profiles {
+		if access.CanAccessProfile(profile.ID) {
+			visible = append(visible, profile)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
 }
 
 func handleBackupProfilesCreate(c *gin.Context) {
@@ -88,7 +99,7 @@ func handleBackupProfileDelete(c *gin.Context) {
 		return
 	}
 	if err := service.ServiceDeleteBackupProfile(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(deletionErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.Status(http.StatusOK)
@@ -212,20 +223,37 @@ func handleBackupProfileExecute(c *gin.Context) {
 		return
 	}
 
-	// Execute backup in a goroutine to avoid blocking
-	go func() {
-		executor := service.NewBackupExecutor()
-		// Manual execution should bypass the enabled flag
-		if err := executor.ExecuteBackup(uint(id), true); err != nil {
-			// Log error but don't block response
-			// Error will be saved in the backup_run record
+	// The backup is queued and runs in the background, its outcome is saved in the
+	// backup_run record. Manual execution should bypass the enabled flag
+	job, err := service.ServiceEnqueueBackup(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup profile not found"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		}
-	}()
+		return
+	}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"message":    "Backup started",
-		"profile_id": id,
-	})
+	switch job.Status {
+	case "skipped":
+		c.JSON(http.StatusConflict, gin.H{"error": job.Message, "job_id": job.ID})
+	case "failed":
+		c.JSON(http.StatusBadRequest, gin.H{"error": job.Message, "job_id": job.ID})
+	case "queued":
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":    "Backup queued",
+			"profile_id": id,
+			"job_id":     job.ID,
+		})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":       "Backup started",
+			"profile_id":    id,
+			"job_id":        job.ID,
+			"backup_run_id": job.BackupRunID,
+		})
+	}
 }
 
 func handleBackupProfileDryRun(c *gin.Context) {
@@ -250,3 +278,50 @@ func handleBackupProfileDryRun(c *gin.Context) {
 		"message":    "Dry run only, nothing executed",
 	})
 }
+
+// handleBackupProfileRetentionPreview shows which runs the retention cleanup would prune.
+// Retention query parameters preview another policy instead of the saved one.
+func handleBackupProfileRetentionPreview(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var policy *service.RetentionPolicy
+	proposed := service.RetentionPolicy{}
+	for param, target := range map[string]*int{
+		"retention_days": &proposed.KeepWithinDays,
+		"keep_last":      &proposed.KeepLast,
+		"keep_daily":     &proposed.KeepDaily,
+		"keep_weekly":    &proposed.KeepWeekly,
+		"keep_monthly":   &proposed.KeepMonthly,
+		"keep_yearly":    &proposed.KeepYearly,
+	} {
+		if value := c.Query(param); value != "" {
+			if *target, err = strconv.Atoi(value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param})
+				return
+			}
+			policy = &proposed
+		}
+	}
+	if value := c.Query("max_size_bytes"); value != "" {
+		if proposed.MaxSizeBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid max_size_bytes"})
+			return
+		}
+		policy = &proposed
+	}
+
+	preview, err := service.ServicePreviewRetention(uint(id), policy)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup profile not found"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
@@ -1,9 +1,14 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"backapp-server/service"
 
@@ -26,7 +31,18 @@ func handleBackupRunsList(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, runs)
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	visible := runs[:0]
+	for _, run := range runs {
+		if access.CanAccessProfile(run.BackupProfileID) {
+			visible = append(visible, run)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
 }
 
 func handleBackupRunGet(c *gin.Context) {
@@ -75,6 +91,104 @@ func handleBackupRunLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, logs)
 }
 
+// handleBackupRunDownload streams the files of a run as an archive. Only uncompressed tar
+// archives have a known length, so they are the only format served in ranges.
+func handleBackupRunDownload(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	archive, err := service.ServiceOpenRunArchive(uint(id), c.DefaultQuery("format", "zip"))
+	if err != nil {
+		switch err {
+		case service.ErrUnsupportedArchiveFormat:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup run not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	defer archive.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archive.Name))
+	c.Header("Content-Type", archive.ContentType())
+	c.Header("ETag", archive.ETag)
+
+	size := archive.Size()
+	if size < 0 {
+		c.Status(http.StatusOK)
+		if err := archive.Stream(c.Writer); err != nil {
+			log.Printf("Archive download of backup run %d aborted: %v", id, err)
+		}
+		return
+	}
+
+	c.Header("Accept-Ranges", "bytes")
+	start, length := int64(0), size
+	status := http.StatusOK
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+		// A stale If-Range validator means the archive changed, send it again in full
+		if ifRange := c.GetHeader("If-Range"); ifRange == "" || ifRange == archive.ETag {
+			var ok bool
+			start, length, ok = parseByteRange(rangeHeader, size)
+			if !ok {
+				c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+				c.Status(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if length != size {
+				status = http.StatusPartialContent
+				c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+			}
+		}
+	}
+
+	c.Header("Content-Length", strconv.FormatInt(length, 10))
+	c.Status(status)
+	if err := archive.WriteRange(c.Writer, start, length); err != nil {
+		log.Printf("Archive download of backup run %d aborted: %v", id, err)
+	}
+}
+
+// parseByteRange parses a Range header with a single byte range. Multiple ranges and
+// other units are answered with the whole content.
+func parseByteRange(header string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		// suffix range, the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true
+}
+
 func handleBackupRunDeletionImpact(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -107,13 +221,35 @@ func handleBackupRunDelete(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "backup run not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(deletionErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.Status(http.StatusOK)
 }
 
+func handleBackupRunCancel(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	run, err := service.ServiceCancelBackupRun(uint(id), requestAuthor(c))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup run not found"})
+		} else if errors.Is(err, service.ErrBackupRunNotRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Cancellation requested", "backup_run_id": run.ID})
+}
+
 func handleBackupFileDownload(c *gin.Context) {
 	fileID, err := strconv.ParseUint(c.Param("fileId"), 10, 32)
 	if err != nil {
@@ -133,40 +269,87 @@ func handleBackupFileDownload(c *gin.Context) {
 		return
 	}
 
-	location, err := service.GetStorageLocationForRun(file.BackupRunID)
+	// Falls back to a replica when the primary storage is unreachable
+	reader, fileInfo, err := service.OpenBackupFileReader(file)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found on storage"})
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(
+		http.StatusOK,
+		fileInfo.Size(),
+		"application/octet-stream",
+		reader,
+		map[string]string{"Content-Disposition": "attachment; filename=" + filepath.Base(file.RemotePath)},
+	)
+}
+
+func handleBackupFileEntries(c *gin.Context) {
+	fileID, err := strconv.ParseUint(c.Param("fileId"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve storage location"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file id"})
 		return
 	}
-	backend, err := service.NewStorageBackend(location)
+
+	entries, err := service.ServiceListArchiveEntries(uint(fileID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open storage backend"})
+		respondArchiveEntryError(c, err)
 		return
 	}
-	defer backend.Close()
+	c.JSON(http.StatusOK, entries)
+}
 
-	reader, err := backend.OpenReader(file.LocalPath)
+// handleBackupFileEntryDownload streams one file from inside an archive, without
+// downloading the whole archive
+func handleBackupFileEntryDownload(c *gin.Context) {
+	fileID, err := strconv.ParseUint(c.Param("fileId"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found on storage"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file id"})
+		return
+	}
+	name := c.Query("path")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
 		return
 	}
-	defer reader.Close()
 
-	fileInfo, err := backend.Stat(file.LocalPath)
+	reader, entry, err := service.ServiceOpenArchiveEntry(uint(fileID), name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found on storage"})
+		respondArchiveEntryError(c, err)
 		return
 	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Download of %s from backup file %d failed: %v", entry.Path, fileID, err)
+		}
+	}()
 
 	c.DataFromReader(
 		http.StatusOK,
-		fileInfo.Size(),
+		entry.Size,
 		"application/octet-stream",
 		reader,
-		map[string]string{"Content-Disposition": "attachment; filename=" + filepath.Base(file.RemotePath)},
+		map[string]string{"Content-Disposition": fmt.Sprintf("attachment; filename=%q", path.Base(entry.Path))},
 	)
 }
 
+func respondArchiveEntryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+	case errors.Is(err, service.ErrArchiveEntryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrBackupFileDeleted):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error(), "deleted": true})
+	case errors.Is(err, service.ErrNotAnArchive):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 func handleBackupFileDelete(c *gin.Context) {
 	fileID, err := strconv.ParseUint(c.Param("fileId"), 10, 32)
 	if err != nil {
@@ -179,7 +362,7 @@ func handleBackupFileDelete(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(deletionErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -0,0 +1,104 @@
+package controller
+
+// loginPageHTML is served at /login. It is self-contained because the frontend bundle
+// itself is only served to signed-in users.
+const loginPageHTML = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>BackApp - Sign in</title>
+<style>
+  body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center;
+         font-family: Roboto, Helvetica, Arial, sans-serif; background: #f5f5f5; color: #212121; }
+  form { background: #fff; padding: 32px; border-radius: 8px; width: 320px;
+         box-shadow: 0 2px 8px rgba(0, 0, 0, 0.15); }
+  h1 { margin: 0 0 8px; font-size: 24px; font-weight: 500; }
+  p { margin: 0 0 16px; font-size: 14px; color: #616161; }
+  label { display: block; margin: 12px 0 4px; font-size: 14px; }
+  input { box-sizing: border-box; width: 100%; padding: 10px; font-size: 15px;
+          border: 1px solid #bdbdbd; border-radius: 4px; }
+  button { margin-top: 20px; width: 100%; padding: 10px; font-size: 15px; border: 0; border-radius: 4px;
+           background: #1976d2; color: #fff; cursor: pointer; }
+  button:disabled { opacity: 0.6; cursor: default; }
+  .error { margin-top: 12px; color: #d32f2f; font-size: 14px; min-height: 18px; }
+  .hidden { display: none; }
+</style>
+</head>
+<body>
+<form id="form" data-testid="login-form">
+  <h1 id="title">Sign in to BackApp</h1>
+  <p id="setup-hint" class="hidden">Create the admin account. The setup token is printed in the server log.</p>
+  <div id="setup-token-field" class="hidden">
+    <label for="setup_token">Setup token</label>
+    <input id="setup_token" name="setup_token" autocomplete="off">
+  </div>
+  <label for="username">Username</label>
+  <input id="username" name="username" autocomplete="username" required autofocus>
+  <label for="password">Password</label>
+  <input id="password" name="password" type="password" autocomplete="current-password" required>
+  <button id="submit" type="submit" data-testid="login-submit">Sign in</button>
+  <div id="error" class="error" role="alert"></div>
+</form>
+<script>
+(function () {
+  var form = document.getElementById('form');
+  var errorBox = document.getElementById('error');
+  var setup = false;
+
+  function nextTarget() {
+    var next = new URLSearchParams(window.location.search).get('next') || '/';
+    if (next.charAt(0) !== '/' || next.charAt(1) === '/' || next.charAt(1) === '\\') {
+      return '/';
+    }
+    return next;
+  }
+
+  fetch('/api/v1/auth/status').then(function (r) { return r.json(); }).then(function (status) {
+    if (!status.setup_required) {
+      return;
+    }
+    setup = true;
+    document.getElementById('title').textContent = 'Welcome to BackApp';
+    document.getElementById('setup-hint').classList.remove('hidden');
+    document.getElementById('setup-token-field').classList.remove('hidden');
+    document.getElementById('setup_token').required = true;
+    document.getElementById('password').autocomplete = 'new-password';
+    document.getElementById('submit').textContent = 'Create admin account';
+  });
+
+  form.addEventListener('submit', function (event) {
+    event.preventDefault();
+    errorBox.textContent = '';
+    var body = {
+      username: form.username.value,
+      password: form.password.value
+    };
+    if (setup) {
+      body.setup_token = form.setup_token.value.trim();
+    }
+    var button = document.getElementById('submit');
+    button.disabled = true;
+    fetch(setup ? '/api/v1/auth/setup' : '/api/v1/auth/login', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body)
+    }).then(function (r) {
+      if (r.ok) {
+        window.location.replace(nextTarget());
+        return;
+      }
+      return r.json().then(function (data) {
+        errorBox.textContent = data.error || 'Sign in failed';
+        button.disabled = false;
+      });
+    }).catch(function () {
+      errorBox.textContent = 'Server not reachable';
+      button.disabled = false;
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"backapp-server/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ---- v1: Restore drills ----
+
+func handleBackupProfileRestoreDrill(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	// The drill runs in the background, its outcome is saved in the drill record
+	drill, err := service.ServiceStartRestoreDrill(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "backup profile not found"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":          "Restore drill started",
+		"restore_drill_id": drill.ID,
+	})
+}
+
+func handleRestoreDrillsList(c *gin.Context) {
+	var profileID uint64
+	if value := c.Query("profile_id"); value != "" {
+		var err error
+		if profileID, err = strconv.ParseUint(value, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile_id"})
+			return
+		}
+	}
+
+	drills, err := service.ServiceListRestoreDrills(uint(profileID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	visible := drills[:0]
+	for i := range drills {
+		if access.CanAccessProfile(drills[i].BackupProfileID) {
+			visible = append(visible, drills[i])
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+func handleRestoreDrillGet(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	drill, err := service.ServiceGetRestoreDrill(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "restore drill not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !access.CanAccessProfile(drill.BackupProfileID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return
+	}
+	c.JSON(http.StatusOK, drill)
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"backapp-server/config"
+	"backapp-server/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,64 +14,126 @@ func SetupRouter(r *gin.Engine) {
 	// v1 REST API endpoints
 	// Health endpoint (root level) for Docker healthcheck
 	r.GET("/health", handleHealth)
+	r.GET("/login", handleLoginPage)
+
+	admin := requireRole(service.RoleAdmin)
+	operator := requireRole(service.RoleOperator)
 
 	api := r.Group("/api/v1")
 	{
 		// Health endpoint under API as well
 		api.GET("/health", handleHealth)
+
+		// Authentication
+		api.GET("/auth/status", handleAuthStatus)
+		api.POST("/auth/login", handleAuthLogin)
+		api.POST("/auth/setup", handleAuthSetup)
+		api.POST("/auth/logout", handleAuthLogout)
+		api.GET("/auth/me", handleAuthMe)
+		api.POST("/auth/password", requireSession, handleAuthChangePassword)
+		api.GET("/auth/tokens", requireSession, handleAPITokensList)
+		api.POST("/auth/tokens", requireSession, handleAPITokensCreate)
+		api.DELETE("/auth/tokens/:id", requireSession, handleAPITokenRevoke)
+
+		api.GET("/users", admin, handleUsersList)
+		api.POST("/users", admin, handleUsersCreate)
+		api.PUT("/users/:id", admin, handleUserUpdate)
+		api.DELETE("/users/:id", admin, handleUserDelete)
+		api.GET("/users/:id/scopes", admin, handleUserScopesGet)
+		api.PUT("/users/:id/scopes", admin, handleUserScopesSet)
+		api.GET("/api-tokens", admin, handleAllAPITokensList)
+
 		api.GET("/servers", handleServersList)
-		api.POST("/servers", handleServersCreate)
-		api.GET("/servers/:id", handleServerGet)
-		api.PUT("/servers/:id", handleServerUpdate)
-		api.DELETE("/servers/:id", handleServerDelete)
-		api.GET("/servers/:id/deletion-impact", handleServerDeletionImpact)
-		api.POST("/servers/:id/test-connection", handleServerTestConnection)
-		api.GET("/servers/:id/files", handleServerListFiles)
+		api.POST("/servers", admin, handleServersCreate)
+		api.GET("/servers/:id", requireServerScope, handleServerGet)
+		api.PUT("/servers/:id", admin, handleServerUpdate)
+		api.DELETE("/servers/:id", admin, handleServerDelete)
+		api.GET("/servers/:id/deletion-impact", admin, handleServerDeletionImpact)
+		api.POST("/servers/:id/test-connection", admin, handleServerTestConnection)
+		api.GET("/servers/:id/files", admin, handleServerListFiles)
+		api.GET("/servers/:id/host-key", requireServerScope, handleServerHostKeyGet)
+		api.POST("/servers/:id/host-key/accept", admin, handleServerHostKeyAccept)
+		api.DELETE("/servers/:id/host-key", admin, handleServerHostKeyReset)
 
 		api.GET("/storage-locations", handleStorageLocationsList)
-		api.POST("/storage-locations", handleStorageLocationsCreate)
-		api.PUT("/storage-locations/:id", handleStorageLocationUpdate)
-		api.DELETE("/storage-locations/:id", handleStorageLocationDelete)
-		api.GET("/storage-locations/:id/move-impact", handleStorageLocationMoveImpact)
-		api.GET("/storage-locations/:id/deletion-impact", handleStorageLocationDeletionImpact)
-		api.POST("/storage-locations/:id/test-connection", handleStorageLocationTestConnection)
-		api.GET("/local-files", handleLocalFilesList)
+		api.POST("/storage-locations", admin, handleStorageLocationsCreate)
+		api.PUT("/storage-locations/:id", admin, handleStorageLocationUpdate)
+		api.DELETE("/storage-locations/:id", admin, handleStorageLocationDelete)
+		api.GET("/storage-locations/:id/move-impact", admin, handleStorageLocationMoveImpact)
+		api.GET("/storage-locations/:id/deletion-impact", admin, handleStorageLocationDeletionImpact)
+		api.POST("/storage-locations/:id/test-connection", admin, handleStorageLocationTestConnection)
+		api.GET("/storage-locations/:id/encryption-keys", admin, handleStorageLocationEncryptionKeys)
+		api.POST("/storage-locations/:id/encryption-keys/rotate", admin, handleStorageLocationRotateEncryptionKey)
+		api.GET("/storage-locations/:id/recovery-key", admin, handleStorageLocationRecoveryKey)
+		api.GET("/storage-locations/:id/host-key", handleStorageLocationHostKeyGet)
+		api.POST("/storage-locations/:id/host-key/accept", admin, handleStorageLocationHostKeyAccept)
+		api.DELETE("/storage-locations/:id/host-key", admin, handleStorageLocationHostKeyReset)
+		api.POST("/storage-locations/:id/verify", admin, handleStorageLocationVerify)
+		api.GET("/local-files", admin, handleLocalFilesList)
 
 		api.GET("/naming-rules", handleNamingRulesList)
-		api.POST("/naming-rules", handleNamingRulesCreate)
+		api.POST("/naming-rules", admin, handleNamingRulesCreate)
 		api.POST("/naming-rules/translate", handleNamingRuleTranslate)
-		api.PUT("/naming-rules/:id", handleNamingRuleUpdate)
-		api.DELETE("/naming-rules/:id", handleNamingRuleDelete)
+		api.PUT("/naming-rules/:id", admin, handleNamingRuleUpdate)
+		api.DELETE("/naming-rules/:id", admin, handleNamingRuleDelete)
 
 		api.GET("/backup-profiles", handleBackupProfilesList)
-		api.POST("/backup-profiles", handleBackupProfilesCreate)
-		api.GET("/backup-profiles/:id", handleBackupProfileGet)
-		api.PUT("/backup-profiles/:id", handleBackupProfileUpdate)
-		api.DELETE("/backup-profiles/:id", handleBackupProfileDelete)
-		api.POST("/backup-profiles/:id/duplicate", handleBackupProfileDuplicate)
-		api.GET("/backup-profiles/:id/commands", handleBackupProfileCommandsList)
-		api.POST("/backup-profiles/:id/commands", handleBackupProfileCommandsCreate)
-		api.GET("/backup-profiles/:id/file-rules", handleBackupProfileFileRulesList)
-		api.POST("/backup-profiles/:id/file-rules", handleBackupProfileFileRulesCreate)
-		api.POST("/backup-profiles/:id/run", handleBackupProfileRun)
-		api.POST("/backup-profiles/:id/execute", handleBackupProfileExecute)
-		api.POST("/backup-profiles/:id/dry-run", handleBackupProfileDryRun)
-
-		api.PUT("/commands/:id", handleCommandUpdate)
-		api.DELETE("/commands/:id", handleCommandDelete)
-
-		api.PUT("/file-rules/:id", handleFileRuleUpdate)
-		api.DELETE("/file-rules/:id", handleFileRuleDelete)
+		api.POST("/backup-profiles", admin, handleBackupProfilesCreate)
+		api.GET("/backup-profiles/:id", requireProfileScope, handleBackupProfileGet)
+		api.PUT("/backup-profiles/:id", admin, handleBackupProfileUpdate)
+		api.DELETE("/backup-profiles/:id", admin, handleBackupProfileDelete)
+		api.POST("/backup-profiles/:id/duplicate", admin, handleBackupProfileDuplicate)
+		api.GET("/backup-profiles/:id/commands", requireProfileScope, handleBackupProfileCommandsList)
+		api.POST("/backup-profiles/:id/commands", admin, handleBackupProfileCommandsCreate)
+		api.GET("/backup-profiles/:id/file-rules", requireProfileScope, handleBackupProfileFileRulesList)
+		api.POST("/backup-profiles/:id/file-rules", admin, handleBackupProfileFileRulesCreate)
+		api.GET("/backup-profiles/:id/replicas", requireProfileScope, handleBackupProfileReplicasList)
+		api.GET("/backup-profiles/:id/retention-preview", requireProfileScope, handleBackupProfileRetentionPreview)
+		api.POST("/backup-profiles/:id/replicas", admin, handleBackupProfileReplicasCreate)
+		api.POST("/backup-profiles/:id/run", operator, requireProfileScope, handleBackupProfileRun)
+		api.POST("/backup-profiles/:id/execute", operator, requireProfileScope, handleBackupProfileExecute)
+		api.POST("/backup-profiles/:id/dry-run", operator, requireProfileScope, handleBackupProfileDryRun)
+		api.POST("/backup-profiles/:id/verify", operator, requireProfileScope, handleBackupProfileVerify)
+		api.POST("/backup-profiles/:id/restore-drill", operator, requireProfileScope, handleBackupProfileRestoreDrill)
+
+		api.PUT("/commands/:id", admin, handleCommandUpdate)
+		api.DELETE("/commands/:id", admin, handleCommandDelete)
+
+		api.PUT("/file-rules/:id", admin, handleFileRuleUpdate)
+		api.DELETE("/file-rules/:id", admin, handleFileRuleDelete)
+
+		api.DELETE("/replicas/:id", admin, handleReplicaDelete)
 
 		api.GET("/backup-runs", handleBackupRunsList)
-		api.GET("/backup-runs/:id", handleBackupRunGet)
-		api.GET("/backup-runs/:id/files", handleBackupRunFiles)
-		api.GET("/backup-runs/:id/logs", handleBackupRunLogs)
-		api.GET("/backup-runs/:id/deletion-impact", handleBackupRunDeletionImpact)
-		api.DELETE("/backup-runs/:id", handleBackupRunDelete)
-		api.GET("/backup-files/:fileId", handleBackupFileGet)
-		api.GET("/backup-files/:fileId/download", handleBackupFileDownload)
-		api.DELETE("/backup-files/:fileId", handleBackupFileDelete)
+		api.GET("/backup-runs/:id", requireRunScope, handleBackupRunGet)
+		api.GET("/backup-runs/:id/files", requireRunScope, handleBackupRunFiles)
+		api.GET("/backup-runs/:id/logs", requireRunScope, handleBackupRunLogs)
+		api.GET("/backup-runs/:id/replicas", requireRunScope, handleBackupRunReplicas)
+		api.GET("/backup-runs/:id/download", operator, requireRunScope, handleBackupRunDownload)
+		api.POST("/backup-runs/:id/verify", operator, requireRunScope, handleBackupRunVerify)
+		api.POST("/backup-runs/:id/restore", operator, requireRunScope, handleBackupRunRestore)
+		api.POST("/backup-runs/:id/cancel", operator, requireRunScope, handleBackupRunCancel)
+		api.GET("/backup-runs/:id/restores", requireRunScope, handleBackupRunRestores)
+		api.GET("/backup-runs/:id/deletion-impact", admin, handleBackupRunDeletionImpact)
+		api.DELETE("/backup-runs/:id", admin, handleBackupRunDelete)
+		api.POST("/backup-runs/:id/hold", operator, requireRunScope, handleBackupRunHold)
+		api.DELETE("/backup-runs/:id/hold", admin, handleBackupRunReleaseHold)
+		api.GET("/holds", handleHoldsList)
+		api.GET("/backup-files/:fileId", requireFileScope, handleBackupFileGet)
+		api.GET("/backup-files/:fileId/download", operator, requireFileScope, handleBackupFileDownload)
+		api.GET("/backup-files/:fileId/entries", requireFileScope, handleBackupFileEntries)
+		api.GET("/backup-files/:fileId/entries/download", operator, requireFileScope, handleBackupFileEntryDownload)
+		api.DELETE("/backup-files/:fileId", admin, handleBackupFileDelete)
+		api.GET("/restore-jobs/:id", requireRestoreScope, handleRestoreJobGet)
+		api.GET("/restore-jobs/:id/logs", requireRestoreScope, handleRestoreJobLogs)
+
+		api.GET("/backup-jobs", handleBackupJobsList)
+		api.DELETE("/backup-jobs/:id", operator, handleBackupJobCancel)
+
+		api.GET("/verifications", handleVerificationsList)
+		api.GET("/verifications/:id", handleVerificationGet)
+		api.GET("/restore-drills", handleRestoreDrillsList)
+		api.GET("/restore-drills/:id", handleRestoreDrillGet)
 
 		// Push notifications
 		api.GET("/notifications/vapid-key", handleGetVAPIDPublicKey)
@@ -89,9 +152,9 @@ func SetupRouter(r *gin.Engine) {
 
 		// Test-only endpoints
 		if config.TestMode {
-			api.POST("/test/reset-database", handleResetDatabase)
-			api.POST("/test/trigger-retention-cleanup", handleTriggerRetentionCleanup)
-			api.PUT("/test/backup-runs/:id/date", handleUpdateBackupRunDate)
+			api.POST("/test/reset-database", admin, handleResetDatabase)
+			api.POST("/test/trigger-retention-cleanup", admin, handleTriggerRetentionCleanup)
+			api.PUT("/test/backup-runs/:id/date", admin, handleUpdateBackupRunDate)
 		}
 	}
 }
@@ -21,7 +21,18 @@ func handleServersList(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, servers)
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	visible := servers[:0]
+	for _, server := range servers {
+		if access.CanAccessServer(server.ID) {
+			visible = append(visible, server)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
 }
 
 func handleServersCreate(c *gin.Context) {
@@ -161,7 +172,7 @@ func handleServerDelete(c *gin.Context) {
 		return
 	}
 	if err := service.ServiceDeleteServer(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(deletionErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.Status(http.StatusOK)
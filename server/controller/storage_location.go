@@ -40,7 +40,20 @@ func handleStorageLocationsCreate(c *gin.Context) {
 		username := c.PostForm("username")
 		authType := c.PostForm("auth_type")
 		password := c.PostForm("password")
+		endpoint := c.PostForm("endpoint")
+		bucket := c.PostForm("bucket")
+		region := c.PostForm("region")
+		accessKey := c.PostForm("access_key")
+		secretKey := c.PostForm("secret_key")
+		pathStyle, _ := strconv.ParseBool(c.PostForm("path_style"))
+		tlsMode := c.PostForm("tls_mode")
+		tlsSkipVerify, _ := strconv.ParseBool(c.PostForm("tls_skip_verify"))
+		encrypted, _ := strconv.ParseBool(c.PostForm("encrypted"))
+		dedup, _ := strconv.ParseBool(c.PostForm("dedup"))
 		port := 22
+		if storageType == "ftp" {
+			port = 0 // Resolved from the TLS mode
+		}
 		if portStr := c.PostForm("port"); portStr != "" {
 			if p, err := strconv.Atoi(portStr); err == nil {
 				port = p
@@ -61,9 +74,93 @@ func handleStorageLocationsCreate(c *gin.Context) {
 				return
 			}
 			loc := &entity.StorageLocation{
-				Name:     name,
-				Type:     storageType,
-				BasePath: basePath,
+				Name:      name,
+				Encrypted: encrypted,
+				Dedup:     dedup,
+				Type:      storageType,
+				BasePath:  basePath,
+			}
+			created, err := service.ServiceCreateStorageLocation(loc)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, created)
+			return
+		}
+
+		if storageType == "s3" {
+			if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "missing required s3 fields"})
+				return
+			}
+			loc := &entity.StorageLocation{
+				Name:       name,
+				Encrypted:  encrypted,
+				Dedup:      dedup,
+				Type:       storageType,
+				Endpoint:   endpoint,
+				Bucket:     bucket,
+				Region:     region,
+				AccessKey:  accessKey,
+				SecretKey:  secretKey,
+				PathStyle:  pathStyle,
+				RemotePath: remotePath,
+			}
+			created, err := service.ServiceCreateStorageLocation(loc)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, created)
+			return
+		}
+
+		if storageType == "webdav" {
+			if endpoint == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "missing required webdav fields"})
+				return
+			}
+			if authType != "basic" && authType != "digest" {
+				authType = ""
+			}
+			loc := &entity.StorageLocation{
+				Name:       name,
+				Encrypted:  encrypted,
+				Dedup:      dedup,
+				Type:       storageType,
+				Endpoint:   endpoint,
+				RemotePath: remotePath,
+				Username:   username,
+				Password:   password,
+				AuthType:   authType,
+			}
+			created, err := service.ServiceCreateStorageLocation(loc)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, created)
+			return
+		}
+
+		if storageType == "ftp" {
+			if address == "" || remotePath == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "missing required ftp fields"})
+				return
+			}
+			loc := &entity.StorageLocation{
+				Name:          name,
+				Encrypted:     encrypted,
+				Dedup:         dedup,
+				Type:          storageType,
+				Address:       address,
+				Port:          port,
+				RemotePath:    remotePath,
+				Username:      username,
+				Password:      password,
+				TLSMode:       tlsMode,
+				TLSSkipVerify: tlsSkipVerify,
 			}
 			created, err := service.ServiceCreateStorageLocation(loc)
 			if err != nil {
@@ -85,6 +182,8 @@ func handleStorageLocationsCreate(c *gin.Context) {
 
 		location := &entity.StorageLocation{
 			Name:       name,
+			Encrypted:  encrypted,
+			Dedup:      dedup,
 			Type:       storageType,
 			Address:    address,
 			Port:       port,
@@ -165,6 +264,33 @@ func handleStorageLocationsCreate(c *gin.Context) {
 				return
 			}
 		}
+	} else if storageType == "s3" {
+		if input.Endpoint == "" || input.Bucket == "" || input.AccessKey == "" || input.SecretKey == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing required s3 fields"})
+			return
+		}
+	} else if storageType == "webdav" {
+		if input.Endpoint == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing required webdav fields"})
+			return
+		}
+		switch input.AuthType {
+		case "", "basic", "digest":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported webdav auth_type"})
+			return
+		}
+	} else if storageType == "ftp" {
+		if input.Address == "" || input.RemotePath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing required ftp fields"})
+			return
+		}
+		switch input.TLSMode {
+		case "", "none", "explicit", "implicit":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported ftp tls_mode"})
+			return
+		}
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported storage type"})
 		return
@@ -198,9 +324,12 @@ func handleStorageLocationUpdate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON body"})
 		return
 	}
-	_, setEnabled := rawMap["enabled"]
+	setFields := make(map[string]bool, len(rawMap))
+	for key := range rawMap {
+		setFields[key] = true
+	}
 
-	loc, err := service.ServiceUpdateStorageLocation(uint(id), &input, setEnabled)
+	loc, err := service.ServiceUpdateStorageLocation(uint(id), &input, setFields)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "storage location not found"})
@@ -262,7 +391,7 @@ func handleStorageLocationDelete(c *gin.Context) {
 	id := c.Param("id")
 	err := service.ServiceDeleteStorageLocation(id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(deletionErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "storage location deleted"})
@@ -285,15 +414,84 @@ func handleStorageLocationTestConnection(c *gin.Context) {
 		return
 	}
 
-	if service.NormalizeStorageType(location) != "sftp" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "connection test is only supported for sftp storage"})
+	var testErr error
+	switch service.NormalizeStorageType(location) {
+	case "sftp":
+		testErr = service.TestSFTPConnection(location)
+	case "s3":
+		testErr = service.TestS3Connection(location)
+	case "webdav":
+		testErr = service.TestWebDAVConnection(location)
+	case "ftp":
+		testErr = service.TestFTPConnection(location)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "connection test is only supported for remote storage"})
 		return
 	}
 
-	if err := service.TestSFTPConnection(location); err != nil {
-		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+	if testErr != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": testErr.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "connection successful"})
 }
+
+func handleStorageLocationEncryptionKeys(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	keys, err := service.ServiceListEncryptionKeys(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "storage location not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func handleStorageLocationRotateEncryptionKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	location, err := service.ServiceGetStorageLocation(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "storage location not found"})
+		return
+	}
+	if !location.Encrypted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "encryption is not enabled for this storage location"})
+		return
+	}
+	key, err := service.ServiceRotateEncryptionKey(location.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
+func handleStorageLocationRecoveryKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	recoveryKey, err := service.ServiceGetRecoveryKey(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "storage location not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_key": recoveryKey})
+}
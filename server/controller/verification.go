@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"backapp-server/entity"
+	"backapp-server/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ---- v1: Verifications ----
+
+func handleBackupRunVerify(c *gin.Context) {
+	startVerification(c, "backup run not found", service.ServiceVerifyBackupRun)
+}
+
+func handleBackupProfileVerify(c *gin.Context) {
+	startVerification(c, "backup profile not found", service.ServiceVerifyBackupProfile)
+}
+
+func handleStorageLocationVerify(c *gin.Context) {
+	startVerification(c, "storage location not found", service.ServiceVerifyStorageLocation)
+}
+
+// startVerification starts the verification of the resource in the path, the outcome is
+// saved in the verification record.
+func startVerification(c *gin.Context, notFound string, start func(id uint) (*entity.BackupVerification, error)) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	verification, err := start(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":         "Verification started",
+		"verification_id": verification.ID,
+	})
+}
+
+// canAccessVerification reports whether the user may see a verification. Verifications of
+// a whole storage location span profiles and are only visible without scopes.
+func canAccessVerification(access *service.UserAccess, verification *entity.BackupVerification) bool {
+	if verification.BackupProfileID == nil {
+		return access.Unrestricted()
+	}
+	return access.CanAccessProfile(*verification.BackupProfileID)
+}
+
+func handleVerificationsList(c *gin.Context) {
+	var filter service.VerificationFilter
+	for param, target := range map[string]*uint{
+		"run_id":      &filter.BackupRunID,
+		"profile_id":  &filter.BackupProfileID,
+		"location_id": &filter.StorageLocationID,
+	} {
+		if value := c.Query(param); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param})
+				return
+			}
+			*target = uint(id)
+		}
+	}
+
+	verifications, err := service.ServiceListVerifications(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	visible := verifications[:0]
+	for i := range verifications {
+		if canAccessVerification(access, &verifications[i]) {
+			visible = append(visible, verifications[i])
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+func handleVerificationGet(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	verification, err := service.ServiceGetVerification(uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "verification not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	access, err := currentAccess(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !canAccessVerification(access, verification) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return
+	}
+	c.JSON(http.StatusOK, verification)
+}
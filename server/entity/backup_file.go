@@ -2,18 +2,29 @@ package entity
 
 import "time"
 
-// BackupFile tracks individual files downloaded during a run
+// BackupFile tracks individual files downloaded during a run.
+// Files that were unchanged in an incremental run point to the copy stored by an
+// earlier run: LocalPath is shared and ReusedFromRunID names the run that stored it.
 type BackupFile struct {
-	ID          uint       `gorm:"primaryKey" json:"id"`
-	BackupRunID uint       `gorm:"not null;constraint:OnDelete:CASCADE" json:"backup_run_id"`
-	FileRuleID  uint       `json:"file_rule_id,omitempty"`
-	RemotePath  string     `gorm:"not null" json:"remote_path"`
-	LocalPath   string     `gorm:"not null" json:"local_path"`
-	SizeBytes   int64      `json:"size_bytes"`
-	FileSize    int64      `json:"file_size,omitempty"`
-	Checksum    string     `json:"checksum,omitempty"`
-	Deleted     bool       `gorm:"default:false" json:"deleted"`
-	Available   *bool      `gorm:"-" json:"available,omitempty"`
-	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	BackupRunID      uint       `gorm:"not null;constraint:OnDelete:CASCADE" json:"backup_run_id"`
+	FileRuleID       uint       `json:"file_rule_id,omitempty"`
+	RemotePath       string     `gorm:"not null" json:"remote_path"`
+	LocalPath        string     `gorm:"not null" json:"local_path"`
+	SnapshotPath     string     `json:"snapshot_path,omitempty"` // path relative to the run's backup directory
+	SizeBytes        int64      `json:"size_bytes"`
+	FileSize         int64      `json:"file_size,omitempty"`
+	RemoteModTime    *time.Time `json:"remote_mod_time,omitempty"`
+	RemoteMode       uint32     `json:"remote_mode,omitempty"` // permission bits on the source server
+	ReusedFromRunID  *uint      `gorm:"index" json:"reused_from_run_id,omitempty"`
+	Checksum         string     `json:"checksum,omitempty"`        // SHA-256 of the content, computed while storing it
+	RemoteChecksum   string     `json:"remote_checksum,omitempty"` // SHA-256 computed on the source server, when verified
+	ChecksumMismatch bool       `gorm:"default:false" json:"checksum_mismatch"`
+	Encrypted        bool       `gorm:"default:false" json:"encrypted"`              // stored with client-side encryption of its location
+	IntegrityStatus  string     `gorm:"type:text" json:"integrity_status,omitempty"` // result of the last verification
+	VerifiedAt       *time.Time `json:"verified_at,omitempty"`
+	Deleted          bool       `gorm:"default:false" json:"deleted"`
+	Available        *bool      `gorm:"-" json:"available,omitempty"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
 }
@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// BackupJob is a requested backup execution waiting in or taken from the job queue. Jobs
+// are persisted so queued backups survive a restart.
+type BackupJob struct {
+	ID                uint           `gorm:"primaryKey" json:"id"`
+	BackupProfileID   uint           `gorm:"not null;index" json:"backup_profile_id"`
+	BackupProfile     *BackupProfile `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"-"`
+	ServerID          uint           `json:"server_id"`                     // copied from the profile for the concurrency limits
+	StorageLocationID uint           `json:"storage_location_id"`           // copied from the profile for the concurrency limits
+	Trigger           string         `gorm:"type:text" json:"trigger"`      // manual or scheduled
+	AllowDisabled     bool           `json:"allow_disabled"`                // manual executions also run disabled profiles
+	Status            string         `gorm:"type:text;index" json:"status"` // queued, running, completed, failed, skipped or cancelled
+	BackupRunID       *uint          `json:"backup_run_id,omitempty"`       // set once the job started
+	Message           string         `json:"message,omitempty"`             // why a job was skipped or failed to start
+	QueuedAt          time.Time      `json:"queued_at"`
+	StartedAt         *time.Time     `json:"started_at,omitempty"`
+	FinishedAt        *time.Time     `json:"finished_at,omitempty"`
+}
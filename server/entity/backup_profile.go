@@ -4,20 +4,33 @@ import "time"
 
 // BackupProfile defines a backup configuration
 type BackupProfile struct {
-	ID                uint      `gorm:"primaryKey" json:"id"`
-	Name              string    `gorm:"not null" json:"name"`
-	ServerID          uint      `gorm:"not null;constraint:OnDelete:RESTRICT" json:"server_id"`
-	StorageLocationID uint      `gorm:"not null;constraint:OnDelete:RESTRICT" json:"storage_location_id"`
-	NamingRuleID      uint      `gorm:"not null;constraint:OnDelete:RESTRICT" json:"naming_rule_id"`
-	ScheduleCron      string    `json:"schedule_cron,omitempty"`
-	RetentionDays     *int      `json:"retention_days"` // nil or 0 means keep forever
-	Enabled           bool      `json:"enabled"`
-	CreatedAt         time.Time `json:"created_at"`
+	ID                   uint      `gorm:"primaryKey" json:"id"`
+	Name                 string    `gorm:"not null" json:"name"`
+	ServerID             uint      `gorm:"not null;constraint:OnDelete:RESTRICT" json:"server_id"`
+	StorageLocationID    uint      `gorm:"not null;constraint:OnDelete:RESTRICT" json:"storage_location_id"`
+	NamingRuleID         uint      `gorm:"not null;constraint:OnDelete:RESTRICT" json:"naming_rule_id"`
+	ScheduleCron         string    `json:"schedule_cron,omitempty"`
+	VerifyCron           string    `json:"verify_cron,omitempty"`
+	RestoreDrillCron     string    `json:"restore_drill_cron,omitempty"`      // test restores of the latest successful run
+	RestoreDrillServerID *uint     `json:"restore_drill_server_id,omitempty"` // test server, empty restores locally
+	RestoreDrillPath     string    `json:"restore_drill_path,omitempty"`      // parent of the scratch directory
+	RestoreDrillCommand  string    `json:"restore_drill_command,omitempty"`   // run in the scratch directory
+	RetentionDays        *int      `json:"retention_days"`                    // keeps runs of the last days, nil or 0 without other rules keeps forever
+	KeepLast             int       `json:"keep_last"`                         // most recent runs to keep
+	KeepDaily            int       `json:"keep_daily"`                        // days, weeks, months and years to keep the last run of
+	KeepWeekly           int       `json:"keep_weekly"`
+	KeepMonthly          int       `json:"keep_monthly"`
+	KeepYearly           int       `json:"keep_yearly"`
+	MaxSizeBytes         int64     `json:"max_size_bytes"`     // oldest runs are pruned above this stored size, 0 is unlimited
+	ConcurrencyPolicy    string    `json:"concurrency_policy"` // queue or skip a backup requested while one is running
+	Enabled              bool      `json:"enabled"`
+	CreatedAt            time.Time `json:"created_at"`
 
-	Server          *Server          `gorm:"foreignKey:ServerID" json:"server,omitempty"`
-	StorageLocation *StorageLocation `gorm:"foreignKey:StorageLocationID" json:"storage_location,omitempty"`
-	NamingRule      *NamingRule      `gorm:"foreignKey:NamingRuleID" json:"naming_rule,omitempty"`
-	Commands        []Command        `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"commands,omitempty"`
-	FileRules       []FileRule       `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"file_rules,omitempty"`
-	BackupRuns      []BackupRun      `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"backup_runs,omitempty"`
+	Server          *Server                `gorm:"foreignKey:ServerID" json:"server,omitempty"`
+	StorageLocation *StorageLocation       `gorm:"foreignKey:StorageLocationID" json:"storage_location,omitempty"`
+	NamingRule      *NamingRule            `gorm:"foreignKey:NamingRuleID" json:"naming_rule,omitempty"`
+	Commands        []Command              `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"commands,omitempty"`
+	FileRules       []FileRule             `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"file_rules,omitempty"`
+	BackupRuns      []BackupRun            `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"backup_runs,omitempty"`
+	Replicas        []BackupProfileReplica `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"replicas,omitempty"`
 }
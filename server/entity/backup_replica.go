@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+// BackupProfileReplica is an additional storage location every run of a profile is copied to
+type BackupProfileReplica struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	BackupProfileID   uint      `gorm:"not null;uniqueIndex:idx_profile_replica_location;constraint:OnDelete:CASCADE" json:"backup_profile_id"`
+	StorageLocationID uint      `gorm:"not null;uniqueIndex:idx_profile_replica_location;constraint:OnDelete:RESTRICT" json:"storage_location_id"`
+	CreatedAt         time.Time `json:"created_at"`
+
+	StorageLocation *StorageLocation `gorm:"foreignKey:StorageLocationID" json:"storage_location,omitempty"`
+}
+
+// BackupRunReplica tracks the copy of a backup run on a replica storage location
+type BackupRunReplica struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	BackupRunID       uint      `gorm:"not null;index;constraint:OnDelete:CASCADE" json:"backup_run_id"`
+	StorageLocationID uint      `gorm:"not null;index" json:"storage_location_id"`
+	Status            string    `gorm:"type:text" json:"status"`
+	BackupPath        string    `json:"backup_path,omitempty"`
+	TotalFiles        int       `json:"total_files"`
+	TotalSizeBytes    int64     `json:"total_size_bytes"`
+	Encrypted         bool      `gorm:"default:false" json:"encrypted"` // copies were written with client-side encryption
+	ErrorMessage      string    `json:"error_message,omitempty"`
+	StartTime         time.Time `json:"start_time"`
+	EndTime           time.Time `json:"end_time"`
+}
@@ -12,9 +12,20 @@ type BackupRun struct {
 	LocalBackupPath    string    `json:"local_backup_path,omitempty"`
 	TotalFiles         int       `json:"total_files"`
 	TotalSizeBytes     int64     `json:"total_size_bytes"`
+	ChecksumMismatches int       `json:"checksum_mismatches"`
 	ErrorMessage       string    `json:"error_message,omitempty"`
 	Log                string    `json:"log,omitempty"`
 	RetentionCleanedUp bool      `gorm:"default:false" json:"retention_cleaned_up"`
 
-	BackupFiles []BackupFile `gorm:"foreignKey:BackupRunID;constraint:OnDelete:CASCADE" json:"backup_files,omitempty"`
+	// A hold keeps the run from retention and deletion until it is released or expires
+	Held       bool       `gorm:"default:false;index" json:"held"`
+	HoldReason string     `json:"hold_reason,omitempty"`
+	HoldBy     string     `json:"hold_by,omitempty"`
+	HeldAt     *time.Time `json:"held_at,omitempty"`
+	HoldUntil  *time.Time `json:"hold_until,omitempty"` // nil holds until released
+
+	LockedUntil *time.Time `json:"locked_until,omitempty"` // set on WORM storage locations, the run cannot be removed before
+
+	BackupFiles []BackupFile       `gorm:"foreignKey:BackupRunID;constraint:OnDelete:CASCADE" json:"backup_files,omitempty"`
+	Replicas    []BackupRunReplica `gorm:"foreignKey:BackupRunID;constraint:OnDelete:CASCADE" json:"replicas,omitempty"`
 }
@@ -0,0 +1,37 @@
+package entity
+
+import "time"
+
+// BackupVerification records an integrity check of the stored files of a run, a profile
+// or a storage location. Only the files that failed are kept as results.
+type BackupVerification struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	Scope             string    `gorm:"type:text" json:"scope"` // run, profile or location
+	BackupRunID       *uint     `gorm:"index" json:"backup_run_id,omitempty"`
+	BackupProfileID   *uint     `gorm:"index" json:"backup_profile_id,omitempty"`
+	StorageLocationID *uint     `gorm:"index" json:"storage_location_id,omitempty"`
+	Trigger           string    `gorm:"type:text" json:"trigger"` // manual or scheduled
+	Status            string    `gorm:"type:text" json:"status"`
+	StartTime         time.Time `json:"start_time"`
+	EndTime           time.Time `json:"end_time"`
+	FilesChecked      int       `json:"files_checked"`
+	FilesFailed       int       `json:"files_failed"`
+	BytesRead         int64     `json:"bytes_read"`
+	ErrorMessage      string    `json:"error_message,omitempty"`
+
+	Results []BackupVerificationResult `gorm:"foreignKey:BackupVerificationID;constraint:OnDelete:CASCADE" json:"results,omitempty"`
+}
+
+// BackupVerificationResult describes a file that failed a verification
+type BackupVerificationResult struct {
+	ID                   uint      `gorm:"primaryKey" json:"id"`
+	BackupVerificationID uint      `gorm:"not null;index;constraint:OnDelete:CASCADE" json:"backup_verification_id"`
+	BackupFileID         uint      `gorm:"index" json:"backup_file_id"`
+	BackupRunID          uint      `json:"backup_run_id"`
+	LocalPath            string    `json:"local_path"`
+	Status               string    `gorm:"type:text" json:"status"` // missing, checksum_mismatch, archive_corrupt or unreadable
+	ExpectedChecksum     string    `json:"expected_checksum,omitempty"`
+	ActualChecksum       string    `json:"actual_checksum,omitempty"`
+	Message              string    `json:"message,omitempty"`
+	CreatedAt            time.Time `json:"created_at"`
+}
@@ -2,13 +2,13 @@ package entity
 
 import "time"
 
-// Command is executed over SSH before or after file transfer
+// Command is executed over SSH before or after file transfer, or when a run is cancelled
 type Command struct {
 	ID               uint      `gorm:"primaryKey" json:"id"`
 	BackupProfileID  uint      `gorm:"not null;constraint:OnDelete:CASCADE" json:"backup_profile_id"`
 	Command          string    `gorm:"not null" json:"command"`
 	WorkingDirectory string    `json:"working_directory"`
 	RunOrder         int       `gorm:"not null" json:"run_order"`
-	RunStage         string    `gorm:"type:text;check:run_stage IN ('pre', 'post')" json:"run_stage"`
+	RunStage         string    `gorm:"type:text;check:run_stage IN ('pre', 'post', 'cancel')" json:"run_stage"`
 	CreatedAt        time.Time `json:"created_at"`
 }
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// DedupChunk is a content-addressed chunk stored once in a deduplicated storage location.
+// RefCount counts the file manifests referencing it, unreferenced chunks are removed by retention.
+type DedupChunk struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	StorageLocationID uint      `gorm:"not null;uniqueIndex:idx_location_chunk_hash" json:"storage_location_id"`
+	Hash              string    `gorm:"not null;uniqueIndex:idx_location_chunk_hash" json:"hash"`
+	SizeBytes         int64     `json:"size_bytes"`
+	RefCount          int64     `gorm:"not null;default:0;index" json:"ref_count"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// EncryptionKey is a data key used to encrypt backup files written to a storage location.
+// Only the active key encrypts new files, older keys are kept to decrypt existing ones.
+type EncryptionKey struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	StorageLocationID uint      `gorm:"not null;uniqueIndex:idx_location_key_version" json:"storage_location_id"`
+	Version           uint32    `gorm:"not null;uniqueIndex:idx_location_key_version" json:"version"`
+	Key               string    `gorm:"not null;serializer:secret" json:"-"`
+	Fingerprint       string    `json:"fingerprint"`
+	Active            bool      `gorm:"default:false" json:"active"`
+	CreatedAt         time.Time `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// KnownHost is the trusted SSH host key of a server or SFTP storage location.
+// When a host presents a different key it is kept as pending until accepted.
+type KnownHost struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	ServerID           *uint      `gorm:"uniqueIndex" json:"server_id,omitempty"`
+	StorageLocationID  *uint      `gorm:"uniqueIndex" json:"storage_location_id,omitempty"`
+	Address            string     `json:"address"`
+	KeyType            string     `json:"key_type"`
+	PublicKey          string     `gorm:"not null" json:"public_key"`
+	Fingerprint        string     `gorm:"not null" json:"fingerprint"`
+	PendingKeyType     string     `json:"pending_key_type,omitempty"`
+	PendingPublicKey   string     `json:"pending_public_key,omitempty"`
+	PendingFingerprint string     `json:"pending_fingerprint,omitempty"`
+	MismatchAt         *time.Time `json:"mismatch_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
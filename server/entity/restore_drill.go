@@ -0,0 +1,29 @@
+package entity
+
+import "time"
+
+// RestoreDrill records a test restore of the latest successful run of a profile into a
+// scratch location, including the checks of the restored files and the validation command.
+type RestoreDrill struct {
+	ID                uint           `gorm:"primaryKey" json:"id"`
+	BackupProfileID   uint           `gorm:"not null;index" json:"backup_profile_id"`
+	BackupProfile     *BackupProfile `gorm:"foreignKey:BackupProfileID;constraint:OnDelete:CASCADE" json:"-"`
+	BackupRunID       *uint          `gorm:"index" json:"backup_run_id,omitempty"`
+	RestoreJobID      *uint          `json:"restore_job_id,omitempty"` // restore to a test server
+	ServerID          *uint          `json:"server_id,omitempty"`      // empty for a local scratch directory
+	ScratchPath       string         `json:"scratch_path,omitempty"`
+	Trigger           string         `gorm:"type:text" json:"trigger"` // manual or scheduled
+	Status            string         `gorm:"type:text" json:"status"`  // pending, running, passed, failed or error
+	StartTime         time.Time      `json:"start_time"`
+	EndTime           time.Time      `json:"end_time"`
+	ExpectedFiles     int            `json:"expected_files"`
+	RestoredFiles     int            `json:"restored_files"`
+	VerifiedFiles     int            `json:"verified_files"`
+	FailedFiles       int            `json:"failed_files"`
+	BytesRestored     int64          `json:"bytes_restored"`
+	ValidationCommand string         `json:"validation_command,omitempty"`
+	ValidationOutput  string         `gorm:"type:text" json:"validation_output,omitempty"`
+	ValidationPassed  *bool          `json:"validation_passed,omitempty"`
+	Failures          []string       `gorm:"serializer:json" json:"failures,omitempty"`
+	ErrorMessage      string         `json:"error_message,omitempty"`
+}
@@ -0,0 +1,40 @@
+package entity
+
+import "time"
+
+// RestoreJob copies files of a backup run to a server over SFTP, the source server of the
+// run or any other registered server
+type RestoreJob struct {
+	ID                  uint       `gorm:"primaryKey" json:"id"`
+	BackupRunID         uint       `gorm:"not null;index" json:"backup_run_id"`
+	BackupRun           *BackupRun `gorm:"foreignKey:BackupRunID;constraint:OnDelete:CASCADE" json:"-"`
+	ServerID            uint       `gorm:"not null;index" json:"server_id"`           // defaults to the server of the run's profile
+	FileIDs             []uint     `gorm:"serializer:json" json:"file_ids,omitempty"` // empty restores every file of the run
+	TargetPath          string     `json:"target_path,omitempty"`                     // empty restores files to their original paths
+	ConflictPolicy      string     `gorm:"type:text" json:"conflict_policy"`          // overwrite, skip or rename
+	PreservePermissions bool       `gorm:"default:false" json:"preserve_permissions"`
+	ExtractArchives     bool       `gorm:"default:false" json:"extract_archives"`
+	ArchiveEntries      []string   `gorm:"serializer:json" json:"archive_entries,omitempty"` // paths inside the archive of the single selected file
+	PreCommands         []string   `gorm:"serializer:json" json:"pre_commands,omitempty"`    // run on the server before restoring
+	PostCommands        []string   `gorm:"serializer:json" json:"post_commands,omitempty"`   // run after all files were restored
+	Status              string     `gorm:"type:text" json:"status"`
+	StartTime           time.Time  `json:"start_time"`
+	EndTime             time.Time  `json:"end_time"`
+	TotalFiles          int        `json:"total_files"`
+	RestoredFiles       int        `json:"restored_files"`
+	SkippedFiles        int        `json:"skipped_files"`
+	FailedFiles         int        `json:"failed_files"`
+	TotalSizeBytes      int64      `json:"total_size_bytes"`
+	ErrorMessage        string     `json:"error_message,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+type RestoreJobLog struct {
+	ID           uint        `json:"id" gorm:"primaryKey"`
+	RestoreJobID uint        `json:"restore_job_id" gorm:"not null;index"`
+	RestoreJob   *RestoreJob `json:"-" gorm:"foreignKey:RestoreJobID;constraint:OnDelete:CASCADE"`
+	Timestamp    time.Time   `json:"timestamp" gorm:"not null"`
+	Level        string      `json:"level" gorm:"not null"` // INFO, WARNING, ERROR, DEBUG
+	Message      string      `json:"message" gorm:"type:text;not null"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
@@ -0,0 +1,40 @@
+package entity
+
+import "encoding/json"
+
+// RedactedSecret replaces stored credentials in API responses. Updates that send it
+// back unchanged keep the stored value.
+const RedactedSecret = "********"
+
+func redactSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return RedactedSecret
+}
+
+// MarshalJSON redacts the SSH password.
+func (s Server) MarshalJSON() ([]byte, error) {
+	type plain Server
+	out := plain(s)
+	out.Password = redactSecret(out.Password)
+	return json.Marshal(out)
+}
+
+// MarshalJSON redacts passwords, SSH keys and S3 secret keys.
+func (l StorageLocation) MarshalJSON() ([]byte, error) {
+	type plain StorageLocation
+	out := plain(l)
+	out.Password = redactSecret(out.Password)
+	out.SSHKey = redactSecret(out.SSHKey)
+	out.SecretKey = redactSecret(out.SecretKey)
+	return json.Marshal(out)
+}
+
+// MarshalJSON redacts the archive password.
+func (r FileRule) MarshalJSON() ([]byte, error) {
+	type plain FileRule
+	out := plain(r)
+	out.CompressPassword = redactSecret(out.CompressPassword)
+	return json.Marshal(out)
+}
@@ -4,13 +4,14 @@ import "time"
 
 // Server stores SSH connection details
 type Server struct {
-	ID             uint      `gorm:"primaryKey" json:"id"`
-	Name           string    `gorm:"not null" json:"name"`
-	Host           string    `gorm:"not null" json:"host"`
-	Port           int       `gorm:"default:22" json:"port"`
-	Username       string    `gorm:"not null" json:"username"`
-	AuthType       string    `gorm:"type:text;check:auth_type IN ('password', 'key')" json:"auth_type"`
-	Password       string    `json:"password,omitempty"`
-	PrivateKeyPath string    `json:"-"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID                   uint      `gorm:"primaryKey" json:"id"`
+	Name                 string    `gorm:"not null" json:"name"`
+	Host                 string    `gorm:"not null" json:"host"`
+	Port                 int       `gorm:"default:22" json:"port"`
+	Username             string    `gorm:"not null" json:"username"`
+	AuthType             string    `gorm:"type:text;check:auth_type IN ('password', 'key')" json:"auth_type"`
+	Password             string    `gorm:"serializer:secret" json:"password,omitempty"`
+	PrivateKeyPath       string    `gorm:"serializer:secret" json:"-"`
+	MaxConcurrentBackups int       `json:"max_concurrent_backups"` // backups of this server running at once, 0 is unlimited
+	CreatedAt            time.Time `json:"created_at"`
 }
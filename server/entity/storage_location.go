@@ -4,17 +4,30 @@ import "time"
 
 // StorageLocation defines where backups are stored
 type StorageLocation struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `gorm:"not null" json:"name"`
-	BasePath  string    `gorm:"not null" json:"base_path"`
-	Type      string    `gorm:"default:local" json:"type"`
-	Address   string    `json:"address,omitempty"`
-	Port      int       `json:"port,omitempty"`
-	RemotePath string   `json:"remote_path,omitempty"`
-	Username  string    `json:"username,omitempty"`
-	Password  string    `json:"password,omitempty"`
-	SSHKey    string    `json:"ssh_key,omitempty"`
-	AuthType  string    `json:"auth_type,omitempty"`
-	Enabled   bool      `gorm:"default:true" json:"enabled"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                   uint      `gorm:"primaryKey" json:"id"`
+	Name                 string    `gorm:"not null" json:"name"`
+	BasePath             string    `gorm:"not null" json:"base_path"`
+	Type                 string    `gorm:"default:local" json:"type"`
+	Address              string    `json:"address,omitempty"`
+	Port                 int       `json:"port,omitempty"`
+	RemotePath           string    `json:"remote_path,omitempty"`
+	Username             string    `json:"username,omitempty"`
+	Password             string    `gorm:"serializer:secret" json:"password,omitempty"`
+	SSHKey               string    `gorm:"serializer:secret" json:"ssh_key,omitempty"`
+	AuthType             string    `json:"auth_type,omitempty"`
+	Endpoint             string    `json:"endpoint,omitempty"`
+	Bucket               string    `json:"bucket,omitempty"`
+	Region               string    `json:"region,omitempty"`
+	AccessKey            string    `json:"access_key,omitempty"`
+	SecretKey            string    `gorm:"serializer:secret" json:"secret_key,omitempty"`
+	PathStyle            bool      `gorm:"default:false" json:"path_style"`
+	TLSMode              string    `json:"tls_mode,omitempty"`
+	TLSSkipVerify        bool      `gorm:"default:false" json:"tls_skip_verify"`
+	Encrypted            bool      `gorm:"default:false" json:"encrypted"`
+	Dedup                bool      `gorm:"default:false" json:"dedup"`
+	Enabled              bool      `gorm:"default:true" json:"enabled"`
+	MinFreePercent       float64   `json:"min_free_percent"`       // below this free space retention prunes the oldest runs, 0 disables
+	WormLockDays         int       `json:"worm_lock_days"`         // files stay undeletable for these days after writing, 0 disables
+	MaxConcurrentBackups int       `json:"max_concurrent_backups"` // backups writing here at once, 0 is unlimited
+	CreatedAt            time.Time `json:"created_at"`
 }
@@ -13,6 +13,13 @@ type StorageUsage struct {
 	FreePercent       float64 `json:"free_percent"`
 	BackupCount       int64   `json:"backup_count"`
 	BackupSizeBytes   int64   `json:"backup_size_bytes"`
+
+	// Set for deduplicated locations: the size of all stored files, the size of the
+	// distinct chunks actually stored and the ratio between both.
+	Dedup             bool    `json:"dedup,omitempty"`
+	LogicalSizeBytes  int64   `json:"logical_size_bytes,omitempty"`
+	PhysicalSizeBytes int64   `json:"physical_size_bytes,omitempty"`
+	DedupRatio        float64 `json:"dedup_ratio,omitempty"`
 }
 
 // TotalStorageUsage represents aggregated storage usage across all locations
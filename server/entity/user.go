@@ -0,0 +1,59 @@
+package entity
+
+import "time"
+
+// User is an account that can sign in to the web UI and API.
+// Role is one of admin, operator or viewer. Accounts created before roles existed are admins.
+// Restricted users only access the servers and profiles of their scopes, nothing once
+// all of them were deleted.
+type User struct {
+	ID           uint        `gorm:"primaryKey" json:"id"`
+	Username     string      `gorm:"not null;uniqueIndex" json:"username"`
+	PasswordHash string      `gorm:"not null" json:"-"`
+	Role         string      `gorm:"not null;default:admin" json:"role"`
+	Restricted   bool        `gorm:"not null;default:false" json:"restricted"`
+	LastLoginAt  *time.Time  `json:"last_login_at,omitempty"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	Scopes       []UserScope `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"scopes,omitempty"`
+}
+
+// UserScope restricts an operator or viewer to a server (and all of its profiles) or to
+// a single backup profile. Users that were never given scopes can access everything their role allows.
+type UserScope struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	UserID          uint      `gorm:"not null;index" json:"user_id"`
+	ServerID        *uint     `gorm:"index" json:"server_id,omitempty"`
+	BackupProfileID *uint     `gorm:"index" json:"backup_profile_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Session is a signed-in browser session. Only a hash of the session token is stored.
+type Session struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"not null;index" json:"user_id"`
+	TokenHash  string    `gorm:"not null;uniqueIndex" json:"-"`
+	CSRFToken  string    `gorm:"not null" json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	ExpiresAt  time.Time `gorm:"index" json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	User       *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+}
+
+// APIToken is a personal access token for automation, sent as "Authorization: Bearer".
+// Its role can only lower the privileges of the owning user, who also passes on its scopes.
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"not null" json:"name"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	Prefix     string     `json:"prefix"`
+	Role       string     `gorm:"not null" json:"role"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	User       *User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
+}
@@ -7,6 +7,8 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"backapp-server/config"
 	"backapp-server/controller"
@@ -15,10 +17,25 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// CORSMiddleware handles Cross-Origin Resource Sharing (CORS)
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware handles Cross-Origin Resource Sharing (CORS). Only the configured
+// origins may make credentialed cross-origin requests, the bundled UI is same-origin.
+func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			allowed[origin] = true
+		}
+	}
+
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		origin := c.GetHeader("Origin")
+		if origin == "" || !allowed[origin] {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		c.Writer.Header().Add("Vary", "Origin")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
@@ -32,6 +49,16 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
+// decryptWithRecoveryKey writes the decrypted contents of an encrypted backup file to stdout.
+func decryptWithRecoveryKey(filePath, recoveryKey string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return service.DecryptWithRecoveryKey(recoveryKey, file, os.Stdout)
+}
+
 //go:embed static/* static/**/*
 var embeddedStaticFiles embed.FS
 
@@ -40,18 +67,57 @@ func main() {
 	port := flag.Int("port", 8080, "Port to run the server on")
 	dbPath := flag.String("db", "./app.db", "SQLite database path")
 	testMode := flag.Bool("test-mode", false, "Run in test mode with database reset endpoint")
+	decryptFile := flag.String("decrypt-file", "", "Decrypt a file copied directly from encrypted storage to stdout and exit")
+	recoveryKey := flag.String("recovery-key", "", "Recovery key of the storage location, used with -decrypt-file")
+	masterKeyFile := flag.String("master-key-file", "", "Master key file for stored credentials (default: master.key next to the database)")
+	rotateMasterKey := flag.Bool("rotate-master-key", false, "Re-encrypt stored credentials with a new master key and exit")
+	corsOrigins := flag.String("cors-origins", "", "Comma-separated origins allowed to make cross-origin API requests")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated reverse proxy addresses whose X-Forwarded-For header is trusted")
+	maxConcurrentBackups := flag.Int("max-concurrent-backups", 4, "Number of backups that may run at the same time")
 	flag.Parse()
 	config.TestMode = *testMode
 
+	if *decryptFile != "" {
+		if err := decryptWithRecoveryKey(*decryptFile, *recoveryKey); err != nil {
+			log.Fatalf("Failed to decrypt file: %v", err)
+		}
+		return
+	}
+
+	// Load the master key before the database so stored credentials can be decrypted
+	if err := service.LoadMasterKey(*masterKeyFile, *dbPath); err != nil {
+		log.Fatalf("Failed to load master key: %v", err)
+	}
+
 	// Initialize database via service layer
 	service.InitDB(*dbPath)
 
+	if *rotateMasterKey {
+		newKey, err := service.RotateMasterKey()
+		if err != nil {
+			log.Fatalf("Failed to rotate master key: %v", err)
+		}
+		if service.MasterKeyFromEnv() {
+			log.Printf("Master key rotated, set BACKAPP_MASTER_KEY to the new key before the next start")
+			fmt.Println(newKey)
+		} else {
+			log.Printf("Master key rotated, the key file has been replaced (previous key kept with .old suffix)")
+		}
+		return
+	}
+
+	// Create the first admin account or print the setup token
+	if err := service.BootstrapAdmin(); err != nil {
+		log.Fatalf("Failed to bootstrap admin account: %v", err)
+	}
+
 	// Initialize notification service
 	if err := service.InitNotificationService(); err != nil {
 		log.Printf("Warning: Failed to initialize notification service: %v", err)
 	}
 
-	// Initialize and load scheduled backups
+	// Resume queued backups, then load scheduled backups
+	service.StartJobQueue(*maxConcurrentBackups)
 	scheduler := service.GetScheduler()
 	if err := scheduler.LoadAllSchedules(); err != nil {
 		log.Printf("Warning: Failed to load backup schedules: %v", err)
@@ -69,8 +135,20 @@ func main() {
 	// Initialize gin router and set up routes via controller package
 	router := gin.Default()
 
-	// Add CORS middleware to allow requests from React frontend
-	router.Use(CORSMiddleware())
+	// Client addresses are used to throttle failed logins, only trust configured proxies
+	var proxies []string
+	if *trustedProxies != "" {
+		proxies = strings.Split(*trustedProxies, ",")
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Invalid -trusted-proxies: %v", err)
+	}
+
+	// Add CORS middleware for explicitly allowed origins
+	router.Use(CORSMiddleware(strings.Split(*corsOrigins, ",")))
+
+	// Require a signed-in session for everything except /health and the login page
+	router.Use(controller.AuthMiddleware())
 
 	// Set up API routes first
 	controller.SetupRouter(router)
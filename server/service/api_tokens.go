@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+)
+
+const (
+	apiTokenPrefix        = "bkp_"
+	apiTokenTouchInterval = time.Minute
+)
+
+var ErrInvalidAPIToken = errors.New("invalid, expired or revoked API token")
+
+// ServiceListAPITokens returns the tokens of a user, or of all users when userID is 0.
+func ServiceListAPITokens(userID uint) ([]entity.APIToken, error) {
+	query := DB.Preload("User").Order("id")
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var tokens []entity.APIToken
+	if err := query.Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// ServiceCreateAPIToken creates a token for the user and returns its secret, which is not
+// stored and cannot be shown again. The role defaults to the user's role and cannot exceed it.
+func ServiceCreateAPIToken(user *entity.User, name, role string, expiresAt *time.Time) (string, *entity.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("name is required")
+	}
+	if role == "" {
+		role = user.Role
+	}
+	if !ValidRole(role) {
+		return "", nil, fmt.Errorf("invalid role: %s", role)
+	}
+	if !RoleAtLeast(user.Role, role) {
+		return "", nil, fmt.Errorf("token role cannot exceed your role (%s)", user.Role)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, fmt.Errorf("expires_at must be in the future")
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	secret = apiTokenPrefix + secret
+	token := &entity.APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: hashSessionToken(secret),
+		Prefix:    secret[:len(apiTokenPrefix)+6],
+		Role:      role,
+		ExpiresAt: expiresAt,
+	}
+	if err := DB.Create(token).Error; err != nil {
+		return "", nil, err
+	}
+	return secret, token, nil
+}
+
+// ServiceRevokeAPIToken revokes a token. Users can revoke their own tokens, admins any token.
+func ServiceRevokeAPIToken(id uint, user *entity.User) (*entity.APIToken, error) {
+	var token entity.APIToken
+	if err := DB.First(&token, id).Error; err != nil {
+		return nil, err
+	}
+	if token.UserID != user.ID && user.Role != RoleAdmin {
+		return nil, fmt.Errorf("cannot revoke a token of another user")
+	}
+	if token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+		if err := DB.Model(&token).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &token, nil
+}
+
+// ServiceAuthenticateAPIToken resolves a bearer token to the user it acts as. The returned
+// user carries the lower of the token's and the owner's role.
+func ServiceAuthenticateAPIToken(secret string) (*entity.User, *entity.APIToken, error) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	var tokens []entity.APIToken
+	if err := DB.Preload("User").Where("token_hash = ?", hashSessionToken(secret)).Limit(1).Find(&tokens).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 || tokens[0].User == nil {
+		return nil, nil, ErrInvalidAPIToken
+	}
+	token := &tokens[0]
+	now := time.Now()
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && now.After(*token.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		token.LastUsedAt = &now
+		if err := DB.Model(token).Update("last_used_at", now).Error; err != nil {
+			log.Printf("Failed to update last use of API token %d: %v", token.ID, err)
+		}
+	}
+
+	user := *token.User
+	if !RoleAtLeast(token.Role, user.Role) {
+		user.Role = token.Role
+	}
+	return &user, token, nil
+}
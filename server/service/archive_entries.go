@@ -0,0 +1,368 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+)
+
+var (
+	ErrNotAnArchive         = errors.New("backup file is not an archive of a compressed rule")
+	ErrArchiveEntryNotFound = errors.New("entry not found in archive")
+	ErrBackupFileDeleted    = errors.New("backup file has been deleted")
+)
+
+// ArchiveEntry describes a file or directory inside an archive of a compressed rule
+type ArchiveEntry struct {
+	Path           string     `json:"path"`
+	Size           int64      `json:"size"`
+	CompressedSize int64      `json:"compressed_size,omitempty"`
+	ModTime        *time.Time `json:"mod_time,omitempty"`
+	Mode           uint32     `json:"mode,omitempty"`
+	IsDir          bool       `json:"is_dir"`
+}
+
+// ServiceListArchiveEntries lists the contents of a stored archive
+func ServiceListArchiveEntries(fileID uint) ([]ArchiveEntry, error) {
+	archive, err := openStoredArchiveByID(fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	return archive.entries()
+}
+
+// ServiceOpenArchiveEntry opens a single file inside a stored archive. The caller closes
+// the reader, the returned entry carries the size of the content.
+func ServiceOpenArchiveEntry(fileID uint, name string) (io.ReadCloser, *ArchiveEntry, error) {
+	archive, err := openStoredArchiveByID(fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry, err := archive.find(name)
+	if err == nil && entry.IsDir {
+		err = fmt.Errorf("%s is a directory", entry.Path)
+	}
+	if err != nil {
+		archive.Close()
+		return nil, nil, err
+	}
+	reader, err := archive.open(entry)
+	if err != nil {
+		archive.Close()
+		return nil, nil, err
+	}
+	return &archiveEntryReader{ReadCloser: reader, archive: archive}, entry, nil
+}
+
+func openStoredArchiveByID(fileID uint) (*storedArchive, error) {
+	file, err := ServiceGetBackupFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Deleted {
+		return nil, ErrBackupFileDeleted
+	}
+	return openStoredArchive(file)
+}
+
+// archiveEntryReader closes the archive together with the entry
+type archiveEntryReader struct {
+	io.ReadCloser
+	archive *storedArchive
+}
+
+func (r *archiveEntryReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.archive.Close()
+	return err
+}
+
+// storedArchive gives access to the entries of an archive stored by a compressed rule.
+// Zip archives are read in place when the storage supports random access, so only the
+// central directory and the requested entries are transferred. 7z archives and storage
+// without random access (encrypted, deduplicated, FTP, WebDAV) use a local file.
+type storedArchive struct {
+	file     *entity.BackupFile
+	format   string
+	password string
+	reader   io.ReadCloser
+	zip      *zip.Reader
+	path     string // local archive file for 7z
+	tempFile string
+}
+
+func openStoredArchive(file *entity.BackupFile) (*storedArchive, error) {
+	if file.FileRuleID == 0 {
+		return nil, ErrNotAnArchive
+	}
+	var rules []entity.FileRule
+	if err := DB.Where("id = ?", file.FileRuleID).Limit(1).Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	format := storedArchiveFormat(file.LocalPath)
+	if len(rules) == 0 || !rules[0].Compress || format == "" {
+		return nil, ErrNotAnArchive
+	}
+
+	reader, info, err := OpenBackupFileReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %v", err)
+	}
+	a := &storedArchive{file: file, format: format, reader: reader}
+	if format == "7z" {
+		a.password = rules[0].CompressPassword
+	}
+	if err := a.prepare(info.Size()); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *storedArchive) prepare(size int64) error {
+	inner := a.reader
+	if wrapped, ok := inner.(*backendReadCloser); ok {
+		inner = wrapped.ReadCloser
+	}
+	if a.format == "zip" {
+		if readerAt, ok := inner.(io.ReaderAt); ok {
+			return a.openZip(readerAt, size)
+		}
+	} else if local, ok := inner.(*os.File); ok {
+		a.path = local.Name()
+		return nil
+	}
+
+	tmp, err := os.CreateTemp("", "backapp-archive-*."+a.format)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	a.tempFile = tmp.Name()
+	a.path = tmp.Name()
+	written, err := io.Copy(tmp, a.reader)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to read archive: %v", err)
+	}
+	a.reader.Close()
+	a.reader = tmp
+	if a.format == "zip" {
+		return a.openZip(tmp, written)
+	}
+	return nil
+}
+
+func (a *storedArchive) openZip(readerAt io.ReaderAt, size int64) error {
+	reader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %v", err)
+	}
+	a.zip = reader
+	return nil
+}
+
+func (a *storedArchive) Close() {
+	if a.reader != nil {
+		a.reader.Close()
+	}
+	if a.tempFile != "" {
+		os.Remove(a.tempFile)
+	}
+}
+
+func (a *storedArchive) entries() ([]ArchiveEntry, error) {
+	if a.zip != nil {
+		entries := make([]ArchiveEntry, 0, len(a.zip.File))
+		for _, f := range a.zip.File {
+			modTime := f.Modified
+			entries = append(entries, ArchiveEntry{
+				Path:           strings.TrimSuffix(f.Name, "/"),
+				Size:           int64(f.UncompressedSize64),
+				CompressedSize: int64(f.CompressedSize64),
+				ModTime:        &modTime,
+				Mode:           uint32(f.Mode().Perm()),
+				IsDir:          f.FileInfo().IsDir(),
+			})
+		}
+		return entries, nil
+	}
+	return list7zEntries(a.path, a.password)
+}
+
+// find returns the entry with the given path inside the archive
+func (a *storedArchive) find(name string) (*ArchiveEntry, error) {
+	name = strings.Trim(name, "/")
+	entries, err := a.entries()
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].Path == name {
+			return &entries[i], nil
+		}
+	}
+	return nil, ErrArchiveEntryNotFound
+}
+
+// open streams the content of a file entry
+func (a *storedArchive) open(entry *ArchiveEntry) (io.ReadCloser, error) {
+	if a.zip != nil {
+		for _, f := range a.zip.File {
+			if strings.TrimSuffix(f.Name, "/") == entry.Path {
+				return f.Open()
+			}
+		}
+		return nil, ErrArchiveEntryNotFound
+	}
+	return open7zEntry(a.path, a.password, entry.Path)
+}
+
+// list7zEntries parses the technical listing of 7z l -slt
+func list7zEntries(archivePath, password string) ([]ArchiveEntry, error) {
+	if _, err := exec.LookPath("7z"); err != nil {
+		return nil, fmt.Errorf("7z is required to read 7z archives")
+	}
+	args := []string{"l", "-slt", "-bd"}
+	if password != "" {
+		args = append(args, "-p"+password)
+	}
+	output, err := exec.Command("7z", append(args, archivePath)...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("7z list failed: %s", formatCommandFailure(err, lastLines(string(output), 3)))
+	}
+
+	var entries []ArchiveEntry
+	var current *ArchiveEntry
+	started := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// The archive properties come first, entries follow the dashed separator
+		if !started {
+			started = strings.HasPrefix(line, "----------")
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			entries = append(entries, ArchiveEntry{Path: strings.Trim(value, "/")})
+			current = &entries[len(entries)-1]
+		case "Size":
+			if current != nil {
+				current.Size, _ = strconv.ParseInt(value, 10, 64)
+			}
+		case "Packed Size":
+			if current != nil {
+				current.CompressedSize, _ = strconv.ParseInt(value, 10, 64)
+			}
+		case "Modified":
+			if current != nil && len(value) >= 19 {
+				if modTime, err := time.ParseInLocation("2006-01-02 15:04:05", value[:19], time.Local); err == nil {
+					current.ModTime = &modTime
+				}
+			}
+		case "Folder":
+			if current != nil && value == "+" {
+				current.IsDir = true
+			}
+		case "Attributes":
+			if current != nil && strings.HasPrefix(value, "D") {
+				current.IsDir = true
+			}
+		}
+	}
+	return entries, nil
+}
+
+// open7zEntry extracts one entry to stdout, wildcard matching is disabled so the name is
+// taken literally
+func open7zEntry(archivePath, password, name string) (io.ReadCloser, error) {
+	args := []string{"x", "-so", "-bd", "-spd"}
+	if password != "" {
+		args = append(args, "-p"+password)
+	}
+	cmd := exec.Command("7z", append(args, archivePath, "--", name)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start 7z: %v", err)
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+// commandReader reads the output of a command and reports its failure on close
+type commandReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (r *commandReader) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("7z extract failed: %s", formatCommandFailure(err, lastLines(r.stderr.String(), 3)))
+	}
+	return nil
+}
+
+// selectArchiveEntries resolves the requested paths to file entries, a directory selects
+// every file below it
+func selectArchiveEntries(entries []ArchiveEntry, names []string) ([]ArchiveEntry, error) {
+	var selected []ArchiveEntry
+	added := make(map[string]bool)
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		found := false
+		for _, entry := range entries {
+			if entry.Path != name && !strings.HasPrefix(entry.Path, name+"/") {
+				continue
+			}
+			found = true
+			if !entry.IsDir && !added[entry.Path] {
+				added[entry.Path] = true
+				selected = append(selected, entry)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrArchiveEntryNotFound, name)
+		}
+	}
+	return selected, nil
+}
+
+// safeEntryPath rejects entry paths that would escape the directory they are restored to
+func safeEntryPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("invalid archive entry path %q", name)
+		}
+	}
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("invalid archive entry path %q", name)
+	}
+	return cleaned, nil
+}
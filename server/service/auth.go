@@ -0,0 +1,352 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	sessionLifetime      = 7 * 24 * time.Hour
+	sessionTouchInterval = 5 * time.Minute
+	minPasswordLength    = 8
+	adminUsernameEnv     = "BACKAPP_ADMIN_USERNAME"
+	adminPasswordEnv     = "BACKAPP_ADMIN_PASSWORD"
+
+	loginFailureLimit  = 5
+	loginFailureWindow = 15 * time.Minute
+)
+
+var (
+	ErrInvalidCredentials    = errors.New("invalid username or password")
+	ErrTooManyLoginAttempts  = errors.New("too many failed login attempts, try again later")
+	ErrSessionNotFound       = errors.New("session not found or expired")
+	ErrSetupAlreadyCompleted = errors.New("an admin account already exists")
+	ErrInvalidSetupToken     = errors.New("invalid setup token")
+)
+
+// setupToken authorizes creating the first admin account through the login page.
+// It is only set while no user exists and is printed to the log on startup.
+var setupToken string
+
+// dummyPasswordHash is compared against for unknown usernames so that the response
+// time does not reveal which accounts exist.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("backapp-dummy-password"), bcrypt.DefaultCost)
+
+// loginFailures tracks failed logins per client address
+var loginFailures = struct {
+	sync.Mutex
+	byClient map[string][]time.Time
+}{byClient: make(map[string][]time.Time)}
+
+// BootstrapAdmin makes sure the first admin account can be created. The account is created
+// from BACKAPP_ADMIN_USERNAME and BACKAPP_ADMIN_PASSWORD when set, otherwise a one-time
+// setup token is generated and logged which has to be entered on the login page.
+func BootstrapAdmin() error {
+	var count int64
+	if err := DB.Model(&entity.User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		setupToken = ""
+		return nil
+	}
+
+	username := strings.TrimSpace(os.Getenv(adminUsernameEnv))
+	password := os.Getenv(adminPasswordEnv)
+	if username != "" && password != "" {
+		if _, err := ServiceCreateUser(username, password, RoleAdmin); err != nil {
+			return fmt.Errorf("failed to create admin from %s: %v", adminUsernameEnv, err)
+		}
+		log.Printf("Created admin account '%s'", username)
+		return nil
+	}
+
+	token, err := randomToken(18)
+	if err != nil {
+		return err
+	}
+	setupToken = token
+	log.Printf("No user account exists yet. Open the web interface and create the admin account with setup token: %s", setupToken)
+	return nil
+}
+
+// ServiceSetupRequired reports whether no user account exists yet.
+func ServiceSetupRequired() (bool, error) {
+	var count int64
+	if err := DB.Model(&entity.User{}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// ServiceSetupAdmin creates the first admin account using the setup token from the log.
+func ServiceSetupAdmin(token, username, password string) (*entity.User, error) {
+	required, err := ServiceSetupRequired()
+	if err != nil {
+		return nil, err
+	}
+	if !required {
+		return nil, ErrSetupAlreadyCompleted
+	}
+	if setupToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(setupToken)) != 1 {
+		return nil, ErrInvalidSetupToken
+	}
+	user, err := ServiceCreateUser(username, password, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	setupToken = ""
+	return user, nil
+}
+
+func ServiceListUsers() ([]entity.User, error) {
+	var users []entity.User
+	if err := DB.Preload("Scopes").Order("username").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func ServiceGetUser(id uint) (*entity.User, error) {
+	var user entity.User
+	if err := DB.Preload("Scopes").First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func ServiceCreateUser(username, password, role string) (*entity.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("invalid role: %s", role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := DB.Model(&entity.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("username '%s' is already taken", username)
+	}
+
+	user := &entity.User{Username: username, PasswordHash: hash, Role: role}
+	if err := DB.Create(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ServiceDeleteUser deletes a user with its sessions and scopes. The last admin cannot be deleted.
+func ServiceDeleteUser(id uint) error {
+	user, err := ServiceGetUser(id)
+	if err != nil {
+		return err
+	}
+	if user.Role == RoleAdmin {
+		if err := ensureAnotherAdmin(user.ID); err != nil {
+			return err
+		}
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&entity.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&entity.UserScope{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&entity.APIToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&entity.User{}, id).Error
+	})
+}
+
+// ServiceChangePassword sets a new password after verifying the current one and signs out
+// all other sessions of the user.
+func ServiceChangePassword(userID uint, currentPassword, newPassword string, keepSessionID uint) error {
+	user, err := ServiceGetUser(userID)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)) != nil {
+		return ErrInvalidCredentials
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND id <> ?", userID, keepSessionID).Delete(&entity.Session{}).Error
+	})
+}
+
+// ServiceLogin verifies the credentials and starts a new session. It returns the session
+// token which is only known to the client.
+func ServiceLogin(username, password, clientIP, userAgent string) (string, *entity.Session, error) {
+	if loginBlocked(clientIP) {
+		return "", nil, ErrTooManyLoginAttempts
+	}
+
+	var users []entity.User
+	if err := DB.Where("username = ?", strings.TrimSpace(username)).Limit(1).Find(&users).Error; err != nil {
+		return "", nil, err
+	}
+	if len(users) == 0 {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		recordLoginFailure(clientIP)
+		return "", nil, ErrInvalidCredentials
+	}
+	user := &users[0]
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		recordLoginFailure(clientIP)
+		log.Printf("Failed login for user '%s' from %s", user.Username, clientIP)
+		return "", nil, ErrInvalidCredentials
+	}
+	clearLoginFailures(clientIP)
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	session := &entity.Session{
+		UserID:     user.ID,
+		TokenHash:  hashSessionToken(token),
+		CSRFToken:  csrfToken,
+		UserAgent:  userAgent,
+		IPAddress:  clientIP,
+		ExpiresAt:  now.Add(sessionLifetime),
+		LastSeenAt: now,
+	}
+	if err := DB.Create(session).Error; err != nil {
+		return "", nil, err
+	}
+	if err := DB.Model(user).Update("last_login_at", now).Error; err != nil {
+		log.Printf("Failed to update last login of user %d: %v", user.ID, err)
+	}
+	session.User = user
+
+	// Opportunistically drop sessions that expired in the meantime
+	DB.Where("expires_at < ?", now).Delete(&entity.Session{})
+	return token, session, nil
+}
+
+// ServiceGetSession resolves a session token. Sessions expire a fixed time after login.
+func ServiceGetSession(token string) (*entity.Session, error) {
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+	var sessions []entity.Session
+	if err := DB.Preload("User").Where("token_hash = ?", hashSessionToken(token)).Limit(1).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 || sessions[0].User == nil {
+		return nil, ErrSessionNotFound
+	}
+	session := &sessions[0]
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		DB.Delete(session)
+		return nil, ErrSessionNotFound
+	}
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		session.LastSeenAt = now
+		if err := DB.Model(session).Update("last_seen_at", now).Error; err != nil {
+			log.Printf("Failed to update session %d: %v", session.ID, err)
+		}
+	}
+	return session, nil
+}
+
+// ServiceLogout ends the session belonging to the token.
+func ServiceLogout(token string) error {
+	return DB.Where("token_hash = ?", hashSessionToken(token)).Delete(&entity.Session{}).Error
+}
+
+// ValidCSRFToken compares the CSRF token sent by the client with the one of the session.
+func ValidCSRFToken(session *entity.Session, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(session.CSRFToken), []byte(token)) == 1
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func loginBlocked(clientIP string) bool {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	cutoff := time.Now().Add(-loginFailureWindow)
+	recent := loginFailures.byClient[clientIP][:0]
+	for _, at := range loginFailures.byClient[clientIP] {
+		if at.After(cutoff) {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) == 0 {
+		delete(loginFailures.byClient, clientIP)
+		return false
+	}
+	loginFailures.byClient[clientIP] = recent
+	return len(recent) >= loginFailureLimit
+}
+
+func recordLoginFailure(clientIP string) {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	loginFailures.byClient[clientIP] = append(loginFailures.byClient[clientIP], time.Now())
+}
+
+func clearLoginFailures(clientIP string) {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	delete(loginFailures.byClient, clientIP)
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+)
+
+// cancelCommandTimeout bounds the on-cancel commands, they must not hang like the run did
+const cancelCommandTimeout = 5 * time.Minute
+
+var ErrBackupRunNotRunning = errors.New("backup run is not running")
+
+// runCancellation cancels the context of a running backup
+type runCancellation struct {
+	cancel context.CancelFunc
+	by     string // who requested the cancellation, empty until then
+}
+
+var (
+	runCancelsMu sync.Mutex
+	runCancels   = make(map[uint]*runCancellation) // runID -> cancellation of the running backup
+)
+
+// registerRunCancel makes a running backup cancellable, the returned function unregisters it
+func registerRunCancel(runID uint, cancel context.CancelFunc) func() {
+	runCancelsMu.Lock()
+	runCancels[runID] = &runCancellation{cancel: cancel}
+	runCancelsMu.Unlock()
+	return func() {
+		runCancelsMu.Lock()
+		delete(runCancels, runID)
+		runCancelsMu.Unlock()
+	}
+}
+
+// runCancelledBy returns who cancelled a running backup
+func runCancelledBy(runID uint) string {
+	runCancelsMu.Lock()
+	defer runCancelsMu.Unlock()
+	if c, ok := runCancels[runID]; ok {
+		return c.by
+	}
+	return ""
+}
+
+// ServiceCancelBackupRun cancels a running backup: remote commands and transfers are aborted,
+// the files written so far are removed and the on-cancel commands of the profile run. The
+// run ends with status cancelled shortly after this returns.
+func ServiceCancelBackupRun(runID uint, author string) (*entity.BackupRun, error) {
+	var run entity.BackupRun
+	if err := DB.Omit("log").First(&run, runID).Error; err != nil {
+		return nil, err
+	}
+
+	runCancelsMu.Lock()
+	c, ok := runCancels[runID]
+	if ok && c.by == "" {
+		c.by = author
+	}
+	runCancelsMu.Unlock()
+	if !ok {
+		return nil, ErrBackupRunNotRunning
+	}
+
+	c.cancel()
+	log.Printf("Backup run %d cancelled by %s", runID, author)
+	return &run, nil
+}
+
+// executeCancelCommands runs the on-cancel commands of the profile. The run's context is
+// already cancelled, so they get a context of their own.
+func (e *BackupExecutor) executeCancelCommands(sshClient *SSHClient, profile *entity.BackupProfile, runID uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelCommandTimeout)
+	defer cancel()
+	e.logToDatabase(runID, "INFO", "Executing on-cancel commands")
+	if err := e.executeCommands(sshClient.WithContext(ctx), profile.Commands, "cancel", runID); err != nil {
+		e.logToDatabase(runID, "ERROR", fmt.Sprintf("On-cancel commands failed: %v", err))
+	}
+}
+
+// cleanupCancelledRun removes the complete and partial files a cancelled run wrote to its
+// backup directory, files shared with earlier runs stay. WORM locations keep the complete
+// files, they are locked.
+func (e *BackupExecutor) cleanupCancelledRun(profile *entity.BackupProfile, run *entity.BackupRun, transfer *FileTransferService) {
+	worm := profile.StorageLocation != nil && profile.StorageLocation.WormLockDays > 0
+	if worm {
+		e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Completely written files are kept, the storage location locks them for %d days", profile.StorageLocation.WormLockDays))
+	}
+
+	removed, err := transfer.RemoveWrittenFiles(worm)
+	if err != nil {
+		e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Failed to remove files of the cancelled run, their records are kept: %v", err))
+	}
+	e.logToDatabase(run.ID, "INFO", fmt.Sprintf("Removed %d files of the cancelled run from %s", len(removed), run.LocalBackupPath))
+
+	// Records of files still on storage stay so retention and the UI can remove them later,
+	// references to files of earlier runs hold nothing of this run
+	query := DB.Where("backup_run_id = ?", run.ID)
+	if len(removed) > 0 {
+		query = query.Where("local_path IN ? OR reused_from_run_id IS NOT NULL", removed)
+	} else {
+		query = query.Where("reused_from_run_id IS NOT NULL")
+	}
+	if err := query.Delete(&entity.BackupFile{}).Error; err != nil {
+		log.Printf("Failed to delete file records of cancelled run %d: %v", run.ID, err)
+	}
+
+	var kept []entity.BackupFile
+	if err := DB.Where("backup_run_id = ?", run.ID).Find(&kept).Error; err != nil {
+		log.Printf("Failed to load file records of cancelled run %d: %v", run.ID, err)
+	}
+	run.TotalFiles = len(kept)
+	run.TotalSizeBytes = 0
+	run.ChecksumMismatches = 0
+	for _, file := range kept {
+		run.TotalSizeBytes += file.SizeBytes
+		if file.ChecksumMismatch {
+			run.ChecksumMismatches++
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"path/filepath"
@@ -33,8 +34,8 @@ func (e *BackupExecutor) logToDatabase(runID uint, level, message string) {
 	log.Printf("[%s] %s", level, message)
 }
 
-// ExecuteBackup executes a backup profile
-func (e *BackupExecutor) ExecuteBackup(profileID uint, allowDisabled bool) error {
+// prepareBackup loads the profile and creates its run record
+func (e *BackupExecutor) prepareBackup(profileID uint, allowDisabled bool) (*entity.BackupProfile, *entity.BackupRun, error) {
 	// Load the backup profile with all relations
 	var profile entity.BackupProfile
 	if err := DB.Preload("Server").
@@ -43,15 +44,15 @@ func (e *BackupExecutor) ExecuteBackup(profileID uint, allowDisabled bool) error
 		Preload("Commands").
 		Preload("FileRules").
 		First(&profile, profileID).Error; err != nil {
-		return fmt.Errorf("failed to load backup profile: %v", err)
+		return nil, nil, fmt.Errorf("failed to load backup profile: %v", err)
 	}
 
 	// Check if profile is enabled (unless manually allowed)
 	if !profile.Enabled && !allowDisabled {
-		return fmt.Errorf("backup profile is disabled")
+		return nil, nil, fmt.Errorf("backup profile is disabled")
 	}
 	if profile.StorageLocation != nil && !profile.StorageLocation.Enabled {
-		return fmt.Errorf("storage location is disabled")
+		return nil, nil, fmt.Errorf("storage location is disabled")
 	}
 
 	// Create backup run record
@@ -61,9 +62,20 @@ func (e *BackupExecutor) ExecuteBackup(profileID uint, allowDisabled bool) error
 		StartTime:       time.Now(),
 	}
 	if err := DB.Create(run).Error; err != nil {
-		return fmt.Errorf("failed to create backup run: %v", err)
+		return nil, nil, fmt.Errorf("failed to create backup run: %v", err)
 	}
+	return &profile, run, nil
+}
+
+// runBackup executes a prepared run and records its outcome. The run can be cancelled
+// through ctx or ServiceCancelBackupRun.
+func (e *BackupExecutor) runBackup(ctx context.Context, profile *entity.BackupProfile, run *entity.BackupRun) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	unregister := registerRunCancel(run.ID, cancel)
+	defer unregister()
 
+	profileID := profile.ID
 	e.logToDatabase(run.ID, "INFO", fmt.Sprintf("Starting backup for profile: %s", profile.Name))
 
 	// Send notification for backup started
@@ -71,13 +83,25 @@ func (e *BackupExecutor) ExecuteBackup(profileID uint, allowDisabled bool) error
 		go NotificationSvc.NotifyBackupStarted(profileID, profile.Name)
 	}
 
+	// Make room first when the storage location is below its minimum free space
+	if profile.StorageLocation != nil && profile.StorageLocation.MinFreePercent > 0 {
+		EnforceFreeSpace(profile.StorageLocationID)
+	}
+
 	// Execute backup and update status
-	err := e.executeBackupInternal(&profile, run)
+	err := e.executeBackupInternal(ctx, profile, run)
 
 	// Update run status
 	run.EndTime = time.Now()
 	duration := run.EndTime.Sub(run.StartTime)
-	if err != nil {
+	if err != nil && ctx.Err() != nil {
+		run.Status = "cancelled"
+		run.ErrorMessage = "Cancelled"
+		if by := runCancelledBy(run.ID); by != "" {
+			run.ErrorMessage = fmt.Sprintf("Cancelled by %s", by)
+		}
+		e.logToDatabase(run.ID, "WARNING", run.ErrorMessage)
+	} else if err != nil {
 		run.Status = "failed"
 		run.ErrorMessage = err.Error()
 		e.logToDatabase(run.ID, "ERROR", fmt.Sprintf("Backup failed: %v", err))
@@ -94,7 +118,11 @@ func (e *BackupExecutor) ExecuteBackup(profileID uint, allowDisabled bool) error
 		}
 	} else {
 		run.Status = "completed"
-		e.logToDatabase(run.ID, "INFO", "Backup completed successfully")
+		if run.ChecksumMismatches > 0 {
+			e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Backup completed with %d checksum mismatches", run.ChecksumMismatches))
+		} else {
+			e.logToDatabase(run.ID, "INFO", "Backup completed successfully")
+		}
 
 		// Send success notification
 		if NotificationSvc != nil {
@@ -104,6 +132,8 @@ func (e *BackupExecutor) ExecuteBackup(profileID uint, allowDisabled bool) error
 		// Check storage usage and notify if low
 		go CheckLowStorage()
 	}
+	// Files written by failed runs are locked as well
+	run.LockedUntil = runLockUntil(profile)
 
 	// Update the run record - using Save with the full struct
 	if updateErr := DB.Save(run).Error; updateErr != nil {
@@ -117,16 +147,21 @@ func (e *BackupExecutor) ExecuteBackup(profileID uint, allowDisabled bool) error
 }
 
 // executeBackupInternal performs the actual backup execution
-func (e *BackupExecutor) executeBackupInternal(profile *entity.BackupProfile, run *entity.BackupRun) error {
+func (e *BackupExecutor) executeBackupInternal(ctx context.Context, profile *entity.BackupProfile, run *entity.BackupRun) (err error) {
 	// Create SSH client
 	e.logToDatabase(run.ID, "INFO", fmt.Sprintf("Connecting to server: %s@%s:%d", profile.Server.Username, profile.Server.Host, profile.Server.Port))
-	sshClient, err := NewSSHClient(profile.Server)
+	sshClient, err := NewSSHClientContext(ctx, profile.Server)
 	if err != nil {
 		e.logToDatabase(run.ID, "ERROR", fmt.Sprintf("Failed to create SSH client: %v", err))
 		return fmt.Errorf("failed to create SSH client: %v", err)
 	}
 	defer sshClient.Close()
 	e.logToDatabase(run.ID, "INFO", "SSH connection established")
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			e.executeCancelCommands(sshClient, profile, run.ID)
+		}
+	}()
 
 	// Execute pre-backup commands
 	e.logToDatabase(run.ID, "INFO", "Executing pre-backup commands")
@@ -166,7 +201,12 @@ func (e *BackupExecutor) executeBackupInternal(profile *entity.BackupProfile, ru
 
 	// Transfer files
 	e.logToDatabase(run.ID, "INFO", fmt.Sprintf("Starting file transfer (%d rules)", len(profile.FileRules)))
-	transferService := NewFileTransferService(sshClient, storageBackend, backupDir, run.ID)
+	transferService := NewFileTransferService(ctx, sshClient, storageBackend, backupDir, run.ID)
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			e.cleanupCancelledRun(profile, run, transferService)
+		}
+	}()
 	backupFiles, err := transferService.TransferFiles(profile.FileRules)
 	if err != nil {
 		e.logToDatabase(run.ID, "ERROR", fmt.Sprintf("File transfer failed: %v", err))
@@ -174,12 +214,33 @@ func (e *BackupExecutor) executeBackupInternal(profile *entity.BackupProfile, ru
 	}
 	e.logToDatabase(run.ID, "INFO", fmt.Sprintf("File transfer completed: %d files", len(backupFiles)))
 
-	// Save backup files to database
+	// Save backup files to database, reused files keep the flag of the run that stored them
+	encrypted := writesEncrypted(storageBackend)
 	for i := range backupFiles {
 		backupFiles[i].BackupRunID = run.ID
+		if backupFiles[i].ReusedFromRunID == nil {
+			backupFiles[i].Encrypted = encrypted
+		}
 		if err := DB.Create(&backupFiles[i]).Error; err != nil {
 			log.Printf("Failed to save backup file record: %v", err)
 		}
+		if backupFiles[i].ChecksumMismatch {
+			run.ChecksumMismatches++
+		}
+	}
+	if run.ChecksumMismatches > 0 {
+		e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("%d files differ from the checksum computed on the server", run.ChecksumMismatches))
+	}
+
+	// Store the checksums next to the files
+	if manifest, err := transferService.WriteChecksumManifest(backupFiles); err != nil {
+		e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Failed to write %s: %v", checksumManifestName, err))
+	} else if manifest != nil {
+		manifest.BackupRunID = run.ID
+		manifest.Encrypted = encrypted
+		if err := DB.Create(manifest).Error; err != nil {
+			log.Printf("Failed to save backup file record: %v", err)
+		}
 	}
 
 	// Calculate total size
@@ -198,7 +259,8 @@ func (e *BackupExecutor) executeBackupInternal(profile *entity.BackupProfile, ru
 		return fmt.Errorf("post-backup commands failed: %v", err)
 	}
 
-	return nil
+	// Replicate last, a cancellation still removes the files of the run
+	return e.replicateRun(ctx, profile, run)
 }
 
 // executeCommands executes commands in order for a specific stage (pre/post)
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+
+	"gorm.io/gorm"
+)
+
+var ErrBackupRunHeld = errors.New("backup run is on hold")
+
+// runOnHold reports whether a hold protects the run at the given time
+func runOnHold(run *entity.BackupRun, now time.Time) bool {
+	return run.Held && (run.HoldUntil == nil || run.HoldUntil.After(now))
+}
+
+// heldRunError explains which hold prevents removing a run
+func heldRunError(run *entity.BackupRun) error {
+	if run.HoldUntil != nil {
+		return fmt.Errorf("%w: run %d held by %s until %s: %s", ErrBackupRunHeld, run.ID, run.HoldBy, run.HoldUntil.Format(time.RFC3339), run.HoldReason)
+	}
+	return fmt.Errorf("%w: run %d held by %s: %s", ErrBackupRunHeld, run.ID, run.HoldBy, run.HoldReason)
+}
+
+// activeHolds restricts a run query to runs with a hold that has not expired
+func activeHolds(query *gorm.DB) *gorm.DB {
+	return query.Where("backup_runs.held = ? AND (backup_runs.hold_until IS NULL OR backup_runs.hold_until > ?)", true, time.Now())
+}
+
+// checkNoHeldRuns refuses when one of the runs the query selects is on hold
+func checkNoHeldRuns(query *gorm.DB) error {
+	var runs []entity.BackupRun
+	if err := activeHolds(query).Limit(1).Find(&runs).Error; err != nil {
+		return err
+	}
+	if len(runs) > 0 {
+		return heldRunError(&runs[0])
+	}
+	return nil
+}
+
+// ServiceListHolds returns the runs currently on hold, the most recent hold first
+func ServiceListHolds() ([]entity.BackupRun, error) {
+	var runs []entity.BackupRun
+	if err := activeHolds(DB.Model(&entity.BackupRun{})).Omit("log").Order("held_at DESC").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ServicePlaceHold puts a completed run on hold so neither retention nor a deletion removes
+// it. Placing a hold on a held run replaces it, an until of nil holds until released.
+func ServicePlaceHold(runID uint, reason, author string, until *time.Time) (*entity.BackupRun, error) {
+	var run entity.BackupRun
+	if err := DB.First(&run, runID).Error; err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required")
+	}
+	if run.Status != "completed" {
+		return nil, fmt.Errorf("only completed runs can be held")
+	}
+	if run.RetentionCleanedUp {
+		return nil, fmt.Errorf("the files of this run were already removed by retention")
+	}
+	now := time.Now()
+	if until != nil && !until.After(now) {
+		return nil, fmt.Errorf("hold_until must be in the future")
+	}
+
+	run.Held = true
+	run.HoldReason = reason
+	run.HoldBy = author
+	run.HeldAt = &now
+	run.HoldUntil = until
+	if err := DB.Model(&run).Select("held", "hold_reason", "hold_by", "held_at", "hold_until").Updates(&run).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Backup run %d held by %s: %s", run.ID, author, reason)
+	return &run, nil
+}
+
+// ServiceReleaseHold removes the hold of a run, retention applies to it again
+func ServiceReleaseHold(runID uint, author string) (*entity.BackupRun, error) {
+	var run entity.BackupRun
+	if err := DB.First(&run, runID).Error; err != nil {
+		return nil, err
+	}
+	if !run.Held {
+		return nil, fmt.Errorf("backup run is not on hold")
+	}
+	if err := DB.Model(&run).Updates(clearedHold()).Error; err != nil {
+		return nil, err
+	}
+	run.Held, run.HoldReason, run.HoldBy, run.HeldAt, run.HoldUntil = false, "", "", nil, nil
+	log.Printf("Hold on backup run %d released by %s", run.ID, author)
+	return &run, nil
+}
+
+// releaseExpiredHolds clears holds whose expiry has passed
+func releaseExpiredHolds() {
+	result := DB.Model(&entity.BackupRun{}).Where("held = ? AND hold_until IS NOT NULL AND hold_until <= ?", true, time.Now()).
+		Updates(clearedHold())
+	if result.Error != nil {
+		log.Printf("Failed to release expired holds: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Released %d expired holds", result.RowsAffected)
+	}
+}
+
+// clearedHold holds the column values of a run without a hold
+func clearedHold() map[string]interface{} {
+	return map[string]interface{}{"held": false, "hold_reason": "", "hold_by": "", "held_at": nil, "hold_until": nil}
+}
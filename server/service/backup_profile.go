@@ -1,6 +1,8 @@
 package service
 
 import (
+	"strings"
+
 	"backapp-server/entity"
 
 	"gorm.io/gorm"
@@ -24,6 +26,12 @@ func ServiceListBackupProfiles() ([]entity.BackupProfile, error) {
 }
 
 func ServiceCreateBackupProfile(input *entity.BackupProfile) (*entity.BackupProfile, error) {
+	if err := retentionPolicyForProfile(input).validate(); err != nil {
+		return nil, err
+	}
+	if err := validateConcurrencyPolicy(input.ConcurrencyPolicy); err != nil {
+		return nil, err
+	}
 	if err := DB.Create(input).Error; err != nil {
 		return nil, err
 	}
@@ -56,7 +64,25 @@ func ServiceUpdateBackupProfile(id uint, input *entity.BackupProfile) (*entity.B
 	profile.StorageLocationID = input.StorageLocationID
 	profile.NamingRuleID = input.NamingRuleID
 	profile.ScheduleCron = input.ScheduleCron
+	profile.VerifyCron = input.VerifyCron
+	profile.RestoreDrillCron = input.RestoreDrillCron
+	profile.RestoreDrillServerID = input.RestoreDrillServerID
+	profile.RestoreDrillPath = strings.TrimSpace(input.RestoreDrillPath)
+	profile.RestoreDrillCommand = input.RestoreDrillCommand
 	profile.RetentionDays = input.RetentionDays
+	profile.KeepLast = input.KeepLast
+	profile.KeepDaily = input.KeepDaily
+	profile.KeepWeekly = input.KeepWeekly
+	profile.KeepMonthly = input.KeepMonthly
+	profile.KeepYearly = input.KeepYearly
+	profile.MaxSizeBytes = input.MaxSizeBytes
+	if err := retentionPolicyForProfile(profile).validate(); err != nil {
+		return nil, err
+	}
+	if err := validateConcurrencyPolicy(input.ConcurrencyPolicy); err != nil {
+		return nil, err
+	}
+	profile.ConcurrencyPolicy = input.ConcurrencyPolicy
 	profile.Enabled = input.Enabled
 	if err := DB.Save(profile).Error; err != nil {
 		return nil, err
@@ -72,10 +98,18 @@ func ServiceUpdateBackupProfile(id uint, input *entity.BackupProfile) (*entity.B
 }
 
 func ServiceDeleteBackupProfile(id uint) error {
+	// Held and locked runs would be deleted together with the profile
+	if err := checkRunsRemovable("backup_profile_id = ?", id); err != nil {
+		return err
+	}
+
 	// Unschedule first
 	scheduler := GetScheduler()
 	scheduler.UnscheduleProfile(id)
 
+	if err := deleteScopesForProfiles(id); err != nil {
+		return err
+	}
 	return DB.Delete(&entity.BackupProfile{}, id).Error
 }
 
@@ -95,6 +129,7 @@ func ServiceDuplicateBackupProfile(id uint) (*entity.BackupProfile, error) {
 	duplicate.Commands = nil
 	duplicate.FileRules = nil
 	duplicate.BackupRuns = nil
+	duplicate.Replicas = nil
 	duplicate.Server = nil
 	duplicate.StorageLocation = nil
 	duplicate.NamingRule = nil
@@ -124,6 +159,17 @@ func ServiceDuplicateBackupProfile(id uint) (*entity.BackupProfile, error) {
 		}
 	}
 
+	// Duplicate replica locations
+	for _, replica := range original.Replicas {
+		newReplica := entity.BackupProfileReplica{
+			BackupProfileID:   duplicate.ID,
+			StorageLocationID: replica.StorageLocationID,
+		}
+		if err := DB.Create(&newReplica).Error; err != nil {
+			return nil, err
+		}
+	}
+
 	return &duplicate, nil
 }
 
@@ -135,6 +181,7 @@ func ServiceGetBackupProfileFull(id uint) (*entity.BackupProfile, error) {
 		Preload("NamingRule").
 		Preload("Commands").
 		Preload("FileRules").
+		Preload("Replicas.StorageLocation").
 		First(&profile, id).Error; err != nil {
 		return nil, err
 	}
@@ -36,7 +36,7 @@ func ServiceListBackupRuns(profileID *int, status string) ([]entity.BackupRun, e
 
 func ServiceGetBackupRun(id uint) (*entity.BackupRun, error) {
 	var run entity.BackupRun
-	if err := DB.First(&run, id).Error; err != nil {
+	if err := DB.Preload("Replicas").First(&run, id).Error; err != nil {
 		return nil, err
 	}
 	return &run, nil
@@ -90,6 +90,15 @@ func ServiceDeleteBackupFile(fileID uint) error {
 	if err := DB.First(&file, fileID).Error; err != nil {
 		return err
 	}
+	var runs []entity.BackupRun
+	if err := DB.Where("id = ?", file.BackupRunID).Limit(1).Find(&runs).Error; err != nil {
+		return err
+	}
+	if len(runs) > 0 {
+		if err := checkRunRemovable(&runs[0]); err != nil {
+			return err
+		}
+	}
 
 	location, err := GetStorageLocationForRun(file.BackupRunID)
 	if err != nil {
@@ -101,8 +110,12 @@ func ServiceDeleteBackupFile(fileID uint) error {
 	}
 	defer backend.Close()
 
-	// Delete the file from storage if it exists
-	if file.LocalPath != "" {
+	// Delete the file from storage if it exists and no other run still references it
+	inUse, err := backupDataInUse(file.LocalPath, file.ID)
+	if err != nil {
+		return err
+	}
+	if file.LocalPath != "" && !inUse {
 		parentDir := filepath.Dir(file.LocalPath)
 		if err := backend.Remove(file.LocalPath); err == nil && backend.IsLocal() {
 			// Clean up empty parent directories
@@ -110,6 +123,11 @@ func ServiceDeleteBackupFile(fileID uint) error {
 		}
 	}
 
+	var run entity.BackupRun
+	if err := DB.First(&run, file.BackupRunID).Error; err == nil {
+		removeReplicaFiles(&run, []entity.BackupFile{file})
+	}
+
 	// Mark as deleted
 	now := time.Now()
 	file.Deleted = true
@@ -124,6 +142,9 @@ func ServiceDeleteBackupRun(runID uint) error {
 	if err := DB.First(&run, runID).Error; err != nil {
 		return err
 	}
+	if err := checkRunRemovable(&run); err != nil {
+		return err
+	}
 
 	location, err := GetStorageLocationForRun(runID)
 	if err != nil {
@@ -143,13 +164,29 @@ func ServiceDeleteBackupRun(runID uint) error {
 
 	// Track directories for cleanup
 	dirsToCleanup := make(map[string]bool)
+	fileIDs := make([]uint, 0, len(files))
+	for _, file := range files {
+		fileIDs = append(fileIDs, file.ID)
+	}
 
-	// Delete files from disk
+	// Delete files from disk, keeping those that later incremental runs still reference
 	for _, file := range files {
-		if file.LocalPath != "" {
-			dirsToCleanup[filepath.Dir(file.LocalPath)] = true
-			backend.Remove(file.LocalPath) // Ignore errors, best effort cleanup
+		if file.LocalPath == "" {
+			continue
+		}
+		inUse, err := backupDataInUse(file.LocalPath, fileIDs...)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			continue
 		}
+		dirsToCleanup[filepath.Dir(file.LocalPath)] = true
+		backend.Remove(file.LocalPath) // Ignore errors, best effort cleanup
+	}
+	// Runs that reused all files from earlier runs leave an empty backup directory
+	if run.LocalBackupPath != "" {
+		dirsToCleanup[run.LocalBackupPath] = true
 	}
 
 	// Clean up empty directories
@@ -158,11 +195,15 @@ func ServiceDeleteBackupRun(runID uint) error {
 			removeEmptyDirs(dir)
 		}
 	}
+	removeReplicaFiles(&run, files)
 
-	// Delete dependent records: logs and files
+	// Delete dependent records: logs, replicas and files
 	if err := DB.Where("backup_run_id = ?", runID).Delete(&entity.BackupRunLog{}).Error; err != nil {
 		return err
 	}
+	if err := DB.Where("backup_run_id = ?", runID).Delete(&entity.BackupRunReplica{}).Error; err != nil {
+		return err
+	}
 	if err := DB.Where("backup_run_id = ?", runID).Delete(&entity.BackupFile{}).Error; err != nil {
 		return err
 	}
@@ -201,16 +242,40 @@ func ServiceGetBackupRunDeletionImpact(runID uint) (*DeletionImpact, error) {
 		BackupFiles: len(files),
 	}
 
+	fileIDs := make([]uint, 0, len(files))
+	for _, file := range files {
+		fileIDs = append(fileIDs, file.ID)
+	}
 	for _, file := range files {
 		impact.TotalSizeBytes += file.SizeBytes
-		if file.LocalPath != "" {
-			impact.FilePaths = append(impact.FilePaths, file.LocalPath)
+		if file.LocalPath == "" {
+			continue
 		}
+		// Files still referenced by other incremental runs are kept
+		if inUse, err := backupDataInUse(file.LocalPath, fileIDs...); err != nil || inUse {
+			continue
+		}
+		impact.FilePaths = append(impact.FilePaths, file.LocalPath)
 	}
 
 	return impact, nil
 }
 
+// backupDataInUse reports whether a file record other than the excluded ones still
+// references the stored data at localPath. Incremental runs share unchanged files.
+func backupDataInUse(localPath string, excludeIDs ...uint) (bool, error) {
+	if localPath == "" {
+		return false, nil
+	}
+	var count int64
+	if err := DB.Model(&entity.BackupFile{}).
+		Where("local_path = ? AND deleted = ? AND id NOT IN ?", localPath, false, append(excludeIDs, 0)).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func GetStorageLocationForRun(runID uint) (*entity.StorageLocation, error) {
 	var run entity.BackupRun
 	if err := DB.First(&run, runID).Error; err != nil {
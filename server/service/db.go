@@ -4,6 +4,7 @@ import (
 	"backapp-server/entity"
 	"log"
 	"os"
+	"strings"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -39,6 +40,21 @@ func InitDB(dataSourceName string) {
 		&entity.BackupRun{},
 		&entity.BackupFile{},
 		&entity.BackupRunLog{},
+		&entity.BackupProfileReplica{},
+		&entity.BackupRunReplica{},
+		&entity.BackupVerification{},
+		&entity.BackupVerificationResult{},
+		&entity.RestoreJob{},
+		&entity.RestoreJobLog{},
+		&entity.RestoreDrill{},
+		&entity.BackupJob{},
+		&entity.EncryptionKey{},
+		&entity.DedupChunk{},
+		&entity.KnownHost{},
+		&entity.User{},
+		&entity.UserScope{},
+		&entity.APIToken{},
+		&entity.Session{},
 		&entity.PushSubscription{},
 		&entity.NotificationPreference{},
 		&entity.VAPIDKeys{},
@@ -47,10 +63,43 @@ func InitDB(dataSourceName string) {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	// Earlier versions only allowed pre- and post-backup commands
+	if err := migrateCommandStages(); err != nil {
+		log.Fatalf("Failed to migrate command stages: %v", err)
+	}
+
+	// Users with scopes were restricted implicitly before the flag existed
+	if err := DB.Model(&entity.User{}).
+		Where("restricted = ? AND id IN (?)", false, DB.Model(&entity.UserScope{}).Select("user_id")).
+		Update("restricted", true).Error; err != nil {
+		log.Fatalf("Failed to migrate user scopes: %v", err)
+	}
+
+	// Encrypt credentials stored in plaintext by earlier versions
+	if err := migrateSecrets(); err != nil {
+		log.Fatalf("Failed to encrypt stored credentials: %v", err)
+	}
+
 	// Initialize default storage locations and naming rules
 	initializeDefaults()
 }
 
+// migrateCommandStages widens the run_stage check of tables created before on-cancel commands.
+// SQLite cannot alter a check, the migrator rebuilds the table.
+func migrateCommandStages() error {
+	var ddl string
+	if err := DB.Raw("SELECT sql FROM sqlite_master WHERE type = ? AND name = ?", "table", "commands").Row().Scan(&ddl); err != nil {
+		return err
+	}
+	if strings.Contains(ddl, "'cancel'") {
+		return nil
+	}
+	if err := DB.Migrator().DropConstraint(&entity.Command{}, "chk_commands_run_stage"); err != nil {
+		return err
+	}
+	return DB.Migrator().CreateConstraint(&entity.Command{}, "chk_commands_run_stage")
+}
+
 func initializeDefaults() {
 	// Check if any storage locations exist
 	var storageCount int64
@@ -120,6 +169,14 @@ func initializeDefaults() {
 }
 
 func ResetDatabase() {
+	// Keep accounts, sessions and API tokens so the client stays signed in
+	var users []entity.User
+	var sessions []entity.Session
+	var tokens []entity.APIToken
+	DB.Find(&users)
+	DB.Find(&sessions)
+	DB.Find(&tokens)
+
 	sqlDB, err := DB.DB()
 	if err != nil {
 		log.Fatalf("Failed to get raw database connection: %v", err)
@@ -137,4 +194,20 @@ func ResetDatabase() {
 
 	// Re-initialize the database
 	InitDB("app.db")
+
+	for i := range users {
+		if err := DB.Create(&users[i]).Error; err != nil {
+			log.Printf("Warning: Failed to restore user %s: %v", users[i].Username, err)
+		}
+	}
+	for i := range sessions {
+		if err := DB.Create(&sessions[i]).Error; err != nil {
+			log.Printf("Warning: Failed to restore session: %v", err)
+		}
+	}
+	for i := range tokens {
+		if err := DB.Create(&tokens[i]).Error; err != nil {
+			log.Printf("Warning: Failed to restore API token %s: %v", tokens[i].Name, err)
+		}
+	}
 }
@@ -0,0 +1,563 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Deduplicated storage locations keep file contents as content-addressed chunks below
+// <base>/.chunks/<first two hex digits>/<sha256> and write a small manifest in place of
+// every backup file:
+//
+//	magic line | JSON {"size": ..., "chunks": [{"hash": ..., "size": ...}, ...]}
+//
+// Chunk boundaries are content-defined by a gear rolling hash, so data shared by files of
+// different runs, profiles or servers is stored once even when it moves within a file.
+// How many manifests reference a chunk is tracked in the database, retention removes
+// chunks that are no longer referenced and not held by a file that is still being
+// written. Files without a manifest header are passed through, so locations that enable
+// deduplication later keep serving their existing backups.
+const (
+	dedupMagic        = "BKAPDDP1\n"
+	dedupChunkDir     = ".chunks"
+	dedupMinChunkSize = 256 << 10
+	dedupMaxChunkSize = 4 << 20
+	dedupChunkMask    = 1<<20 - 1 // cut points every 1 MiB on average
+)
+
+// dedupGear maps bytes to the random values of the rolling hash. It is derived from a
+// fixed seed because changing it would move all chunk boundaries.
+var dedupGear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x4241434b41505031)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// dedupChunkLock keeps garbage collection from removing a chunk while a writer decides to
+// reuse it. Writers share the lock, garbage collection takes it exclusively per chunk.
+var dedupChunkLock sync.RWMutex
+
+// dedupHeldChunks counts how often the files still being written use a chunk. Their
+// manifests only reference the chunks on Close, until then garbage collection skips
+// held chunks however long the write takes.
+var (
+	dedupHeldLock   sync.Mutex
+	dedupHeldChunks = make(map[dedupChunkKey]int)
+)
+
+type dedupChunkKey struct {
+	locationID uint
+	hash       string
+}
+
+func holdDedupChunk(locationID uint, hash string) {
+	dedupHeldLock.Lock()
+	defer dedupHeldLock.Unlock()
+	dedupHeldChunks[dedupChunkKey{locationID, hash}]++
+}
+
+func releaseDedupChunks(locationID uint, chunks []dedupChunkEntry) {
+	dedupHeldLock.Lock()
+	defer dedupHeldLock.Unlock()
+	for _, chunk := range chunks {
+		key := dedupChunkKey{locationID, chunk.Hash}
+		if dedupHeldChunks[key] <= 1 {
+			delete(dedupHeldChunks, key)
+		} else {
+			dedupHeldChunks[key]--
+		}
+	}
+}
+
+func dedupChunkHeld(locationID uint, hash string) bool {
+	dedupHeldLock.Lock()
+	defer dedupHeldLock.Unlock()
+	return dedupHeldChunks[dedupChunkKey{locationID, hash}] > 0
+}
+
+type dedupManifest struct {
+	Size   int64             `json:"size"`
+	Chunks []dedupChunkEntry `json:"chunks"`
+}
+
+type dedupChunkEntry struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// dedupStorageBackend stores files written through it as chunks and manifests and
+// reassembles them on read.
+type dedupStorageBackend struct {
+	StorageBackend
+	location  *entity.StorageLocation
+	chunkDirs map[string]bool
+}
+
+// dedupFileInfo reports the size of the original file instead of the manifest.
+type dedupFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi *dedupFileInfo) Size() int64 { return fi.size }
+
+// wrapDedup adds the deduplication layer when the location has deduplication enabled or
+// still holds chunks of files written while it was enabled.
+func wrapDedup(location *entity.StorageLocation, backend StorageBackend) (StorageBackend, error) {
+	if location == nil || location.ID == 0 || DB == nil {
+		return backend, nil
+	}
+	if !location.Dedup {
+		var count int64
+		if err := DB.Model(&entity.DedupChunk{}).Where("storage_location_id = ?", location.ID).Limit(1).Count(&count).Error; err != nil {
+			backend.Close()
+			return nil, fmt.Errorf("failed to load deduplication chunks: %v", err)
+		}
+		if count == 0 {
+			return backend, nil
+		}
+	}
+	return &dedupStorageBackend{StorageBackend: backend, location: location, chunkDirs: make(map[string]bool)}, nil
+}
+
+func dedupChunkPath(location *entity.StorageLocation, hash string) string {
+	return JoinStoragePath(location, StorageBasePath(location), dedupChunkDir, hash[:2], hash)
+}
+
+func (b *dedupStorageBackend) OpenWriter(filePath string) (io.WriteCloser, error) {
+	if !b.location.Dedup {
+		return b.StorageBackend.OpenWriter(filePath)
+	}
+	return &dedupWriter{backend: b, path: filePath}, nil
+}
+
+func (b *dedupStorageBackend) OpenReader(filePath string) (io.ReadCloser, error) {
+	return b.openReader(filePath, b.StorageBackend.OpenReader)
+}
+
+// OpenReaderAllowPlaintext reads a file stored before the location enabled encryption.
+func (b *dedupStorageBackend) OpenReaderAllowPlaintext(filePath string) (io.ReadCloser, error) {
+	return b.openReader(filePath, func(path string) (io.ReadCloser, error) {
+		return openReaderAllowPlaintext(b.StorageBackend, path)
+	})
+}
+
+func (b *dedupStorageBackend) openReader(filePath string, open func(string) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	reader, err := open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(reader)
+	header, _ := buffered.Peek(len(dedupMagic))
+	if string(header) != dedupMagic {
+		return struct {
+			io.Reader
+			io.Closer
+		}{buffered, reader}, nil
+	}
+	manifest, err := decodeDedupManifest(buffered)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", filePath, err)
+	}
+	return &dedupReader{backend: b, chunks: manifest.Chunks}, nil
+}
+
+func (b *dedupStorageBackend) Stat(filePath string) (os.FileInfo, error) {
+	info, err := b.StorageBackend.Stat(filePath)
+	if err != nil || info.IsDir() || info.Size() < int64(len(dedupMagic)) {
+		return info, err
+	}
+	manifest, err := b.readManifest(filePath)
+	if err != nil || manifest == nil {
+		return info, err
+	}
+	return &dedupFileInfo{FileInfo: info, size: manifest.Size}, nil
+}
+
+// Remove deletes the manifest and releases its chunks. The chunks themselves are removed
+// by garbage collection once no manifest references them.
+func (b *dedupStorageBackend) Remove(filePath string) error {
+	manifest, _ := b.readManifest(filePath)
+	if err := b.StorageBackend.Remove(filePath); err != nil {
+		return err
+	}
+	if manifest != nil {
+		if err := adjustDedupRefs(b.location.ID, manifest.Chunks, -1); err != nil {
+			log.Printf("Failed to release chunks of %s: %v", filePath, err)
+		}
+	}
+	return nil
+}
+
+// readManifest returns the manifest stored at filePath, or nil for regular files.
+// Manifests stored before the location enabled encryption are read as well.
+func (b *dedupStorageBackend) readManifest(filePath string) (*dedupManifest, error) {
+	reader, err := openReaderAllowPlaintext(b.StorageBackend, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	buffered := bufio.NewReader(reader)
+	header, _ := buffered.Peek(len(dedupMagic))
+	if string(header) != dedupMagic {
+		return nil, nil
+	}
+	return decodeDedupManifest(buffered)
+}
+
+func decodeDedupManifest(reader io.Reader) (*dedupManifest, error) {
+	if _, err := io.CopyN(io.Discard, reader, int64(len(dedupMagic))); err != nil {
+		return nil, err
+	}
+	var manifest dedupManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	for _, chunk := range manifest.Chunks {
+		if len(chunk.Hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid chunk hash %q", chunk.Hash)
+		}
+	}
+	return &manifest, nil
+}
+
+// storeChunk writes a chunk unless the location already holds it. The chunk is held
+// for the writer until its manifest is stored or the write is aborted.
+func (b *dedupStorageBackend) storeChunk(hash string, data []byte) error {
+	dedupChunkLock.RLock()
+	defer dedupChunkLock.RUnlock()
+
+	result := DB.Model(&entity.DedupChunk{}).
+		Where("storage_location_id = ? AND hash = ?", b.location.ID, hash).
+		Update("updated_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		holdDedupChunk(b.location.ID, hash)
+		return nil
+	}
+
+	chunkPath := dedupChunkPath(b.location, hash)
+	chunkDir := storageDir(b.location, chunkPath)
+	if !b.chunkDirs[chunkDir] {
+		if err := b.StorageBackend.EnsureDir(chunkDir); err != nil {
+			return err
+		}
+		b.chunkDirs[chunkDir] = true
+	}
+	writer, err := b.StorageBackend.OpenWriter(chunkPath)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		abortWriter(writer, err)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	chunk := &entity.DedupChunk{StorageLocationID: b.location.ID, Hash: hash, SizeBytes: int64(len(data))}
+	if err := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(chunk).Error; err != nil {
+		return err
+	}
+	holdDedupChunk(b.location.ID, hash)
+	return nil
+}
+
+// adjustDedupRefs adds delta to the reference count of every chunk occurrence.
+func adjustDedupRefs(locationID uint, chunks []dedupChunkEntry, delta int64) error {
+	counts := make(map[string]int64)
+	for _, chunk := range chunks {
+		counts[chunk.Hash] += delta
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for hash, count := range counts {
+			if err := tx.Model(&entity.DedupChunk{}).
+				Where("storage_location_id = ? AND hash = ?", locationID, hash).
+				Updates(map[string]interface{}{
+					"ref_count":  gorm.Expr("ref_count + ?", count),
+					"updated_at": time.Now(),
+				}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dedupWriter splits written data into content-defined chunks and stores the manifest on Close.
+type dedupWriter struct {
+	backend  *dedupStorageBackend
+	path     string
+	buf      []byte
+	scanned  int
+	hash     uint64
+	manifest dedupManifest
+	err      error
+}
+
+func (w *dedupWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for w.scanned < len(w.buf) {
+		w.hash = (w.hash << 1) + dedupGear[w.buf[w.scanned]]
+		w.scanned++
+		if (w.scanned >= dedupMinChunkSize && w.hash&dedupChunkMask == 0) || w.scanned >= dedupMaxChunkSize {
+			if err := w.emit(w.scanned); err != nil {
+				w.err = err
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// emit stores the first size buffered bytes as a chunk.
+func (w *dedupWriter) emit(size int) error {
+	data := w.buf[:size]
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if err := w.backend.storeChunk(hash, data); err != nil {
+		return fmt.Errorf("failed to store chunk: %v", err)
+	}
+	w.manifest.Chunks = append(w.manifest.Chunks, dedupChunkEntry{Hash: hash, Size: int64(size)})
+	w.manifest.Size += int64(size)
+	w.buf = append(w.buf[:0], w.buf[size:]...)
+	w.scanned = 0
+	w.hash = 0
+	return nil
+}
+
+// Abort stores no manifest, chunks already stored are unreferenced and garbage collected
+func (w *dedupWriter) Abort(err error) error {
+	w.err = err
+	w.buf = nil
+	w.release()
+	return nil
+}
+
+// release lets garbage collection consider the chunks of the file again, once they are
+// referenced by its manifest or the file was abandoned.
+func (w *dedupWriter) release() {
+	releaseDedupChunks(w.backend.location.ID, w.manifest.Chunks)
+	w.manifest.Chunks = nil
+}
+
+func (w *dedupWriter) Close() error {
+	defer w.release()
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) > 0 {
+		if err := w.emit(len(w.buf)); err != nil {
+			return err
+		}
+	}
+
+	// An overwritten manifest releases its chunks
+	previous, _ := w.backend.readManifest(w.path)
+
+	var encoded bytes.Buffer
+	encoded.WriteString(dedupMagic)
+	if err := json.NewEncoder(&encoded).Encode(&w.manifest); err != nil {
+		return err
+	}
+	writer, err := w.backend.StorageBackend.OpenWriter(w.path)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(encoded.Bytes()); err != nil {
+		abortWriter(writer, err)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if err := adjustDedupRefs(w.backend.location.ID, w.manifest.Chunks, 1); err != nil {
+		return fmt.Errorf("failed to reference chunks: %v", err)
+	}
+	if previous != nil {
+		if err := adjustDedupRefs(w.backend.location.ID, previous.Chunks, -1); err != nil {
+			log.Printf("Failed to release chunks of overwritten %s: %v", w.path, err)
+		}
+	}
+	return nil
+}
+
+// dedupReader reads the chunks of a manifest in order and verifies their hashes.
+type dedupReader struct {
+	backend *dedupStorageBackend
+	chunks  []dedupChunkEntry
+	current io.ReadCloser
+	hasher  hash.Hash
+}
+
+func (r *dedupReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			// Chunks may predate encryption of the location, their hash authenticates them
+			reader, err := openReaderAllowPlaintext(r.backend.StorageBackend, dedupChunkPath(r.backend.location, r.chunks[0].Hash))
+			if err != nil {
+				return 0, fmt.Errorf("failed to open chunk %s: %v", r.chunks[0].Hash, err)
+			}
+			r.current = reader
+			r.hasher = sha256.New()
+		}
+		n, err := r.current.Read(p)
+		r.hasher.Write(p[:n])
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if hex.EncodeToString(r.hasher.Sum(nil)) != r.chunks[0].Hash {
+				return n, fmt.Errorf("chunk %s is corrupted", r.chunks[0].Hash)
+			}
+			r.chunks = r.chunks[1:]
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (r *dedupReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+// DedupStats returns the size of all files stored in a deduplicated location, the size of
+// the distinct chunks holding them and the number of chunks.
+func DedupStats(locationID uint) (logical int64, physical int64, chunks int64, err error) {
+	var result struct {
+		Logical  int64
+		Physical int64
+		Chunks   int64
+	}
+	err = DB.Model(&entity.DedupChunk{}).
+		Select("COALESCE(SUM(size_bytes * ref_count), 0) AS logical, COALESCE(SUM(size_bytes), 0) AS physical, COUNT(*) AS chunks").
+		Where("storage_location_id = ?", locationID).
+		Scan(&result).Error
+	return result.Logical, result.Physical, result.Chunks, err
+}
+
+// collectDedupGarbage removes chunks that no manifest references. Chunks held by files
+// that are still being written are skipped.
+func collectDedupGarbage(location *entity.StorageLocation) (int, int64, error) {
+	var chunks []entity.DedupChunk
+	if err := DB.Where("storage_location_id = ? AND ref_count <= 0", location.ID).
+		Find(&chunks).Error; err != nil {
+		return 0, 0, err
+	}
+	if len(chunks) == 0 {
+		return 0, 0, nil
+	}
+
+	backend, err := newBaseStorageBackend(location)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer backend.Close()
+
+	removed := 0
+	var freed int64
+	for _, chunk := range chunks {
+		dedupChunkLock.Lock()
+		if dedupChunkHeld(location.ID, chunk.Hash) {
+			dedupChunkLock.Unlock()
+			continue
+		}
+		result := DB.Where("id = ? AND ref_count <= 0", chunk.ID).
+			Delete(&entity.DedupChunk{})
+		if result.Error == nil && result.RowsAffected > 0 {
+			chunkPath := dedupChunkPath(location, chunk.Hash)
+			if err := backend.Remove(chunkPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove chunk %s: %v", chunkPath, err)
+			}
+			if backend.IsLocal() {
+				removeEmptyDirs(filepath.Dir(chunkPath))
+			}
+			removed++
+			freed += chunk.SizeBytes
+		}
+		dedupChunkLock.Unlock()
+		if result.Error != nil {
+			return removed, freed, result.Error
+		}
+	}
+	return removed, freed, nil
+}
+
+// purgeDedupChunks removes all remaining chunks of a storage location that is deleted.
+func purgeDedupChunks(location *entity.StorageLocation) error {
+	if _, _, err := collectDedupGarbage(location); err != nil {
+		log.Printf("Failed to remove chunks of storage location %s: %v", location.Name, err)
+	}
+	return DB.Where("storage_location_id = ?", location.ID).Delete(&entity.DedupChunk{}).Error
+}
+
+// moveDedupChunkStore moves the chunk store of a local location whose base path changed.
+func moveDedupChunkStore(oldBasePath, newBasePath string) error {
+	oldDir := filepath.Join(oldBasePath, dedupChunkDir)
+	if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+		return nil
+	}
+	newDir := filepath.Join(newBasePath, dedupChunkDir)
+	if err := os.MkdirAll(newBasePath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", newBasePath, err)
+	}
+	if err := os.Rename(oldDir, newDir); err == nil {
+		return nil
+	}
+	// Rename fails across devices, copy the chunks instead
+	err := filepath.Walk(oldDir, func(src string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(newDir, strings.TrimPrefix(src, oldDir))
+		if info.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		return copyFile(src, dst)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move chunk store %s to %s: %w", oldDir, newDir, err)
+	}
+	return os.RemoveAll(oldDir)
+}
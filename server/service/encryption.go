@@ -0,0 +1,532 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"backapp-server/entity"
+
+	"gorm.io/gorm"
+)
+
+// Encrypted files start with a header followed by AES-256-GCM sealed chunks:
+//
+//	magic (8) | key version (4, big endian) | base nonce (12) | chunk... | final chunk
+//
+// Every chunk holds encryptionChunkSize bytes of plaintext except the final one,
+// which may be shorter or empty. The chunk nonce is the base nonce XOR the chunk
+// counter, and the counter plus a final-chunk flag are authenticated as additional
+// data, so reordered, truncated or extended files fail to decrypt.
+const (
+	encryptionMagic          = "BKAPENC1"
+	encryptionKeySize        = 32
+	encryptionNonceSize      = 12
+	encryptionHeaderSize     = len(encryptionMagic) + 4 + encryptionNonceSize
+	encryptionChunkSize      = 64 << 10
+	encryptionTagSize        = 16
+	encryptionRecoveryPrefix = "BKREC1-"
+)
+
+var errNotEncrypted = errors.New("file is not encrypted or its encryption header is truncated")
+
+// encryptedStorageBackend transparently encrypts files written to and decrypts files
+// read from the wrapped backend. While encryption is enabled, files without an encryption
+// header fail to open. Files stored before encryption was enabled are recorded as
+// unencrypted and read through OpenReaderAllowPlaintext, so locations that enable
+// encryption later keep serving their existing backups.
+type encryptedStorageBackend struct {
+	StorageBackend
+	keys   map[uint32][]byte
+	active uint32
+}
+
+// encryptedFileInfo reports the plaintext size of an encrypted file.
+type encryptedFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi *encryptedFileInfo) Size() int64 { return fi.size }
+
+// wrapEncryption adds the encryption layer when the location has encryption enabled or
+// still holds keys for previously encrypted files.
+func wrapEncryption(location *entity.StorageLocation, backend StorageBackend) (StorageBackend, error) {
+	if location == nil || location.ID == 0 || DB == nil {
+		return backend, nil
+	}
+	keys, err := loadEncryptionKeys(location.ID)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	if len(keys) == 0 && !location.Encrypted {
+		return backend, nil
+	}
+	if len(keys) == 0 {
+		key, err := ServiceRotateEncryptionKey(location.ID)
+		if err != nil {
+			backend.Close()
+			return nil, err
+		}
+		keys = []entity.EncryptionKey{*key}
+	}
+
+	wrapped := &encryptedStorageBackend{StorageBackend: backend, keys: make(map[uint32][]byte)}
+	for _, key := range keys {
+		raw, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil || len(raw) != encryptionKeySize {
+			backend.Close()
+			return nil, fmt.Errorf("invalid encryption key version %d for storage location %d", key.Version, location.ID)
+		}
+		wrapped.keys[key.Version] = raw
+		if key.Active && location.Encrypted {
+			wrapped.active = key.Version
+		}
+	}
+	if location.Encrypted && wrapped.active == 0 {
+		backend.Close()
+		return nil, fmt.Errorf("no active encryption key for storage location %d", location.ID)
+	}
+	return wrapped, nil
+}
+
+func loadEncryptionKeys(locationID uint) ([]entity.EncryptionKey, error) {
+	var keys []entity.EncryptionKey
+	if err := DB.Where("storage_location_id = ?", locationID).Order("version").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %v", err)
+	}
+	return keys, nil
+}
+
+// ServiceListEncryptionKeys returns the key versions of a storage location without key material.
+func ServiceListEncryptionKeys(locationID uint) ([]entity.EncryptionKey, error) {
+	if _, err := ServiceGetStorageLocation(locationID); err != nil {
+		return nil, err
+	}
+	return loadEncryptionKeys(locationID)
+}
+
+// ServiceRotateEncryptionKey creates a new active key for a storage location. New files are
+// encrypted with it while existing files stay readable with their original key version.
+func ServiceRotateEncryptionKey(locationID uint) (*entity.EncryptionKey, error) {
+	raw := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %v", err)
+	}
+	sum := sha256.Sum256(raw)
+
+	key := &entity.EncryptionKey{
+		StorageLocationID: locationID,
+		Key:               base64.StdEncoding.EncodeToString(raw),
+		Fingerprint:       hex.EncodeToString(sum[:8]),
+		Active:            true,
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var latest entity.EncryptionKey
+		if err := tx.Where("storage_location_id = ?", locationID).Order("version DESC").Limit(1).Find(&latest).Error; err != nil {
+			return err
+		}
+		key.Version = latest.Version + 1
+		if err := tx.Model(&entity.EncryptionKey{}).
+			Where("storage_location_id = ?", locationID).
+			Update("active", false).Error; err != nil {
+			return err
+		}
+		return tx.Create(key).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store encryption key: %v", err)
+	}
+	return key, nil
+}
+
+// ServiceGetRecoveryKey exports all key versions of a storage location as a single
+// recovery key that can decrypt its files without the database.
+func ServiceGetRecoveryKey(locationID uint) (string, error) {
+	keys, err := ServiceListEncryptionKeys(locationID)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("storage location has no encryption keys")
+	}
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		raw, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil || len(raw) != encryptionKeySize {
+			return "", fmt.Errorf("invalid encryption key version %d", key.Version)
+		}
+		binary.Write(&buf, binary.BigEndian, key.Version)
+		buf.Write(raw)
+	}
+	return encryptionRecoveryPrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// parseRecoveryKey decodes a recovery key into its key versions.
+func parseRecoveryKey(recoveryKey string) (map[uint32][]byte, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(recoveryKey), encryptionRecoveryPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid recovery key")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) == 0 || len(data)%(4+encryptionKeySize) != 0 {
+		return nil, fmt.Errorf("invalid recovery key")
+	}
+	keys := make(map[uint32][]byte)
+	for len(data) > 0 {
+		keys[binary.BigEndian.Uint32(data[:4])] = data[4 : 4+encryptionKeySize]
+		data = data[4+encryptionKeySize:]
+	}
+	return keys, nil
+}
+
+// DecryptWithRecoveryKey decrypts a file downloaded directly from storage using an
+// exported recovery key. Input without an encryption header is rejected.
+func DecryptWithRecoveryKey(recoveryKey string, src io.Reader, dst io.Writer) error {
+	keys, err := parseRecoveryKey(recoveryKey)
+	if err != nil {
+		return err
+	}
+	reader, err := newDecryptingReader(io.NopCloser(src), keys, false)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, reader)
+	return err
+}
+
+func newChunkCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonceAndAAD derives the nonce and additional data for a chunk.
+func chunkNonceAndAAD(base []byte, counter uint64, final bool) ([]byte, []byte) {
+	nonce := make([]byte, encryptionNonceSize)
+	copy(nonce, base)
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], counter)
+	for i := range ctr {
+		nonce[encryptionNonceSize-8+i] ^= ctr[i]
+	}
+	aad := append(ctr[:], 0)
+	if final {
+		aad[8] = 1
+	}
+	return nonce, aad
+}
+
+// decryptedSize returns the plaintext size of an encrypted file of the given size.
+func decryptedSize(size int64) int64 {
+	body := size - int64(encryptionHeaderSize)
+	if body < encryptionTagSize {
+		return 0
+	}
+	sealedChunk := int64(encryptionChunkSize + encryptionTagSize)
+	chunks := body / sealedChunk
+	if body%sealedChunk != 0 {
+		chunks++
+	}
+	return body - chunks*encryptionTagSize
+}
+
+// plaintextOpener is implemented by storage layers that can open files stored without
+// an encryption header.
+type plaintextOpener interface {
+	OpenReaderAllowPlaintext(path string) (io.ReadCloser, error)
+}
+
+// openReaderAllowPlaintext opens filePath, accepting files without an encryption header.
+func openReaderAllowPlaintext(backend StorageBackend, filePath string) (io.ReadCloser, error) {
+	if opener, ok := backend.(plaintextOpener); ok {
+		return opener.OpenReaderAllowPlaintext(filePath)
+	}
+	return backend.OpenReader(filePath)
+}
+
+// openStoredFile opens a stored copy of a backup file. Only copies recorded as
+// unencrypted, written before their location enabled encryption, may lack the header.
+func openStoredFile(backend StorageBackend, filePath string, encrypted bool) (io.ReadCloser, error) {
+	if encrypted {
+		return backend.OpenReader(filePath)
+	}
+	return openReaderAllowPlaintext(backend, filePath)
+}
+
+// writesEncrypted reports whether files written through backend are encrypted.
+func writesEncrypted(backend StorageBackend) bool {
+	if dedup, ok := backend.(*dedupStorageBackend); ok {
+		backend = dedup.StorageBackend
+	}
+	encrypted, ok := backend.(*encryptedStorageBackend)
+	return ok && encrypted.active != 0
+}
+
+// encryptingWriter seals plaintext in chunks and writes them to the wrapped writer.
+type encryptingWriter struct {
+	inner   io.WriteCloser
+	aead    cipher.AEAD
+	nonce   []byte
+	buf     []byte
+	counter uint64
+	err     error
+}
+
+func newEncryptingWriter(inner io.WriteCloser, version uint32, key []byte) (*encryptingWriter, error) {
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, encryptionNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, encryptionHeaderSize)
+	header = append(header, encryptionMagic...)
+	header = binary.BigEndian.AppendUint32(header, version)
+	header = append(header, nonce...)
+	if _, err := inner.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		inner: inner,
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, the last chunk is sealed on Close
+		if len(w.buf) == encryptionChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptingWriter) seal(final bool) error {
+	nonce, aad := chunkNonceAndAAD(w.nonce, w.counter, final)
+	sealed := w.aead.Seal(nil, nonce, w.buf, aad)
+	if _, err := w.inner.Write(sealed); err != nil {
+		w.err = err
+		return err
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *encryptingWriter) Close() error {
+	if w.err == nil {
+		if err := w.seal(true); err != nil {
+			abortWriter(w.inner, err)
+			return err
+		}
+	}
+	return w.inner.Close()
+}
+
+// Abort discards the file without sealing its final chunk
+func (w *encryptingWriter) Abort(err error) error {
+	w.err = err
+	abortWriter(w.inner, err)
+	return nil
+}
+
+// decryptingReader opens sealed chunks from the wrapped reader.
+type decryptingReader struct {
+	inner   io.Closer
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+// passthroughReader serves files that were stored without encryption.
+type passthroughReader struct {
+	io.Reader
+	inner io.Closer
+}
+
+func (r *passthroughReader) Close() error {
+	return r.inner.Close()
+}
+
+// newDecryptingReader decrypts inner. Files that are too short for the header or lack
+// the magic are passed through unauthenticated when allowPlaintext is set and rejected
+// otherwise.
+func newDecryptingReader(inner io.ReadCloser, keys map[uint32][]byte, allowPlaintext bool) (io.ReadCloser, error) {
+	src := bufio.NewReaderSize(inner, encryptionChunkSize+encryptionTagSize)
+	header, err := src.Peek(encryptionHeaderSize)
+	if err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		if allowPlaintext {
+			return &passthroughReader{Reader: src, inner: inner}, nil
+		}
+		inner.Close()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, errNotEncrypted
+	}
+
+	version := binary.BigEndian.Uint32(header[len(encryptionMagic):])
+	key, ok := keys[version]
+	if !ok {
+		inner.Close()
+		return nil, fmt.Errorf("encryption key version %d not found", version)
+	}
+	aead, err := newChunkCipher(key)
+	if err != nil {
+		inner.Close()
+		return nil, err
+	}
+	nonce := append([]byte(nil), header[len(encryptionMagic)+4:]...)
+	src.Discard(encryptionHeaderSize)
+
+	return &decryptingReader{
+		inner: inner,
+		src:   src,
+		aead:  aead,
+		nonce: nonce,
+		chunk: make([]byte, encryptionChunkSize+encryptionTagSize),
+	}, nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) open() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	final := false
+	switch err {
+	case nil:
+		if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+			final = true
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		final = true
+	default:
+		return err
+	}
+	if n < encryptionTagSize {
+		return fmt.Errorf("encrypted file is truncated")
+	}
+
+	nonce, aad := chunkNonceAndAAD(r.nonce, r.counter, final)
+	plain, err := r.aead.Open(r.chunk[:0], nonce, r.chunk[:n], aad)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %v", r.counter, err)
+	}
+	r.counter++
+	r.plain = plain
+	r.done = final
+	return nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.inner.Close()
+}
+
+func (b *encryptedStorageBackend) OpenWriter(filePath string) (io.WriteCloser, error) {
+	if b.active == 0 {
+		// Encryption was disabled, keys are only kept for reading
+		return b.StorageBackend.OpenWriter(filePath)
+	}
+	inner, err := b.StorageBackend.OpenWriter(filePath)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := newEncryptingWriter(inner, b.active, b.keys[b.active])
+	if err != nil {
+		abortWriter(inner, err)
+		return nil, err
+	}
+	return writer, nil
+}
+
+// OpenReader decrypts filePath. Files without an encryption header are only served
+// once encryption was disabled again.
+func (b *encryptedStorageBackend) OpenReader(filePath string) (io.ReadCloser, error) {
+	return b.openReader(filePath, b.active == 0)
+}
+
+// OpenReaderAllowPlaintext decrypts filePath when it carries an encryption header and
+// serves it unchanged otherwise, for files stored before encryption was enabled.
+func (b *encryptedStorageBackend) OpenReaderAllowPlaintext(filePath string) (io.ReadCloser, error) {
+	return b.openReader(filePath, true)
+}
+
+func (b *encryptedStorageBackend) openReader(filePath string, allowPlaintext bool) (io.ReadCloser, error) {
+	inner, err := b.StorageBackend.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := newDecryptingReader(inner, b.keys, allowPlaintext)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return reader, nil
+}
+
+func (b *encryptedStorageBackend) Stat(filePath string) (os.FileInfo, error) {
+	info, err := b.StorageBackend.Stat(filePath)
+	if err != nil || info.IsDir() || info.Size() < int64(encryptionHeaderSize) {
+		return info, err
+	}
+
+	// Only files carrying the header are encrypted, peek to report the plaintext size
+	reader, err := b.StorageBackend.OpenReader(filePath)
+	if err != nil {
+		return info, nil
+	}
+	defer reader.Close()
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != encryptionMagic {
+		return info, nil
+	}
+	return &encryptedFileInfo{FileInfo: info, size: decryptedSize(info.Size())}, nil
+}
@@ -26,8 +26,13 @@ func ServiceUpdateFileRule(id uint, input *entity.FileRule) (*entity.FileRule, e
 	rule.Recursive = input.Recursive
 	rule.Compress = input.Compress
 	rule.CompressFormat = input.CompressFormat
-	rule.CompressPassword = input.CompressPassword
+	// The redacted placeholder means the password was left unchanged
+	if input.CompressPassword != entity.RedactedSecret {
+		rule.CompressPassword = input.CompressPassword
+	}
 	rule.ExcludePattern = input.ExcludePattern
+	rule.Incremental = input.Incremental
+	rule.VerifyChecksum = input.VerifyChecksum
 	if err := DB.Save(&rule).Error; err != nil {
 		return nil, err
 	}
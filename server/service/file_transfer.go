@@ -1,6 +1,9 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
@@ -8,23 +11,56 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"backapp-server/entity"
 )
 
+// checksumManifestName is the file listing the checksums of a run in its backup directory
+const checksumManifestName = "SHA256SUMS"
+
 // FileTransferService handles file transfers with include/exclude rules
 type FileTransferService struct {
+	ctx            context.Context
 	sshClient      *SSHClient
 	storageBackend StorageBackend
 	destDir        string
 	runID          uint
+	written        []*writtenFile // files opened for writing, complete or not
+}
+
+// writtenFile is a file the transfer opened in the storage backend
+type writtenFile struct {
+	path     string
+	complete bool // its writer was closed successfully, on WORM storage it is locked
+}
+
+// runFileWriter marks its file complete once it was closed successfully
+type runFileWriter struct {
+	io.WriteCloser
+	file *writtenFile
+}
+
+func (w *runFileWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.file.complete = true
+	return nil
+}
+
+func (w *runFileWriter) Abort(err error) error {
+	abortWriter(w.WriteCloser, err)
+	return nil
 }
 
-// NewFileTransferService creates a new file transfer service
-func NewFileTransferService(sshClient *SSHClient, storageBackend StorageBackend, destDir string, runID uint) *FileTransferService {
+// NewFileTransferService creates a new file transfer service. Cancelling ctx aborts the
+// transfer, the SSH client should be bound to the same context.
+func NewFileTransferService(ctx context.Context, sshClient *SSHClient, storageBackend StorageBackend, destDir string, runID uint) *FileTransferService {
 	return &FileTransferService{
+		ctx:            ctx,
 		sshClient:      sshClient,
 		storageBackend: storageBackend,
 		destDir:        destDir,
@@ -56,6 +92,9 @@ func (s *FileTransferService) TransferFiles(fileRules []entity.FileRule) ([]enti
 	}
 
 	for i, rule := range fileRules {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
 		s.logToDatabase("INFO", fmt.Sprintf("Processing rule %d/%d: %s", i+1, len(fileRules), rule.RemotePath))
 		files, err := s.transferFileRule(rule)
 		if err != nil {
@@ -89,61 +128,135 @@ func (s *FileTransferService) transferFileRule(rule entity.FileRule) ([]entity.B
 
 	isDir := strings.TrimSpace(isDirOutput) == "yes"
 
+	// Archives are rebuilt on every run, only plain copies can reuse earlier files
+	var previous map[string]entity.BackupFile
+	if rule.Incremental && !rule.Compress {
+		previous = s.previousFilesForRule(rule)
+	}
+
 	if isDir {
 		if rule.Compress {
 			return s.transferDirectoryCompressed(rule)
 		}
 		if rule.Recursive {
-			return s.transferDirectory(rule)
+			return s.transferDirectory(rule, previous)
 		}
 		// Non-recursive directory transfer
-		return s.transferDirectoryShallow(rule)
+		return s.transferDirectoryShallow(rule, previous)
 	}
 
 	// Single file transfer
 	if rule.Compress {
 		return s.transferSingleFileCompressed(rule)
 	}
-	return s.transferSingleFile(rule)
+	return s.transferSingleFile(rule, previous)
+}
+
+// previousFilesForRule returns the files the last completed run of the profile stored for
+// the rule, keyed by remote path. Files without a recorded modification time are skipped.
+func (s *FileTransferService) previousFilesForRule(rule entity.FileRule) map[string]entity.BackupFile {
+	var runs []entity.BackupRun
+	if err := DB.Where("backup_profile_id = ? AND status = ? AND id <> ?", rule.BackupProfileID, "completed", s.runID).
+		Order("end_time DESC").Limit(1).Find(&runs).Error; err != nil {
+		s.logToDatabase("WARNING", fmt.Sprintf("Failed to load previous run, transferring all files: %v", err))
+		return nil
+	}
+	if len(runs) == 0 {
+		s.logToDatabase("INFO", "No previous completed run, transferring all files")
+		return nil
+	}
+
+	var files []entity.BackupFile
+	// Copies a verification found damaged or missing are transferred again
+	if err := DB.Where("backup_run_id = ? AND file_rule_id = ? AND deleted = ? AND remote_mod_time IS NOT NULL", runs[0].ID, rule.ID, false).
+		Where("COALESCE(integrity_status, '') IN ?", []string{"", integrityStatusOK}).
+		Find(&files).Error; err != nil {
+		s.logToDatabase("WARNING", fmt.Sprintf("Failed to load files of run %d, transferring all files: %v", runs[0].ID, err))
+		return nil
+	}
+	previous := make(map[string]entity.BackupFile, len(files))
+	for _, file := range files {
+		previous[file.RemotePath] = file
+	}
+	s.logToDatabase("INFO", fmt.Sprintf("Incremental: comparing against %d files of run %d", len(previous), runs[0].ID))
+	return previous
+}
+
+// reuseUnchangedFile returns a record referencing the copy an earlier run stored for
+// remoteFile when size and modification time are unchanged and the copy still exists.
+func (s *FileTransferService) reuseUnchangedFile(previous map[string]entity.BackupFile, remoteFile, snapshotPath string, size int64, modTime time.Time, ruleID uint) (entity.BackupFile, bool) {
+	prev, ok := previous[remoteFile]
+	if !ok || prev.LocalPath == "" || prev.FileSize != size || !prev.RemoteModTime.Equal(modTime) {
+		return entity.BackupFile{}, false
+	}
+	if _, err := s.storageBackend.Stat(prev.LocalPath); err != nil {
+		return entity.BackupFile{}, false
+	}
+	reusedFrom := prev.BackupRunID
+	if prev.ReusedFromRunID != nil {
+		reusedFrom = *prev.ReusedFromRunID
+	}
+	return entity.BackupFile{
+		RemotePath:      remoteFile,
+		LocalPath:       prev.LocalPath,
+		SnapshotPath:    snapshotPath,
+		SizeBytes:       size,
+		FileSize:        size,
+		RemoteModTime:   &modTime,
+		Checksum:        prev.Checksum,
+		Encrypted:       prev.Encrypted,
+		ReusedFromRunID: &reusedFrom,
+		FileRuleID:      ruleID,
+	}, true
 }
 
 // transferSingleFile transfers a single file
-func (s *FileTransferService) transferSingleFile(rule entity.FileRule) ([]entity.BackupFile, error) {
+func (s *FileTransferService) transferSingleFile(rule entity.FileRule, previous map[string]entity.BackupFile) ([]entity.BackupFile, error) {
 	fileName := filepath.Base(rule.RemotePath)
 	localPath := s.joinDestPath(fileName)
 
 	s.logToDatabase("DEBUG", fmt.Sprintf("Transferring file: %s", rule.RemotePath))
-	// Get file size
-	sizeCmd := fmt.Sprintf("stat -c%%s '%s' 2>/dev/null || stat -f%%z '%s'", rule.RemotePath, rule.RemotePath)
-	sizeOutput, err := s.sshClient.RunCommand(sizeCmd)
+	// Get file size and modification time
+	fileSize, modTime, mode, err := s.getRemoteFileStat(rule.RemotePath)
 	if err != nil {
 		s.logToDatabase("ERROR", fmt.Sprintf("Failed to get file size for %s: %v", rule.RemotePath, err))
 		return nil, fmt.Errorf("failed to get file size: %v", err)
 	}
 
-	var fileSize int64
-	fmt.Sscanf(strings.TrimSpace(sizeOutput), "%d", &fileSize)
+	if reused, ok := s.reuseUnchangedFile(previous, rule.RemotePath, fileName, fileSize, modTime, rule.ID); ok {
+		reused.RemoteMode = mode
+		s.logToDatabase("DEBUG", fmt.Sprintf("File unchanged, reusing copy of run %d: %s", *reused.ReusedFromRunID, fileName))
+		return []entity.BackupFile{reused}, nil
+	}
 
 	// Download file
-	if err := s.copyRemoteFile(rule.RemotePath, localPath); err != nil {
+	checksum, err := s.copyRemoteFile(rule.RemotePath, localPath)
+	if err != nil {
 		s.logToDatabase("ERROR", fmt.Sprintf("Failed to copy file %s: %v", rule.RemotePath, err))
 		return nil, fmt.Errorf("failed to copy file: %v", err)
 	}
 	s.logToDatabase("DEBUG", fmt.Sprintf("File transferred successfully: %s (%.2f KB)", fileName, float64(fileSize)/1024))
 
 	backupFile := entity.BackupFile{
-		RemotePath: rule.RemotePath,
-		LocalPath:  localPath,
-		SizeBytes:  fileSize,
-		FileSize:   fileSize,
-		FileRuleID: rule.ID,
+		RemotePath:    rule.RemotePath,
+		LocalPath:     localPath,
+		SnapshotPath:  fileName,
+		SizeBytes:     fileSize,
+		FileSize:      fileSize,
+		RemoteModTime: &modTime,
+		RemoteMode:    mode,
+		Checksum:      checksum,
+		FileRuleID:    rule.ID,
+	}
+	if rule.VerifyChecksum {
+		s.verifyRemoteChecksum(&backupFile, rule.RemotePath)
 	}
 
 	return []entity.BackupFile{backupFile}, nil
 }
 
 // transferDirectoryShallow transfers only files in the directory (non-recursive)
-func (s *FileTransferService) transferDirectoryShallow(rule entity.FileRule) ([]entity.BackupFile, error) {
+func (s *FileTransferService) transferDirectoryShallow(rule entity.FileRule, previous map[string]entity.BackupFile) ([]entity.BackupFile, error) {
 	// List files in directory (non-recursive)
 	listCmd := fmt.Sprintf("find '%s' -maxdepth 1 -type f", rule.RemotePath)
 	output, err := s.sshClient.RunCommand(listCmd)
@@ -155,6 +268,9 @@ func (s *FileTransferService) transferDirectoryShallow(rule entity.FileRule) ([]
 	var backupFiles []entity.BackupFile
 
 	for _, file := range files {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
 		file = strings.TrimSpace(file)
 		if file == "" {
 			continue
@@ -166,11 +282,12 @@ func (s *FileTransferService) transferDirectoryShallow(rule entity.FileRule) ([]
 
 		// Create a temporary rule for this single file
 		singleFileRule := entity.FileRule{
-			ID:         rule.ID,
-			RemotePath: file,
+			ID:             rule.ID,
+			RemotePath:     file,
+			VerifyChecksum: rule.VerifyChecksum,
 		}
 
-		transferred, err := s.transferSingleFile(singleFileRule)
+		transferred, err := s.transferSingleFile(singleFileRule, previous)
 		if err != nil {
 			return nil, fmt.Errorf("failed to transfer file %s: %v", file, err)
 		}
@@ -181,8 +298,9 @@ func (s *FileTransferService) transferDirectoryShallow(rule entity.FileRule) ([]
 	return backupFiles, nil
 }
 
-// transferDirectory transfers a directory recursively
-func (s *FileTransferService) transferDirectory(rule entity.FileRule) ([]entity.BackupFile, error) {
+// transferDirectory transfers a directory recursively. Files found unchanged in previous
+// are referenced instead of downloaded again.
+func (s *FileTransferService) transferDirectory(rule entity.FileRule, previous map[string]entity.BackupFile) ([]entity.BackupFile, error) {
 	s.logToDatabase("INFO", fmt.Sprintf("Listing files in directory: %s", rule.RemotePath))
 	// Build find command with exclude pattern if provided
 	findCmd := fmt.Sprintf("find '%s' -type f", rule.RemotePath)
@@ -196,8 +314,12 @@ func (s *FileTransferService) transferDirectory(rule entity.FileRule) ([]entity.
 	files := strings.Split(strings.TrimSpace(output), "\n")
 	s.logToDatabase("INFO", fmt.Sprintf("Found %d files to transfer", len(files)))
 	var backupFiles []entity.BackupFile
+	reusedFiles := 0
 
 	for _, file := range files {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
 		file = strings.TrimSpace(file)
 		if file == "" {
 			continue
@@ -212,37 +334,51 @@ func (s *FileTransferService) transferDirectory(rule entity.FileRule) ([]entity.
 		relPath = strings.TrimPrefix(relPath, "/")
 		localPath := s.joinDestPath(relPath)
 
-		// Create parent directory
-		if err := s.storageBackend.EnsureDir(s.destDirForPath(localPath)); err != nil {
-			return nil, fmt.Errorf("failed to create directory: %v", err)
-		}
-
-		// Get file size
-		sizeCmd := fmt.Sprintf("stat -c%%s '%s' 2>/dev/null || stat -f%%z '%s'", file, file)
-		sizeOutput, err := s.sshClient.RunCommand(sizeCmd)
+		// Get file size and modification time
+		fileSize, modTime, mode, err := s.getRemoteFileStat(file)
 		if err != nil {
 			continue // Skip files that can't be stat'd
 		}
 
-		var fileSize int64
-		fmt.Sscanf(strings.TrimSpace(sizeOutput), "%d", &fileSize)
+		if reused, ok := s.reuseUnchangedFile(previous, file, relPath, fileSize, modTime, rule.ID); ok {
+			reused.RemoteMode = mode
+			backupFiles = append(backupFiles, reused)
+			reusedFiles++
+			continue
+		}
+
+		// Create parent directory
+		if err := s.storageBackend.EnsureDir(s.destDirForPath(localPath)); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %v", err)
+		}
 
 		// Download file
-		if err := s.copyRemoteFile(file, localPath); err != nil {
+		checksum, err := s.copyRemoteFile(file, localPath)
+		if err != nil {
 			return nil, fmt.Errorf("failed to copy file %s: %v", file, err)
 		}
 
 		backupFile := entity.BackupFile{
-			RemotePath: file,
-			LocalPath:  localPath,
-			SizeBytes:  fileSize,
-			FileSize:   fileSize,
-			FileRuleID: rule.ID,
+			RemotePath:    file,
+			LocalPath:     localPath,
+			SnapshotPath:  relPath,
+			SizeBytes:     fileSize,
+			FileSize:      fileSize,
+			RemoteModTime: &modTime,
+			RemoteMode:    mode,
+			Checksum:      checksum,
+			FileRuleID:    rule.ID,
+		}
+		if rule.VerifyChecksum {
+			s.verifyRemoteChecksum(&backupFile, file)
 		}
 
 		backupFiles = append(backupFiles, backupFile)
 	}
 
+	if previous != nil {
+		s.logToDatabase("INFO", fmt.Sprintf("Incremental: %d files unchanged and reused, %d files transferred", reusedFiles, len(backupFiles)-reusedFiles))
+	}
 	return backupFiles, nil
 }
 
@@ -279,8 +415,10 @@ func (s *FileTransferService) transferDirectoryCompressed(rule entity.FileRule)
 		s.logPermissionIssues("find", listOutput)
 		return nil, fmt.Errorf("failed to build file list: %v", err)
 	}
-	defer s.sshClient.RunCommand(fmt.Sprintf("rm -f %s", shellQuote(tmpList)))
+	defer s.removeRemoteTemp(tmpList)
 
+	// Also removes the partial archive of a failed or cancelled compression
+	defer s.removeRemoteTemp(tmpArchive)
 	if output, err := s.sshClient.RunCommand(archiveCmd); err != nil {
 		cmdOutput := strings.TrimSpace(output)
 		s.logPermissionIssues(compressFormat, cmdOutput)
@@ -293,19 +431,24 @@ func (s *FileTransferService) transferDirectoryCompressed(rule entity.FileRule)
 			s.logToDatabase("WARN", fmt.Sprintf("%s completed with warnings: %s", compressFormat, strings.TrimSpace(cmdOutput)))
 		}
 	}
-	defer s.sshClient.RunCommand(fmt.Sprintf("rm -f %s", shellQuote(tmpArchive)))
 
 	fileSize, _ := s.getRemoteFileSize(tmpArchive)
-	if err := s.copyRemoteFile(tmpArchive, localPath); err != nil {
+	checksum, err := s.copyRemoteFile(tmpArchive, localPath)
+	if err != nil {
 		return nil, fmt.Errorf("failed to copy archive: %v", err)
 	}
 
 	backupFile := entity.BackupFile{
-		RemotePath: archiveName,
-		LocalPath:  localPath,
-		SizeBytes:  fileSize,
-		FileSize:   fileSize,
-		FileRuleID: rule.ID,
+		RemotePath:   archiveName,
+		LocalPath:    localPath,
+		SnapshotPath: archiveName,
+		SizeBytes:    fileSize,
+		FileSize:     fileSize,
+		Checksum:     checksum,
+		FileRuleID:   rule.ID,
+	}
+	if rule.VerifyChecksum {
+		s.verifyRemoteChecksum(&backupFile, tmpArchive)
 	}
 
 	return []entity.BackupFile{backupFile}, nil
@@ -337,25 +480,31 @@ func (s *FileTransferService) transferSingleFileCompressed(rule entity.FileRule)
 		archiveCmd = s.build7zCommandForFile(parentDir, tmpArchive, fileName, rule.CompressPassword)
 	}
 	s.logToDatabase("INFO", fmt.Sprintf("Compressing file with %s: %s", archiveFormat, rule.RemotePath))
+	defer s.removeRemoteTemp(tmpArchive)
 	if output, err := s.sshClient.RunCommand(archiveCmd); err != nil {
 		cmdOutput := strings.TrimSpace(output)
 		s.logPermissionIssues(archiveFormat, cmdOutput)
 		cmdDetails := formatCommandFailure(err, cmdOutput)
 		return nil, fmt.Errorf("failed to create %s archive: %s", archiveFormat, cmdDetails)
 	}
-	defer s.sshClient.RunCommand(fmt.Sprintf("rm -f %s", shellQuote(tmpArchive)))
 
 	fileSize, _ := s.getRemoteFileSize(tmpArchive)
-	if err := s.copyRemoteFile(tmpArchive, localPath); err != nil {
+	checksum, err := s.copyRemoteFile(tmpArchive, localPath)
+	if err != nil {
 		return nil, fmt.Errorf("failed to copy archive: %v", err)
 	}
 
 	backupFile := entity.BackupFile{
-		RemotePath: archiveName,
-		LocalPath:  localPath,
-		SizeBytes:  fileSize,
-		FileSize:   fileSize,
-		FileRuleID: rule.ID,
+		RemotePath:   archiveName,
+		LocalPath:    localPath,
+		SnapshotPath: archiveName,
+		SizeBytes:    fileSize,
+		FileSize:     fileSize,
+		Checksum:     checksum,
+		FileRuleID:   rule.ID,
+	}
+	if rule.VerifyChecksum {
+		s.verifyRemoteChecksum(&backupFile, tmpArchive)
 	}
 
 	return []entity.BackupFile{backupFile}, nil
@@ -375,11 +524,11 @@ func (s *FileTransferService) transferSingleFileCompressedViaLocal(rule entity.F
 
 	localArchive := filepath.Join(tmpRoot, archiveName)
 	if archiveFormat == "zip" {
-		if err := runLocalZipArchive(tmpRoot, fileName, localArchive, ""); err != nil {
+		if err := runLocalZipArchive(s.ctx, tmpRoot, fileName, localArchive, ""); err != nil {
 			return nil, fmt.Errorf("failed to create zip archive locally: %v", err)
 		}
 	} else {
-		if err := runLocal7zArchive(tmpRoot, fileName, localArchive, rule.CompressPassword); err != nil {
+		if err := runLocal7zArchive(s.ctx, tmpRoot, fileName, localArchive, rule.CompressPassword); err != nil {
 			return nil, fmt.Errorf("failed to create 7z archive locally: %v", err)
 		}
 	}
@@ -389,16 +538,19 @@ func (s *FileTransferService) transferSingleFileCompressedViaLocal(rule entity.F
 		return nil, fmt.Errorf("failed to stat local archive: %v", err)
 	}
 
-	if err := s.copyLocalFileToStorage(localArchive, destPath); err != nil {
+	checksum, err := s.copyLocalFileToStorage(localArchive, destPath)
+	if err != nil {
 		return nil, fmt.Errorf("failed to upload archive: %v", err)
 	}
 
 	backupFile := entity.BackupFile{
-		RemotePath: archiveName,
-		LocalPath:  destPath,
-		SizeBytes:  fileInfo.Size(),
-		FileSize:   fileInfo.Size(),
-		FileRuleID: rule.ID,
+		RemotePath:   archiveName,
+		LocalPath:    destPath,
+		SnapshotPath: archiveName,
+		SizeBytes:    fileInfo.Size(),
+		FileSize:     fileInfo.Size(),
+		Checksum:     checksum,
+		FileRuleID:   rule.ID,
 	}
 
 	return []entity.BackupFile{backupFile}, nil
@@ -420,18 +572,18 @@ func (s *FileTransferService) transferDirectoryCompressedViaLocal(rule entity.Fi
 	}
 
 	s.logToDatabase("INFO", fmt.Sprintf("Downloading directory for local compression: %s", rule.RemotePath))
-	tmpService := NewFileTransferService(s.sshClient, &localStorageBackend{}, localDir, s.runID)
-	if _, err := tmpService.transferDirectory(rule); err != nil {
+	tmpService := NewFileTransferService(s.ctx, s.sshClient, &localStorageBackend{}, localDir, s.runID)
+	if _, err := tmpService.transferDirectory(rule, nil); err != nil {
 		return nil, fmt.Errorf("failed to download directory for compression: %v", err)
 	}
 
 	localArchive := filepath.Join(tmpRoot, archiveName)
 	if compressFormat == "zip" {
-		if err := runLocalZipArchive(tmpRoot, baseName, localArchive, rule.ExcludePattern); err != nil {
+		if err := runLocalZipArchive(s.ctx, tmpRoot, baseName, localArchive, rule.ExcludePattern); err != nil {
 			return nil, fmt.Errorf("failed to create zip archive locally: %v", err)
 		}
 	} else {
-		if err := runLocal7zArchive(tmpRoot, baseName, localArchive, rule.CompressPassword); err != nil {
+		if err := runLocal7zArchive(s.ctx, tmpRoot, baseName, localArchive, rule.CompressPassword); err != nil {
 			return nil, fmt.Errorf("failed to create 7z archive locally: %v", err)
 		}
 	}
@@ -441,52 +593,66 @@ func (s *FileTransferService) transferDirectoryCompressedViaLocal(rule entity.Fi
 		return nil, fmt.Errorf("failed to stat local archive: %v", err)
 	}
 
-	if err := s.copyLocalFileToStorage(localArchive, destPath); err != nil {
+	checksum, err := s.copyLocalFileToStorage(localArchive, destPath)
+	if err != nil {
 		return nil, fmt.Errorf("failed to upload archive: %v", err)
 	}
 
 	backupFile := entity.BackupFile{
-		RemotePath: archiveName,
-		LocalPath:  destPath,
-		SizeBytes:  fileInfo.Size(),
-		FileSize:   fileInfo.Size(),
-		FileRuleID: rule.ID,
+		RemotePath:   archiveName,
+		LocalPath:    destPath,
+		SnapshotPath: archiveName,
+		SizeBytes:    fileInfo.Size(),
+		FileSize:     fileInfo.Size(),
+		Checksum:     checksum,
+		FileRuleID:   rule.ID,
 	}
 
 	return []entity.BackupFile{backupFile}, nil
 }
 
-func runLocal7zArchive(workDir, sourceName, archivePath, password string) error {
+func runLocal7zArchive(ctx context.Context, workDir, sourceName, archivePath, password string) error {
 	args := []string{"a", "-t7z", "-mx=9"}
 	if password != "" {
 		args = append(args, "-mhe=on", "-p"+password)
 	}
 	args = append(args, archivePath, sourceName)
 
-	cmd := exec.Command("7z", args...)
+	cmd := exec.CommandContext(ctx, "7z", args...)
 	cmd.Dir = workDir
 	output, err := cmd.CombinedOutput()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	if err != nil {
 		return fmt.Errorf("7z failed: %v (%s)", err, strings.TrimSpace(string(output)))
 	}
 	return nil
 }
 
-func (s *FileTransferService) copyLocalFileToStorage(localPath, destPath string) error {
+// copyLocalFileToStorage uploads a local file and returns its SHA-256 checksum.
+func (s *FileTransferService) copyLocalFileToStorage(localPath, destPath string) (string, error) {
 	reader, err := os.Open(localPath)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer reader.Close()
 
-	writer, err := s.storageBackend.OpenWriter(destPath)
+	writer, err := s.openWriter(destPath)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer writer.Close()
 
-	_, err = io.Copy(writer, reader)
-	return err
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(writer, hasher), &contextReader{ctx: s.ctx, reader: reader}); err != nil {
+		abortWriter(writer, err)
+		return "", err
+	}
+	// Remote backends may only report upload failures on close
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
 func (s *FileTransferService) archiveName(format string) string {
@@ -542,6 +708,18 @@ func (s *FileTransferService) shouldExclude(filePath, excludePattern string) boo
 	return false
 }
 
+// storedArchiveFormat returns the format of an archive stored by a compressed rule,
+// judged by its extension, or an empty string for other files.
+func storedArchiveFormat(filePath string) string {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".zip":
+		return "zip"
+	case ".7z":
+		return "7z"
+	}
+	return ""
+}
+
 func (s *FileTransferService) joinDestPath(relPath string) string {
 	if s.storageBackend.IsLocal() {
 		return filepath.Join(s.destDir, relPath)
@@ -556,16 +734,174 @@ func (s *FileTransferService) destDirForPath(filePath string) string {
 	return path.Dir(filePath)
 }
 
-func (s *FileTransferService) copyRemoteFile(remotePath, destPath string) error {
-	if s.storageBackend.IsLocal() {
-		return s.sshClient.CopyFileFromRemote(remotePath, destPath)
+// copyRemoteFile downloads a remote file into the storage backend and returns the SHA-256
+// checksum of the content, computed while streaming.
+func (s *FileTransferService) copyRemoteFile(remotePath, destPath string) (string, error) {
+	writer, err := s.openWriter(destPath)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	if err := s.sshClient.CopyFileFromRemoteToWriter(remotePath, io.MultiWriter(writer, hasher)); err != nil {
+		abortWriter(writer, err)
+		// Plain local storage can still fall back to scp, WORM files must go through the
+		// locked writer
+		if local, ok := s.storageBackend.(*localStorageBackend); ok && local.lockDays == 0 && s.ctx.Err() == nil {
+			if scpErr := s.sshClient.copyFileUsingSCP(remotePath, destPath); scpErr == nil {
+				return localFileChecksum(destPath)
+			}
+		}
+		return "", err
 	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// openWriter opens a file of the run in the storage backend and remembers it for
+// RemoveWrittenFiles
+func (s *FileTransferService) openWriter(destPath string) (io.WriteCloser, error) {
+	file := &writtenFile{path: destPath}
+	s.written = append(s.written, file)
 	writer, err := s.storageBackend.OpenWriter(destPath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &runFileWriter{WriteCloser: writer, file: file}, nil
+}
+
+// RemoveWrittenFiles removes the files the transfer wrote to the storage backend and returns
+// the paths it removed. Partial files are always removed, complete ones unless keepComplete
+// is set, e.g. because WORM storage locked them. Files reused from earlier runs are not
+// touched. On local storage the directories left empty are removed as well.
+func (s *FileTransferService) RemoveWrittenFiles(keepComplete bool) ([]string, error) {
+	var removed []string
+	var firstErr error
+	dirs := map[string]bool{s.destDir: true}
+	for _, file := range s.written {
+		if file.complete && keepComplete {
+			continue
+		}
+		filePath := file.path
+		if _, err := s.storageBackend.Stat(filePath); err != nil {
+			continue
+		}
+		if err := s.storageBackend.Remove(filePath); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to remove %s: %v", filePath, err)
+			}
+			continue
+		}
+		removed = append(removed, filePath)
+		for dir := s.destDirForPath(filePath); strings.HasPrefix(dir, s.destDir) && !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	if s.storageBackend.IsLocal() {
+		// Deepest first, os.Remove leaves directories that still hold other files
+		ordered := make([]string, 0, len(dirs))
+		for dir := range dirs {
+			ordered = append(ordered, dir)
+		}
+		sort.Slice(ordered, func(i, j int) bool { return len(ordered[i]) > len(ordered[j]) })
+		for _, dir := range ordered {
+			os.Remove(dir)
+		}
+	}
+	return removed, firstErr
+}
+
+// removeRemoteTemp deletes a temporary file on the remote host, also after the transfer
+// was cancelled
+func (s *FileTransferService) removeRemoteTemp(remotePath string) {
+	s.sshClient.WithContext(context.Background()).RunCommand(fmt.Sprintf("rm -f %s", shellQuote(remotePath)))
+}
+
+// contextReader fails reads once its context is cancelled, aborting a copy in progress
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// WriteChecksumManifest writes a SHA256SUMS file with the checksums of the files stored in
+// the run's backup directory in sha256sum format, so the directory can be checked with
+// sha256sum -c. Unchanged files of incremental rules stay in the directory of an earlier
+// run and are listed by its SHA256SUMS.
+func (s *FileTransferService) WriteChecksumManifest(files []entity.BackupFile) (*entity.BackupFile, error) {
+	var content strings.Builder
+	for _, file := range files {
+		if file.Checksum == "" || file.SnapshotPath == "" || file.ReusedFromRunID != nil {
+			continue
+		}
+		fmt.Fprintf(&content, "%s  %s\n", file.Checksum, file.SnapshotPath)
+	}
+	if content.Len() == 0 {
+		return nil, nil
+	}
+
+	localPath := s.joinDestPath(checksumManifestName)
+	writer, err := s.openWriter(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(writer, content.String()); err != nil {
+		abortWriter(writer, err)
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	checksum := sha256.Sum256([]byte(content.String()))
+	return &entity.BackupFile{
+		RemotePath:   checksumManifestName,
+		LocalPath:    localPath,
+		SnapshotPath: checksumManifestName,
+		SizeBytes:    int64(content.Len()),
+		FileSize:     int64(content.Len()),
+		Checksum:     hex.EncodeToString(checksum[:]),
+	}, nil
+}
+
+// localFileChecksum returns the SHA-256 checksum of a local file.
+func localFileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyRemoteChecksum compares the checksum of the stored copy with the one computed
+// by the remote host and marks the file on mismatch.
+func (s *FileTransferService) verifyRemoteChecksum(file *entity.BackupFile, remotePath string) {
+	output, err := s.sshClient.RunCommand(fmt.Sprintf("sha256sum %s 2>/dev/null || shasum -a 256 %s", shellQuote(remotePath), shellQuote(remotePath)))
+	fields := strings.Fields(output)
+	if err == nil && (len(fields) == 0 || len(fields[0]) != sha256.Size*2) {
+		err = fmt.Errorf("unexpected sha256sum output")
+	}
+	if err != nil {
+		s.logToDatabase("WARNING", fmt.Sprintf("Failed to compute remote checksum of %s: %s", remotePath, formatCommandFailure(err, strings.TrimSpace(output))))
+		return
+	}
+	file.RemoteChecksum = strings.ToLower(fields[0])
+	if file.RemoteChecksum != file.Checksum {
+		file.ChecksumMismatch = true
+		s.logToDatabase("WARNING", fmt.Sprintf("Checksum mismatch for %s: remote %s, stored %s", remotePath, file.RemoteChecksum, file.Checksum))
 	}
-	defer writer.Close()
-	return s.sshClient.CopyFileFromRemoteToWriter(remotePath, writer)
 }
 
 func (s *FileTransferService) getRemoteFileSize(remotePath string) (int64, error) {
@@ -580,6 +916,23 @@ func (s *FileTransferService) getRemoteFileSize(remotePath string) (int64, error
 	return fileSize, nil
 }
 
+// getRemoteFileStat returns the size, modification time and permission bits of a remote
+// file, supporting both GNU and BSD stat.
+func (s *FileTransferService) getRemoteFileStat(remotePath string) (int64, time.Time, uint32, error) {
+	statCmd := fmt.Sprintf("stat -c '%%s %%Y %%a' %s 2>/dev/null || stat -f '%%z %%m %%Lp' %s", shellQuote(remotePath), shellQuote(remotePath))
+	output, err := s.sshClient.RunCommand(statCmd)
+	if err != nil {
+		return 0, time.Time{}, 0, err
+	}
+
+	var fileSize, modTime int64
+	var mode uint32
+	if _, err := fmt.Sscanf(strings.TrimSpace(output), "%d %d %o", &fileSize, &modTime, &mode); err != nil {
+		return 0, time.Time{}, 0, fmt.Errorf("unexpected stat output %q", strings.TrimSpace(output))
+	}
+	return fileSize, time.Unix(modTime, 0).UTC(), mode, nil
+}
+
 func (s *FileTransferService) buildFileListCommand(parentDir, baseName, listFile string) string {
 	cmd := fmt.Sprintf(
 		"cd %s && find %s -xdev -print > %s 2> %s.err; cat %s.err; rm -f %s.err; if [ ! -s %s ]; then exit 1; fi; exit 0",
@@ -685,7 +1038,7 @@ func (s *FileTransferService) build7zCommandForFile(parentDir, tmpArchive, fileN
 	)
 	return cmd
 }
-func runLocalZipArchive(workDir, sourceName, archivePath, excludePattern string) error {
+func runLocalZipArchive(ctx context.Context, workDir, sourceName, archivePath, excludePattern string) error {
 	args := []string{"-r", "-9", archivePath, sourceName}
 	if excludePattern != "" {
 		patterns := strings.Split(excludePattern, ",")
@@ -700,9 +1053,12 @@ func runLocalZipArchive(workDir, sourceName, archivePath, excludePattern string)
 			}
 		}
 	}
-	cmd := exec.Command("zip", args...)
+	cmd := exec.CommandContext(ctx, "zip", args...)
 	cmd.Dir = workDir
 	output, err := cmd.CombinedOutput()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	if err != nil {
 		return fmt.Errorf("zip failed: %v (%s)", err, strings.TrimSpace(string(output)))
 	}
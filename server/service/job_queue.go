@@ -0,0 +1,332 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+
+	"gorm.io/gorm"
+)
+
+const (
+	jobStatusQueued    = "queued"
+	jobStatusRunning   = "running"
+	jobStatusCompleted = "completed"
+	jobStatusFailed    = "failed"
+	jobStatusSkipped   = "skipped"
+	jobStatusCancelled = "cancelled"
+
+	jobTriggerManual    = "manual"
+	jobTriggerScheduled = "scheduled"
+
+	// What happens to a new job while the profile already has one queued or running
+	concurrencyPolicyQueue = "queue"
+	concurrencyPolicySkip  = "skip"
+
+	defaultMaxConcurrentBackups = 4
+	finishedJobRetention        = 7 * 24 * time.Hour
+)
+
+var ErrJobNotQueued = errors.New("backup job is not queued")
+
+func validateConcurrencyPolicy(policy string) error {
+	switch policy {
+	case "", concurrencyPolicyQueue, concurrencyPolicySkip:
+		return nil
+	}
+	return fmt.Errorf("concurrency_policy must be %s or %s", concurrencyPolicyQueue, concurrencyPolicySkip)
+}
+
+// jobQueue starts queued backup jobs in order as soon as the global worker limit and the
+// limits of their server and storage location allow. A profile never runs twice at once.
+type jobQueue struct {
+	mu         sync.Mutex
+	maxWorkers int
+	running    map[uint]*entity.BackupJob // jobID -> running job
+	executor   *BackupExecutor
+}
+
+var backupQueue = &jobQueue{
+	maxWorkers: defaultMaxConcurrentBackups,
+	running:    make(map[uint]*entity.BackupJob),
+	executor:   NewBackupExecutor(),
+}
+
+// StartJobQueue sets the global worker limit and resumes the jobs queued before a restart
+func StartJobQueue(maxWorkers int) {
+	backupQueue.mu.Lock()
+	if maxWorkers > 0 {
+		backupQueue.maxWorkers = maxWorkers
+	}
+	backupQueue.mu.Unlock()
+
+	// Jobs that were running when the server stopped cannot be resumed, neither can their runs
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&entity.BackupRun{}).
+			Where("status = ? AND id IN (?)", "running",
+				tx.Model(&entity.BackupJob{}).Select("backup_run_id").Where("status = ? AND backup_run_id IS NOT NULL", jobStatusRunning)).
+			Updates(map[string]interface{}{
+				"status":        "failed",
+				"error_message": "interrupted by a server restart",
+				"end_time":      now,
+			}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&entity.BackupJob{}).Where("status = ?", jobStatusRunning).Updates(map[string]interface{}{
+			"status":      jobStatusFailed,
+			"message":     "interrupted by a server restart",
+			"finished_at": now,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Failed to mark interrupted backup jobs: %v", err)
+	}
+	backupQueue.dispatch()
+}
+
+// ServiceEnqueueBackup queues a manual backup of a profile, which also runs disabled profiles
+func ServiceEnqueueBackup(profileID uint) (*entity.BackupJob, error) {
+	return enqueueBackup(profileID, true, jobTriggerManual)
+}
+
+// enqueueBackup queues a backup of a profile. The returned job is already running when a
+// worker was free, skipped when the profile's concurrency policy rejects it, or failed
+// when the backup could not be started.
+func enqueueBackup(profileID uint, allowDisabled bool, trigger string) (*entity.BackupJob, error) {
+	var profile entity.BackupProfile
+	if err := DB.Preload("StorageLocation").First(&profile, profileID).Error; err != nil {
+		return nil, err
+	}
+	if !profile.Enabled && !allowDisabled {
+		return nil, fmt.Errorf("backup profile is disabled")
+	}
+	if profile.StorageLocation != nil && !profile.StorageLocation.Enabled {
+		return nil, fmt.Errorf("storage location is disabled")
+	}
+
+	job := &entity.BackupJob{
+		BackupProfileID:   profile.ID,
+		ServerID:          profile.ServerID,
+		StorageLocationID: profile.StorageLocationID,
+		Trigger:           trigger,
+		AllowDisabled:     allowDisabled,
+		Status:            jobStatusQueued,
+		QueuedAt:          time.Now(),
+	}
+
+	backupQueue.mu.Lock()
+	var active []entity.BackupJob
+	if err := DB.Where("backup_profile_id = ? AND status IN ?", profile.ID, []string{jobStatusQueued, jobStatusRunning}).
+		Find(&active).Error; err != nil {
+		backupQueue.mu.Unlock()
+		return nil, err
+	}
+	for _, other := range active {
+		if other.Status == jobStatusQueued {
+			// A second waiting job would back up the same data again
+			job.Status = jobStatusSkipped
+			job.Message = fmt.Sprintf("a backup of this profile is already queued (job %d)", other.ID)
+			break
+		}
+		if profile.ConcurrencyPolicy == concurrencyPolicySkip {
+			job.Status = jobStatusSkipped
+			job.Message = fmt.Sprintf("a backup of this profile is already running (job %d)", other.ID)
+		}
+	}
+	if job.Status == jobStatusSkipped {
+		now := time.Now()
+		job.FinishedAt = &now
+	}
+	err := DB.Create(job).Error
+	backupQueue.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Status == jobStatusSkipped {
+		log.Printf("Skipped %s backup of profile %d: %s", trigger, profile.ID, job.Message)
+		return job, nil
+	}
+	backupQueue.dispatch()
+	return ServiceGetBackupJob(job.ID)
+}
+
+// ServiceGetBackupJob returns a backup job
+func ServiceGetBackupJob(id uint) (*entity.BackupJob, error) {
+	var job entity.BackupJob
+	if err := DB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ServiceListBackupJobs lists the jobs with the given status in queue order, without a
+// status the queued and running jobs
+func ServiceListBackupJobs(status string) ([]entity.BackupJob, error) {
+	query := DB.Order("id")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status IN ?", []string{jobStatusQueued, jobStatusRunning})
+	}
+	var jobs []entity.BackupJob
+	if err := query.Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ServiceCancelBackupJob removes a job from the queue before it started
+func ServiceCancelBackupJob(id uint) (*entity.BackupJob, error) {
+	backupQueue.mu.Lock()
+	defer backupQueue.mu.Unlock()
+
+	job, err := ServiceGetBackupJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != jobStatusQueued {
+		return nil, ErrJobNotQueued
+	}
+	now := time.Now()
+	job.Status = jobStatusCancelled
+	job.FinishedAt = &now
+	if err := DB.Save(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// dispatch starts the queued jobs the limits allow, in the order they were queued. Jobs
+// whose server, storage location or profile is busy wait without blocking later jobs.
+func (q *jobQueue) dispatch() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.running) >= q.maxWorkers {
+		return
+	}
+	var queued []entity.BackupJob
+	if err := DB.Where("status = ?", jobStatusQueued).Order("id").Find(&queued).Error; err != nil {
+		log.Printf("Failed to load queued backup jobs: %v", err)
+		return
+	}
+	if len(queued) == 0 {
+		return
+	}
+
+	perProfile := make(map[uint]int)
+	perServer := make(map[uint]int)
+	perLocation := make(map[uint]int)
+	for _, job := range q.running {
+		perProfile[job.BackupProfileID]++
+		perServer[job.ServerID]++
+		perLocation[job.StorageLocationID]++
+	}
+	serverLimits, locationLimits := q.loadLimits()
+
+	for i := range queued {
+		if len(q.running) >= q.maxWorkers {
+			break
+		}
+		job := &queued[i]
+		if perProfile[job.BackupProfileID] > 0 {
+			continue
+		}
+		if limit := serverLimits[job.ServerID]; limit > 0 && perServer[job.ServerID] >= limit {
+			continue
+		}
+		if limit := locationLimits[job.StorageLocationID]; limit > 0 && perLocation[job.StorageLocationID] >= limit {
+			continue
+		}
+		if q.start(job) {
+			perProfile[job.BackupProfileID]++
+			perServer[job.ServerID]++
+			perLocation[job.StorageLocationID]++
+		}
+	}
+}
+
+// loadLimits returns the concurrency limits of all servers and storage locations that have one
+func (q *jobQueue) loadLimits() (map[uint]int, map[uint]int) {
+	serverLimits := make(map[uint]int)
+	var servers []entity.Server
+	if err := DB.Where("max_concurrent_backups > 0").Find(&servers).Error; err != nil {
+		log.Printf("Failed to load server concurrency limits: %v", err)
+	}
+	for _, server := range servers {
+		serverLimits[server.ID] = server.MaxConcurrentBackups
+	}
+
+	locationLimits := make(map[uint]int)
+	var locations []entity.StorageLocation
+	if err := DB.Where("max_concurrent_backups > 0").Find(&locations).Error; err != nil {
+		log.Printf("Failed to load storage location concurrency limits: %v", err)
+	}
+	for _, location := range locations {
+		locationLimits[location.ID] = location.MaxConcurrentBackups
+	}
+	return serverLimits, locationLimits
+}
+
+// start creates the run of a job and executes it in the background, it reports whether
+// the job occupies a worker
+func (q *jobQueue) start(job *entity.BackupJob) bool {
+	now := time.Now()
+	profile, run, err := q.executor.prepareBackup(job.BackupProfileID, job.AllowDisabled)
+	if err != nil {
+		job.Status = jobStatusFailed
+		job.Message = err.Error()
+		job.FinishedAt = &now
+		if err := DB.Save(job).Error; err != nil {
+			log.Printf("Failed to update backup job %d: %v", job.ID, err)
+		}
+		log.Printf("Backup job %d for profile %d failed to start: %v", job.ID, job.BackupProfileID, err)
+		return false
+	}
+
+	job.Status = jobStatusRunning
+	job.StartedAt = &now
+	job.BackupRunID = &run.ID
+	if err := DB.Save(job).Error; err != nil {
+		log.Printf("Failed to update backup job %d: %v", job.ID, err)
+	}
+	q.running[job.ID] = job
+
+	go func() {
+		if err := q.executor.runBackup(context.Background(), profile, run); err != nil {
+			log.Printf("Backup job %d for profile %d failed: %v", job.ID, job.BackupProfileID, err)
+		}
+		q.finish(job, run)
+	}()
+	return true
+}
+
+// finish records the outcome of a job and hands its worker to the next queued job
+func (q *jobQueue) finish(job *entity.BackupJob, run *entity.BackupRun) {
+	q.mu.Lock()
+	delete(q.running, job.ID)
+	status := jobStatusFailed
+	switch run.Status {
+	case "completed":
+		status = jobStatusCompleted
+	case "cancelled":
+		status = jobStatusCancelled
+	}
+	now := time.Now()
+	if err := DB.Model(job).Updates(map[string]interface{}{"status": status, "finished_at": now}).Error; err != nil {
+		log.Printf("Failed to update backup job %d: %v", job.ID, err)
+	}
+	// Finished jobs are kept for a week, their runs keep the full history
+	DB.Where("status NOT IN ? AND finished_at < ?", []string{jobStatusQueued, jobStatusRunning}, now.Add(-finishedJobRetention)).
+		Delete(&entity.BackupJob{})
+	q.mu.Unlock()
+
+	q.dispatch()
+}
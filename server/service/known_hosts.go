@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHostTarget identifies whose host key is verified. A zero target belongs to a
+// server that is not stored yet, its key is recorded on the first connection afterwards.
+type knownHostTarget struct {
+	serverID          uint
+	storageLocationID uint
+	name              string
+}
+
+func serverHostTarget(server *entity.Server) knownHostTarget {
+	return knownHostTarget{serverID: server.ID, name: server.Name}
+}
+
+func storageLocationHostTarget(location *entity.StorageLocation) knownHostTarget {
+	return knownHostTarget{storageLocationID: location.ID, name: location.Name}
+}
+
+func (t knownHostTarget) isZero() bool {
+	return t.serverID == 0 && t.storageLocationID == 0
+}
+
+func (t knownHostTarget) where() (string, uint) {
+	if t.serverID != 0 {
+		return "server_id = ?", t.serverID
+	}
+	return "storage_location_id = ?", t.storageLocationID
+}
+
+// HostKeyMismatchError is returned when a host presents a key different from the trusted one.
+type HostKeyMismatchError struct {
+	Name     string
+	Address  string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s (%s): expected %s, got %s - accept the new key if the change is expected",
+		e.Name, e.Address, e.Expected, e.Actual)
+}
+
+// applyHostKeyPolicy verifies host keys against the known hosts store and, once a key is
+// trusted, restricts negotiation to its type so the host presents the same key again.
+func applyHostKeyPolicy(config *ssh.ClientConfig, target knownHostTarget) {
+	config.HostKeyCallback = hostKeyCallback(target)
+	if target.isZero() {
+		return
+	}
+	if known, err := findKnownHost(target); err == nil && known != nil {
+		config.HostKeyAlgorithms = hostKeyAlgorithms(known.KeyType)
+	}
+}
+
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+func hostKeyCallback(target knownHostTarget) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if target.isZero() {
+			return nil
+		}
+		return verifyHostKey(target, hostname, key)
+	}
+}
+
+func verifyHostKey(target knownHostTarget, address string, key ssh.PublicKey) error {
+	publicKey := marshalHostKey(key)
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	known, err := findKnownHost(target)
+	if err != nil {
+		return fmt.Errorf("failed to load known host: %v", err)
+	}
+	if known == nil {
+		known = &entity.KnownHost{
+			Address:     address,
+			KeyType:     key.Type(),
+			PublicKey:   publicKey,
+			Fingerprint: fingerprint,
+		}
+		if target.serverID != 0 {
+			known.ServerID = &target.serverID
+		} else {
+			known.StorageLocationID = &target.storageLocationID
+		}
+		if err := DB.Create(known).Error; err == nil {
+			log.Printf("Trusting host key %s for %s (%s) on first use", fingerprint, target.name, address)
+			return nil
+		}
+		// Another connection recorded the key concurrently, compare against that one
+		if known, err = findKnownHost(target); err != nil || known == nil {
+			return fmt.Errorf("failed to record host key for %s", target.name)
+		}
+	}
+
+	if known.PublicKey == publicKey {
+		return nil
+	}
+
+	if known.PendingPublicKey != publicKey {
+		now := time.Now()
+		known.PendingKeyType = key.Type()
+		known.PendingPublicKey = publicKey
+		known.PendingFingerprint = fingerprint
+		known.MismatchAt = &now
+		if err := DB.Save(known).Error; err != nil {
+			log.Printf("Failed to record host key mismatch: %v", err)
+		}
+		if NotificationSvc != nil {
+			var serverID *uint
+			if target.serverID != 0 {
+				serverID = &target.serverID
+			}
+			go NotificationSvc.NotifyHostKeyMismatch(serverID, target.name, fingerprint)
+		}
+	}
+	return &HostKeyMismatchError{
+		Name:     target.name,
+		Address:  address,
+		Expected: known.Fingerprint,
+		Actual:   fingerprint,
+	}
+}
+
+func marshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func findKnownHost(target knownHostTarget) (*entity.KnownHost, error) {
+	query, id := target.where()
+	var hosts []entity.KnownHost
+	if err := DB.Where(query, id).Limit(1).Find(&hosts).Error; err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	return &hosts[0], nil
+}
+
+// ServiceGetServerKnownHost returns the trusted host key of a server, nil if none is recorded yet.
+func ServiceGetServerKnownHost(serverID uint) (*entity.KnownHost, error) {
+	if _, err := GetServerByID(serverID); err != nil {
+		return nil, err
+	}
+	return findKnownHost(knownHostTarget{serverID: serverID})
+}
+
+// ServiceGetStorageLocationKnownHost returns the trusted host key of an SFTP storage location.
+func ServiceGetStorageLocationKnownHost(locationID uint) (*entity.KnownHost, error) {
+	if _, err := ServiceGetStorageLocation(locationID); err != nil {
+		return nil, err
+	}
+	return findKnownHost(knownHostTarget{storageLocationID: locationID})
+}
+
+// ServiceAcceptServerHostKey trusts the pending host key of a server.
+func ServiceAcceptServerHostKey(serverID uint) (*entity.KnownHost, error) {
+	return acceptPendingHostKey(knownHostTarget{serverID: serverID})
+}
+
+// ServiceAcceptStorageLocationHostKey trusts the pending host key of a storage location.
+func ServiceAcceptStorageLocationHostKey(locationID uint) (*entity.KnownHost, error) {
+	return acceptPendingHostKey(knownHostTarget{storageLocationID: locationID})
+}
+
+func acceptPendingHostKey(target knownHostTarget) (*entity.KnownHost, error) {
+	known, err := findKnownHost(target)
+	if err != nil {
+		return nil, err
+	}
+	if known == nil || known.PendingPublicKey == "" {
+		return nil, fmt.Errorf("no pending host key to accept")
+	}
+	known.KeyType = known.PendingKeyType
+	known.PublicKey = known.PendingPublicKey
+	known.Fingerprint = known.PendingFingerprint
+	known.PendingKeyType = ""
+	known.PendingPublicKey = ""
+	known.PendingFingerprint = ""
+	known.MismatchAt = nil
+	if err := DB.Save(known).Error; err != nil {
+		return nil, err
+	}
+	return known, nil
+}
+
+// ServiceResetServerHostKey forgets the host key of a server, the next connection trusts it again.
+func ServiceResetServerHostKey(serverID uint) error {
+	return DB.Where("server_id = ?", serverID).Delete(&entity.KnownHost{}).Error
+}
+
+// ServiceResetStorageLocationHostKey forgets the host key of a storage location.
+func ServiceResetStorageLocationHostKey(locationID uint) error {
+	return DB.Where("storage_location_id = ?", locationID).Delete(&entity.KnownHost{}).Error
+}
@@ -399,6 +399,83 @@ func (n *NotificationService) NotifyConsecutiveFailures(profileID uint, profileN
 	})
 }
 
+// NotifyVerificationFailed sends notification when an integrity verification finds damaged
+// or missing files. Verifications of a storage location only reach preferences without a profile.
+func (n *NotificationService) NotifyVerificationFailed(verificationID uint, profileID *uint, name string, errorMsg string) {
+	data := map[string]string{
+		"type":            "verification_failed",
+		"verification_id": fmt.Sprintf("%d", verificationID),
+	}
+	if profileID != nil {
+		data["profile_id"] = fmt.Sprintf("%d", *profileID)
+	}
+	payload := &NotificationPayload{
+		Title: "Backup Verification Failed",
+		Body:  fmt.Sprintf("Verification of '%s' failed: %s", name, errorMsg),
+		Tag:   fmt.Sprintf("verification-failed-%d", verificationID),
+		Data:  data,
+	}
+
+	n.SendToAll(payload, func(pref *entity.NotificationPreference) bool {
+		if !pref.NotifyOnFailure {
+			return false
+		}
+		if pref.BackupProfileID == nil {
+			return true
+		}
+		return profileID != nil && *pref.BackupProfileID == *profileID
+	})
+}
+
+// NotifyRestoreDrillFailed sends notification when a restore drill could not restore or
+// validate the latest run of a profile
+func (n *NotificationService) NotifyRestoreDrillFailed(drillID, profileID uint, profileName string, errorMsg string) {
+	payload := &NotificationPayload{
+		Title: "Restore Drill Failed",
+		Body:  fmt.Sprintf("Restore drill of '%s' failed: %s", profileName, errorMsg),
+		Tag:   fmt.Sprintf("restore-drill-failed-%d", drillID),
+		Data: map[string]string{
+			"type":       "restore_drill_failed",
+			"drill_id":   fmt.Sprintf("%d", drillID),
+			"profile_id": fmt.Sprintf("%d", profileID),
+		},
+	}
+
+	n.SendToAll(payload, func(pref *entity.NotificationPreference) bool {
+		if !pref.NotifyOnFailure {
+			return false
+		}
+		if pref.BackupProfileID == nil {
+			return true
+		}
+		return *pref.BackupProfileID == profileID
+	})
+}
+
+// NotifyHostKeyMismatch sends notification when a host presents an unknown SSH host key
+func (n *NotificationService) NotifyHostKeyMismatch(serverID *uint, name, fingerprint string) {
+	payload := &NotificationPayload{
+		Title: "SSH Host Key Changed",
+		Body:  fmt.Sprintf("'%s' presented an unknown host key (%s), connections are refused until it is accepted", name, fingerprint),
+		Tag:   fmt.Sprintf("host-key-mismatch-%s", name),
+		Data: map[string]string{
+			"type":        "host_key_mismatch",
+			"name":        name,
+			"fingerprint": fingerprint,
+		},
+	}
+
+	n.SendToAll(payload, func(pref *entity.NotificationPreference) bool {
+		if !pref.NotifyOnFailure || pref.BackupProfileID != nil {
+			return false
+		}
+		if pref.ServerID == nil {
+			return true
+		}
+		return serverID != nil && *pref.ServerID == *serverID
+	})
+}
+
 // NotifyLowStorage sends notification when storage is running low
 func (n *NotificationService) NotifyLowStorage(locationName string, freePercent float64) {
 	payload := &NotificationPayload{
@@ -420,6 +497,24 @@ func (n *NotificationService) NotifyLowStorage(locationName string, freePercent
 	})
 }
 
+// NotifySpacePruned sends notification when retention pruned runs to free space on a storage location
+func (n *NotificationService) NotifySpacePruned(locationName string, runs int, freedBytes int64) {
+	payload := &NotificationPayload{
+		Title: "Backups Pruned for Space",
+		Body:  fmt.Sprintf("Pruned %d backup runs (%.2f MB) from storage location '%s' to free space", runs, float64(freedBytes)/(1024*1024), locationName),
+		Tag:   "space-pruned",
+		Data: map[string]string{
+			"type":     "space_pruned",
+			"location": locationName,
+			"runs":     fmt.Sprintf("%d", runs),
+		},
+	}
+
+	n.SendToAll(payload, func(pref *entity.NotificationPreference) bool {
+		return pref.NotifyOnLowStorage
+	})
+}
+
 // generateVAPIDKeys generates a new ECDSA P-256 key pair for VAPID
 func generateVAPIDKeys() (string, string, error) {
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+
+	"backapp-server/entity"
+
+	"gorm.io/gorm"
+)
+
+// Roles in ascending order of privileges. Viewers can read runs, logs and storage usage,
+// operators can additionally trigger backups, verifications, restores and restore drills
+// and download files, admins can change everything.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// RoleAtLeast reports whether role grants at least the privileges of required.
+func RoleAtLeast(role, required string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[required]
+}
+
+// UserAccess is the set of servers and backup profiles a user may see and act on.
+type UserAccess struct {
+	unrestricted bool
+	servers      map[uint]bool
+	profiles     map[uint]bool
+}
+
+// Unrestricted reports whether the user is not limited by scopes.
+func (a *UserAccess) Unrestricted() bool {
+	return a.unrestricted
+}
+
+func (a *UserAccess) CanAccessServer(serverID uint) bool {
+	return a.unrestricted || a.servers[serverID]
+}
+
+func (a *UserAccess) CanAccessProfile(profileID uint) bool {
+	return a.unrestricted || a.profiles[profileID]
+}
+
+// ServiceGetUserAccess resolves the scopes of a user. Admins and users that are not
+// restricted have unrestricted access, restricted users whose scopes were all deleted
+// with their servers or profiles have none. A server scope grants all profiles of the
+// server, a profile scope only makes its server visible.
+func ServiceGetUserAccess(user *entity.User) (*UserAccess, error) {
+	access := &UserAccess{servers: make(map[uint]bool), profiles: make(map[uint]bool)}
+	if user.Role == RoleAdmin || !user.Restricted {
+		access.unrestricted = true
+		return access, nil
+	}
+
+	scopes, err := ServiceListUserScopes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var serverIDs, profileIDs []uint
+	for _, scope := range scopes {
+		if scope.ServerID != nil {
+			serverIDs = append(serverIDs, *scope.ServerID)
+		}
+		if scope.BackupProfileID != nil {
+			profileIDs = append(profileIDs, *scope.BackupProfileID)
+		}
+	}
+
+	var profiles []entity.BackupProfile
+	if err := DB.Select("id", "server_id").
+		Where("server_id IN ? OR id IN ?", append(serverIDs, 0), append(profileIDs, 0)).
+		Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range serverIDs {
+		access.servers[id] = true
+	}
+	for _, profile := range profiles {
+		access.profiles[profile.ID] = true
+		access.servers[profile.ServerID] = true
+	}
+	return access, nil
+}
+
+func ServiceListUserScopes(userID uint) ([]entity.UserScope, error) {
+	var scopes []entity.UserScope
+	if err := DB.Where("user_id = ?", userID).Order("id").Find(&scopes).Error; err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+// ServiceSetUserScopes replaces the scopes of a user. Each scope names either a server or a
+// backup profile. An empty list removes all restrictions.
+func ServiceSetUserScopes(userID uint, scopes []entity.UserScope) ([]entity.UserScope, error) {
+	if _, err := ServiceGetUser(userID); err != nil {
+		return nil, err
+	}
+	for i := range scopes {
+		scope := &scopes[i]
+		if (scope.ServerID == nil) == (scope.BackupProfileID == nil) {
+			return nil, fmt.Errorf("each scope needs either a server_id or a backup_profile_id")
+		}
+		if scope.ServerID != nil {
+			if _, err := GetServerByID(*scope.ServerID); err != nil {
+				return nil, fmt.Errorf("server %d not found", *scope.ServerID)
+			}
+		}
+		if scope.BackupProfileID != nil {
+			if _, err := ServiceGetBackupProfile(*scope.BackupProfileID); err != nil {
+				return nil, fmt.Errorf("backup profile %d not found", *scope.BackupProfileID)
+			}
+		}
+		scope.ID = 0
+		scope.UserID = userID
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&entity.UserScope{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&entity.User{}).Where("id = ?", userID).Update("restricted", len(scopes) > 0).Error; err != nil {
+			return err
+		}
+		if len(scopes) == 0 {
+			return nil
+		}
+		return tx.Create(&scopes).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ServiceListUserScopes(userID)
+}
+
+// ServiceUpdateUserRole changes the role of a user. The last admin cannot be demoted.
+func ServiceUpdateUserRole(userID uint, role string) (*entity.User, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("invalid role: %s", role)
+	}
+	user, err := ServiceGetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == RoleAdmin && role != RoleAdmin {
+		if err := ensureAnotherAdmin(user.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := DB.Model(user).Update("role", role).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ensureAnotherAdmin fails when userID is the only admin account.
+func ensureAnotherAdmin(userID uint) error {
+	var count int64
+	if err := DB.Model(&entity.User{}).Where("role = ? AND id <> ?", RoleAdmin, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("at least one admin account is required")
+	}
+	return nil
+}
+
+// deleteScopesForProfiles removes scopes that reference deleted backup profiles.
+func deleteScopesForProfiles(profileIDs ...uint) error {
+	if len(profileIDs) == 0 {
+		return nil
+	}
+	return DB.Where("backup_profile_id IN ?", profileIDs).Delete(&entity.UserScope{}).Error
+}
@@ -0,0 +1,378 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+)
+
+const (
+	replicaStatusRunning   = "running"
+	replicaStatusCompleted = "completed"
+	replicaStatusFailed    = "failed"
+)
+
+func ServiceListReplicasForProfile(profileID uint) ([]entity.BackupProfileReplica, error) {
+	var replicas []entity.BackupProfileReplica
+	if err := DB.Preload("StorageLocation").Where("backup_profile_id = ?", profileID).Order("id").Find(&replicas).Error; err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
+
+func ServiceCreateProfileReplica(input *entity.BackupProfileReplica) (*entity.BackupProfileReplica, error) {
+	profile, err := ServiceGetBackupProfile(input.BackupProfileID)
+	if err != nil {
+		return nil, err
+	}
+	if profile.StorageLocationID == input.StorageLocationID {
+		return nil, fmt.Errorf("replica location must differ from the primary storage location")
+	}
+	if _, err := ServiceGetStorageLocation(input.StorageLocationID); err != nil {
+		return nil, fmt.Errorf("storage location not found")
+	}
+
+	var count int64
+	if err := DB.Model(&entity.BackupProfileReplica{}).
+		Where("backup_profile_id = ? AND storage_location_id = ?", input.BackupProfileID, input.StorageLocationID).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("storage location is already a replica of this profile")
+	}
+
+	if err := DB.Create(input).Error; err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+func ServiceDeleteProfileReplica(id uint) error {
+	return DB.Delete(&entity.BackupProfileReplica{}, id).Error
+}
+
+// ServiceListReplicasForRun returns the replica copies of a backup run
+func ServiceListReplicasForRun(runID uint) ([]entity.BackupRunReplica, error) {
+	var replicas []entity.BackupRunReplica
+	if err := DB.Where("backup_run_id = ?", runID).Order("id").Find(&replicas).Error; err != nil {
+		return nil, err
+	}
+	return replicas, nil
+}
+
+// replicateRun copies every file of a finished run from the primary location to each
+// replica location of the profile. Replica failures are recorded per replica and do not
+// fail the run itself. When ctx is cancelled the copies made so far are removed and the
+// context error is returned.
+func (e *BackupExecutor) replicateRun(ctx context.Context, profile *entity.BackupProfile, run *entity.BackupRun) error {
+	replicas, err := ServiceListReplicasForProfile(profile.ID)
+	if err != nil {
+		e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Failed to load replica locations: %v", err))
+		return nil
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+
+	var files []entity.BackupFile
+	if err := DB.Where("backup_run_id = ? AND deleted = ?", run.ID, false).Find(&files).Error; err != nil {
+		e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Failed to load backup files for replication: %v", err))
+		return nil
+	}
+
+	source, err := NewStorageBackend(profile.StorageLocation)
+	if err != nil {
+		e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Failed to open primary storage for replication: %v", err))
+		return nil
+	}
+	defer source.Close()
+
+	for _, replica := range replicas {
+		runReplica := &entity.BackupRunReplica{
+			BackupRunID:       run.ID,
+			StorageLocationID: replica.StorageLocationID,
+			Status:            replicaStatusRunning,
+			StartTime:         time.Now(),
+		}
+		if err := DB.Create(runReplica).Error; err != nil {
+			log.Printf("Failed to create backup run replica record: %v", err)
+			continue
+		}
+
+		e.logToDatabase(run.ID, "INFO", fmt.Sprintf("Replicating backup to storage location: %s", replica.StorageLocation.Name))
+		err := copyRunToReplica(ctx, source, profile.StorageLocation, run, files, replica.StorageLocation, runReplica)
+
+		runReplica.EndTime = time.Now()
+		if ctx.Err() != nil {
+			runReplica.Status = replicaStatusFailed
+			runReplica.ErrorMessage = "Cancelled"
+			if err := DB.Save(runReplica).Error; err != nil {
+				log.Printf("Failed to update backup run replica status: %v", err)
+			}
+			e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Replication to %s cancelled, removing the replica copies", replica.StorageLocation.Name))
+			removeReplicaFiles(run, files)
+			return ctx.Err()
+		}
+		if err != nil {
+			runReplica.Status = replicaStatusFailed
+			runReplica.ErrorMessage = err.Error()
+			e.logToDatabase(run.ID, "WARNING", fmt.Sprintf("Replication to %s failed: %v", replica.StorageLocation.Name, err))
+		} else {
+			runReplica.Status = replicaStatusCompleted
+			e.logToDatabase(run.ID, "INFO", fmt.Sprintf("Replication to %s completed: %d files", replica.StorageLocation.Name, runReplica.TotalFiles))
+		}
+		if err := DB.Save(runReplica).Error; err != nil {
+			log.Printf("Failed to update backup run replica status: %v", err)
+		}
+	}
+	return nil
+}
+
+func copyRunToReplica(ctx context.Context, source StorageBackend, primary *entity.StorageLocation, run *entity.BackupRun, files []entity.BackupFile, location *entity.StorageLocation, runReplica *entity.BackupRunReplica) error {
+	if location == nil {
+		return fmt.Errorf("storage location not found")
+	}
+	if !location.Enabled {
+		return fmt.Errorf("storage location is disabled")
+	}
+
+	relDir, err := relativeStoragePath(primary, StorageBasePath(primary), run.LocalBackupPath)
+	if err != nil {
+		return err
+	}
+	runReplica.BackupPath = JoinStoragePath(location, StorageBasePath(location), relDir)
+
+	target, err := NewStorageBackend(location)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage backend: %v", err)
+	}
+	defer target.Close()
+	runReplica.Encrypted = writesEncrypted(target)
+
+	if err := target.EnsureDir(runReplica.BackupPath); err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	createdDirs := map[string]bool{runReplica.BackupPath: true}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file.LocalPath == "" {
+			continue
+		}
+		destPath, err := replicaFilePath(primary, run, location, runReplica, &file)
+		if err != nil {
+			return err
+		}
+		destDir := storageDir(location, destPath)
+		if !createdDirs[destDir] {
+			if err := target.EnsureDir(destDir); err != nil {
+				return fmt.Errorf("failed to create directory %s: %v", destDir, err)
+			}
+			createdDirs[destDir] = true
+		}
+
+		written, err := copyStorageFile(ctx, source, &file, target, destPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %v", file.LocalPath, err)
+		}
+		runReplica.TotalFiles++
+		runReplica.TotalSizeBytes += written
+	}
+	return nil
+}
+
+func copyStorageFile(ctx context.Context, source StorageBackend, file *entity.BackupFile, target StorageBackend, targetPath string) (int64, error) {
+	reader, err := openStoredFile(source, file.LocalPath, file.Encrypted)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	writer, err := target.OpenWriter(targetPath)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(writer, &contextReader{ctx: ctx, reader: reader})
+	if err != nil {
+		abortWriter(writer, err)
+		return written, err
+	}
+	// Remote backends may only report upload failures on close
+	return written, writer.Close()
+}
+
+// relativeStoragePath returns target relative to base, using forward slashes.
+func relativeStoragePath(location *entity.StorageLocation, base, target string) (string, error) {
+	if NormalizeStorageType(location) == storageTypeLocal {
+		rel, err := filepath.Rel(base, target)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("path %s is outside of %s", target, base)
+		}
+		return filepath.ToSlash(rel), nil
+	}
+	base = path.Clean("/" + base)
+	target = path.Clean("/" + target)
+	if base == "/" {
+		return strings.TrimPrefix(target, "/"), nil
+	}
+	if !strings.HasPrefix(target, base+"/") {
+		return "", fmt.Errorf("path %s is outside of %s", target, base)
+	}
+	return strings.TrimPrefix(target, base+"/"), nil
+}
+
+// storageDir returns the parent directory of a storage path.
+func storageDir(location *entity.StorageLocation, filePath string) string {
+	if NormalizeStorageType(location) == storageTypeLocal {
+		return filepath.Dir(filePath)
+	}
+	return path.Dir(filePath)
+}
+
+// snapshotPath returns the path of a file relative to the backup directory of its run.
+// Files reused by incremental runs are stored in the directory of an earlier run, so the
+// recorded snapshot path takes precedence over the storage path.
+func snapshotPath(primary *entity.StorageLocation, run *entity.BackupRun, file *entity.BackupFile) (string, error) {
+	if file.SnapshotPath != "" {
+		return file.SnapshotPath, nil
+	}
+	return relativeStoragePath(primary, run.LocalBackupPath, file.LocalPath)
+}
+
+// replicaFilePath maps a file of the primary copy of a run to its path on a replica.
+// Replicas always hold a complete copy of the run, including reused files.
+func replicaFilePath(primary *entity.StorageLocation, run *entity.BackupRun, location *entity.StorageLocation, runReplica *entity.BackupRunReplica, file *entity.BackupFile) (string, error) {
+	rel, err := snapshotPath(primary, run, file)
+	if err != nil {
+		return "", err
+	}
+	return JoinStoragePath(location, runReplica.BackupPath, rel), nil
+}
+
+// backendReadCloser closes the storage backend together with the reader.
+type backendReadCloser struct {
+	io.ReadCloser
+	backend StorageBackend
+}
+
+func (r *backendReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.backend.Close()
+	return err
+}
+
+// OpenBackupFileReader opens a backup file from the primary storage location and falls
+// back to completed replicas when the primary copy cannot be read. The returned reader
+// owns its storage backend.
+func OpenBackupFileReader(file *entity.BackupFile) (io.ReadCloser, os.FileInfo, error) {
+	var run entity.BackupRun
+	if err := DB.First(&run, file.BackupRunID).Error; err != nil {
+		return nil, nil, err
+	}
+	primary, err := GetStorageLocationForRun(file.BackupRunID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, info, primaryErr := openStorageFile(primary, file.LocalPath, file.Encrypted)
+	if primaryErr == nil {
+		return reader, info, nil
+	}
+
+	replicas, err := ServiceListReplicasForRun(run.ID)
+	if err != nil {
+		return nil, nil, primaryErr
+	}
+	for i := range replicas {
+		if replicas[i].Status != replicaStatusCompleted {
+			continue
+		}
+		location, err := ServiceGetStorageLocation(replicas[i].StorageLocationID)
+		if err != nil || !location.Enabled {
+			continue
+		}
+		replicaPath, err := replicaFilePath(primary, &run, location, &replicas[i], file)
+		if err != nil {
+			continue
+		}
+		reader, info, err := openStorageFile(location, replicaPath, replicas[i].Encrypted)
+		if err != nil {
+			log.Printf("Replica %s of backup file %d not readable: %v", location.Name, file.ID, err)
+			continue
+		}
+		log.Printf("Primary copy of backup file %d not readable (%v), using replica %s", file.ID, primaryErr, location.Name)
+		return reader, info, nil
+	}
+	return nil, nil, primaryErr
+}
+
+func openStorageFile(location *entity.StorageLocation, filePath string, encrypted bool) (io.ReadCloser, os.FileInfo, error) {
+	backend, err := NewStorageBackend(location)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := backend.Stat(filePath)
+	if err != nil {
+		backend.Close()
+		return nil, nil, err
+	}
+	reader, err := openStoredFile(backend, filePath, encrypted)
+	if err != nil {
+		backend.Close()
+		return nil, nil, err
+	}
+	return &backendReadCloser{ReadCloser: reader, backend: backend}, info, nil
+}
+
+// removeReplicaFiles deletes the replica copies of the given files, best effort.
+func removeReplicaFiles(run *entity.BackupRun, files []entity.BackupFile) {
+	replicas, err := ServiceListReplicasForRun(run.ID)
+	if err != nil || len(replicas) == 0 {
+		return
+	}
+	primary, err := GetStorageLocationForRun(run.ID)
+	if err != nil {
+		return
+	}
+
+	for i := range replicas {
+		location, err := ServiceGetStorageLocation(replicas[i].StorageLocationID)
+		if err != nil {
+			continue
+		}
+		backend, err := NewStorageBackend(location)
+		if err != nil {
+			log.Printf("Failed to open replica storage %s: %v", location.Name, err)
+			continue
+		}
+		dirsToCleanup := make(map[string]bool)
+		for _, file := range files {
+			if file.LocalPath == "" {
+				continue
+			}
+			replicaPath, err := replicaFilePath(primary, run, location, &replicas[i], &file)
+			if err != nil {
+				continue
+			}
+			dirsToCleanup[filepath.Dir(replicaPath)] = true
+			backend.Remove(replicaPath) // Ignore errors, best effort cleanup
+		}
+		if backend.IsLocal() {
+			for dir := range dirsToCleanup {
+				removeEmptyDirs(dir)
+			}
+		}
+		backend.Close()
+	}
+}
@@ -0,0 +1,663 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	restoreStatusPending   = "pending"
+	restoreStatusRunning   = "running"
+	restoreStatusCompleted = "completed"
+	restoreStatusFailed    = "failed"
+
+	restoreConflictOverwrite = "overwrite"
+	restoreConflictSkip      = "skip"
+	restoreConflictRename    = "rename"
+)
+
+// ServiceStartRestore validates a restore of a backup run and starts it in the background.
+// Files are restored to the given server, by default the server of the run's profile. The
+// returned job is updated with the outcome, its progress is logged as restore job logs.
+func ServiceStartRestore(runID uint, input *entity.RestoreJob) (*entity.RestoreJob, error) {
+	var run entity.BackupRun
+	if err := DB.First(&run, runID).Error; err != nil {
+		return nil, err
+	}
+	if run.Status == "running" {
+		return nil, fmt.Errorf("backup run is still running")
+	}
+	profile, err := ServiceGetBackupProfile(run.BackupProfileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup profile: %v", err)
+	}
+
+	serverID := profile.ServerID
+	if input.ServerID != 0 {
+		var servers []entity.Server
+		if err := DB.Where("id = ?", input.ServerID).Limit(1).Find(&servers).Error; err != nil {
+			return nil, err
+		}
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("server %d not found", input.ServerID)
+		}
+		serverID = servers[0].ID
+	}
+
+	job := &entity.RestoreJob{
+		BackupRunID:         run.ID,
+		ServerID:            serverID,
+		FileIDs:             input.FileIDs,
+		TargetPath:          strings.TrimSpace(input.TargetPath),
+		ConflictPolicy:      input.ConflictPolicy,
+		PreservePermissions: input.PreservePermissions,
+		ExtractArchives:     input.ExtractArchives,
+		ArchiveEntries:      input.ArchiveEntries,
+		PreCommands:         trimCommands(input.PreCommands),
+		PostCommands:        trimCommands(input.PostCommands),
+		Status:              restoreStatusPending,
+		StartTime:           time.Now(),
+	}
+	if err := validateRestoreJob(job); err != nil {
+		return nil, err
+	}
+
+	if err := DB.Create(job).Error; err != nil {
+		return nil, fmt.Errorf("failed to create restore job: %v", err)
+	}
+	started := *job
+	go runRestore(job)
+	return &started, nil
+}
+
+// validateRestoreJob applies defaults and checks the options and selected files of a job
+func validateRestoreJob(job *entity.RestoreJob) error {
+	switch job.ConflictPolicy {
+	case "":
+		// Never touch existing files unless asked to
+		job.ConflictPolicy = restoreConflictSkip
+	case restoreConflictOverwrite, restoreConflictSkip, restoreConflictRename:
+	default:
+		return fmt.Errorf("invalid conflict_policy %q, expected overwrite, skip or rename", job.ConflictPolicy)
+	}
+
+	if job.TargetPath != "" {
+		if !path.IsAbs(job.TargetPath) {
+			return fmt.Errorf("target_path must be an absolute path")
+		}
+		job.TargetPath = path.Clean(job.TargetPath)
+	}
+
+	if len(job.FileIDs) > 0 {
+		unique := make(map[uint]bool, len(job.FileIDs))
+		for _, id := range job.FileIDs {
+			unique[id] = true
+		}
+		var count int64
+		if err := DB.Model(&entity.BackupFile{}).
+			Where("id IN ? AND backup_run_id = ? AND deleted = ?", job.FileIDs, job.BackupRunID, false).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(unique) {
+			return fmt.Errorf("file_ids must reference stored files of the backup run")
+		}
+	}
+
+	if len(job.ArchiveEntries) > 0 {
+		if len(job.FileIDs) != 1 {
+			return fmt.Errorf("archive_entries require exactly one file in file_ids")
+		}
+		var file entity.BackupFile
+		if err := DB.First(&file, job.FileIDs[0]).Error; err != nil {
+			return err
+		}
+		var rules []entity.FileRule
+		if err := DB.Where("id = ?", file.FileRuleID).Limit(1).Find(&rules).Error; err != nil {
+			return err
+		}
+		if len(rules) == 0 || !rules[0].Compress || storedArchiveFormat(file.LocalPath) == "" {
+			return fmt.Errorf("archive_entries require an archive of a compressed rule")
+		}
+		for i, name := range job.ArchiveEntries {
+			entryPath, err := safeEntryPath(name)
+			if err != nil {
+				return err
+			}
+			job.ArchiveEntries[i] = entryPath
+		}
+	}
+	return nil
+}
+
+// trimCommands drops empty restore commands
+func trimCommands(commands []string) []string {
+	var trimmed []string
+	for _, command := range commands {
+		if command = strings.TrimSpace(command); command != "" {
+			trimmed = append(trimmed, command)
+		}
+	}
+	return trimmed
+}
+
+func ServiceListRestoreJobsForRun(runID uint) ([]entity.RestoreJob, error) {
+	var jobs []entity.RestoreJob
+	if err := DB.Where("backup_run_id = ?", runID).Order("start_time DESC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func ServiceGetRestoreJob(id uint) (*entity.RestoreJob, error) {
+	var job entity.RestoreJob
+	if err := DB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ServiceGetRestoreJobLogs retrieves all logs for a specific restore job
+func ServiceGetRestoreJobLogs(jobID uint) ([]entity.RestoreJobLog, error) {
+	var logs []entity.RestoreJobLog
+	err := DB.Where("restore_job_id = ?", jobID).
+		Order("timestamp ASC").
+		Find(&logs).Error
+	return logs, err
+}
+
+// restorer copies the files of one restore job to the target server
+type restorer struct {
+	job        *entity.RestoreJob
+	run        *entity.BackupRun
+	location   *entity.StorageLocation
+	sshClient  *SSHClient
+	sftpClient *sftp.Client
+	rules      map[uint]*entity.FileRule
+}
+
+func (r *restorer) logToDatabase(level, message string) {
+	logEntry := &entity.RestoreJobLog{
+		RestoreJobID: r.job.ID,
+		Timestamp:    time.Now(),
+		Level:        level,
+		Message:      message,
+	}
+	if err := DB.Create(logEntry).Error; err != nil {
+		log.Printf("Failed to save log to database: %v", err)
+	}
+	// Also log to console
+	log.Printf("[%s] %s", level, message)
+}
+
+func runRestore(job *entity.RestoreJob) {
+	r := &restorer{job: job, rules: make(map[uint]*entity.FileRule)}
+
+	job.Status = restoreStatusRunning
+	job.StartTime = time.Now()
+	DB.Save(job)
+
+	err := r.execute()
+	job.EndTime = time.Now()
+	switch {
+	case err != nil:
+		job.Status = restoreStatusFailed
+		job.ErrorMessage = err.Error()
+		r.logToDatabase("ERROR", fmt.Sprintf("Restore failed: %v", err))
+	case job.FailedFiles > 0:
+		job.Status = restoreStatusFailed
+		job.ErrorMessage = fmt.Sprintf("%d of %d files failed to restore", job.FailedFiles, job.TotalFiles)
+		r.logToDatabase("ERROR", fmt.Sprintf("Restore finished with errors: %s", job.ErrorMessage))
+	default:
+		job.Status = restoreStatusCompleted
+		r.logToDatabase("INFO", fmt.Sprintf("Restore completed: %d files restored, %d skipped (%.2f MB) in %v",
+			job.RestoredFiles, job.SkippedFiles, float64(job.TotalSizeBytes)/(1024*1024), job.EndTime.Sub(job.StartTime).Round(time.Second)))
+	}
+	if err := DB.Save(job).Error; err != nil {
+		log.Printf("Failed to save restore job %d: %v", job.ID, err)
+	}
+}
+
+func (r *restorer) execute() error {
+	var run entity.BackupRun
+	if err := DB.First(&run, r.job.BackupRunID).Error; err != nil {
+		return fmt.Errorf("failed to load backup run: %v", err)
+	}
+	r.run = &run
+	location, err := GetStorageLocationForRun(run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load storage location: %v", err)
+	}
+	r.location = location
+
+	var server entity.Server
+	if err := DB.First(&server, r.job.ServerID).Error; err != nil {
+		return fmt.Errorf("failed to load server: %v", err)
+	}
+
+	files, err := r.files()
+	if err != nil {
+		return fmt.Errorf("failed to load backup files: %v", err)
+	}
+	r.job.TotalFiles = len(files)
+	DB.Save(r.job)
+
+	target := r.job.TargetPath
+	if target == "" {
+		target = "original paths"
+	}
+	r.logToDatabase("INFO", fmt.Sprintf("Restoring %d files of backup run %d to %s (%s), existing files: %s",
+		len(files), run.ID, server.Name, target, r.job.ConflictPolicy))
+
+	r.logToDatabase("INFO", fmt.Sprintf("Connecting to server: %s@%s:%d", server.Username, server.Host, server.Port))
+	sshClient, err := NewSSHClient(&server)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+	r.sshClient = sshClient
+
+	sftpClient, err := sshClient.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer sftpClient.Close()
+	r.sftpClient = sftpClient
+
+	if err := r.executeCommands(r.job.PreCommands, "pre"); err != nil {
+		return fmt.Errorf("pre-restore commands failed: %v", err)
+	}
+
+	if len(r.job.ArchiveEntries) > 0 {
+		r.restoreArchiveEntries(&files[0])
+	} else {
+		for i := range files {
+			restored, err := r.restoreFile(&files[i])
+			switch {
+			case err != nil:
+				r.job.FailedFiles++
+				r.logToDatabase("ERROR", fmt.Sprintf("Failed to restore %s: %v", files[i].RemotePath, err))
+			case restored:
+				r.job.RestoredFiles++
+			default:
+				r.job.SkippedFiles++
+			}
+		}
+	}
+
+	// Like post-backup commands, post-restore commands only run after a complete restore
+	if r.job.FailedFiles > 0 {
+		if len(r.job.PostCommands) > 0 {
+			r.logToDatabase("WARNING", "Skipping post-restore commands, not all files were restored")
+		}
+		return nil
+	}
+	if err := r.executeCommands(r.job.PostCommands, "post"); err != nil {
+		return fmt.Errorf("post-restore commands failed: %v", err)
+	}
+	return nil
+}
+
+// executeCommands runs restore commands in order on the target server
+func (r *restorer) executeCommands(commands []string, stage string) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	r.logToDatabase("INFO", fmt.Sprintf("Executing %s-restore commands", stage))
+	for _, command := range commands {
+		r.logToDatabase("INFO", fmt.Sprintf("Executing %s command: %s", stage, command))
+		output, err := r.sshClient.RunCommandInDir(command, "/")
+		if err != nil {
+			r.logToDatabase("ERROR", fmt.Sprintf("Command failed: %s, error: %v", command, err))
+			return fmt.Errorf("command '%s' failed: %v, output: %s", command, err, strings.TrimSpace(output))
+		}
+		if output != "" {
+			r.logToDatabase("DEBUG", fmt.Sprintf("Command output: %s", output))
+		}
+	}
+	return nil
+}
+
+// files returns the stored files selected by the job. The checksum manifest is only
+// restored to a target directory, it has no original location.
+func (r *restorer) files() ([]entity.BackupFile, error) {
+	query := DB.Where("backup_run_id = ? AND deleted = ?", r.job.BackupRunID, false)
+	if len(r.job.FileIDs) > 0 {
+		query = query.Where("id IN ?", r.job.FileIDs)
+	}
+	var files []entity.BackupFile
+	if err := query.Order("id").Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	selected := files[:0]
+	for _, file := range files {
+		if r.job.TargetPath == "" && file.FileRuleID == 0 && file.RemotePath == checksumManifestName {
+			continue
+		}
+		selected = append(selected, file)
+	}
+	return selected, nil
+}
+
+func (r *restorer) rule(ruleID uint) *entity.FileRule {
+	if ruleID == 0 {
+		return nil
+	}
+	rule, ok := r.rules[ruleID]
+	if !ok {
+		var rules []entity.FileRule
+		if err := DB.Where("id = ?", ruleID).Limit(1).Find(&rules).Error; err == nil && len(rules) > 0 {
+			rule = &rules[0]
+		}
+		r.rules[ruleID] = rule
+	}
+	return rule
+}
+
+// restoreFile restores one stored file and reports whether it was written. Archives of
+// compressed rules are extracted next to the original directory or into the target path.
+func (r *restorer) restoreFile(file *entity.BackupFile) (bool, error) {
+	snapshot, err := snapshotPath(r.location, r.run, file)
+	if err != nil {
+		return false, err
+	}
+	snapshot = strings.ReplaceAll(snapshot, "\\", "/")
+
+	rule := r.rule(file.FileRuleID)
+	format := ""
+	if rule != nil && rule.Compress {
+		format = storedArchiveFormat(file.LocalPath)
+	}
+	if format == "" {
+		target := path.Join(r.job.TargetPath, snapshot)
+		if r.job.TargetPath == "" {
+			if !path.IsAbs(file.RemotePath) {
+				return false, fmt.Errorf("original location unknown, restore to a target_path instead")
+			}
+			target = file.RemotePath
+		}
+		return r.upload(file, target)
+	}
+
+	destDir := r.job.TargetPath
+	if destDir == "" {
+		destDir = path.Dir(rule.RemotePath)
+	}
+	if !r.job.ExtractArchives {
+		return r.upload(file, path.Join(destDir, path.Base(snapshot)))
+	}
+	if err := r.extract(file, destDir, format, rule.CompressPassword); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// prepareTarget creates the directory of target and applies the conflict policy of the
+// job. It returns the path to write to, or an empty path when the file is skipped.
+func (r *restorer) prepareTarget(target string) (string, error) {
+	if err := r.sftpClient.MkdirAll(path.Dir(target)); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %v", path.Dir(target), err)
+	}
+
+	info, err := r.sftpClient.Stat(target)
+	switch {
+	case err == nil && info.IsDir():
+		return "", fmt.Errorf("%s is a directory", target)
+	case err == nil && r.job.ConflictPolicy == restoreConflictSkip:
+		r.logToDatabase("INFO", fmt.Sprintf("Skipped %s, the file already exists", target))
+		return "", nil
+	case err == nil && r.job.ConflictPolicy == restoreConflictRename:
+		renamed, err := r.freePath(target)
+		if err != nil {
+			return "", err
+		}
+		r.logToDatabase("INFO", fmt.Sprintf("%s already exists, restoring as %s", target, renamed))
+		return renamed, nil
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return "", fmt.Errorf("failed to check %s: %v", target, err)
+	}
+	return target, nil
+}
+
+// upload writes a stored file to target, applying the conflict policy of the job
+func (r *restorer) upload(file *entity.BackupFile, target string) (bool, error) {
+	target, err := r.prepareTarget(target)
+	if err != nil || target == "" {
+		return false, err
+	}
+
+	if _, err := r.copyToRemote(file, target); err != nil {
+		return false, err
+	}
+
+	if r.job.PreservePermissions {
+		if file.RemoteMode != 0 {
+			if err := r.sftpClient.Chmod(target, os.FileMode(file.RemoteMode)); err != nil {
+				r.logToDatabase("WARNING", fmt.Sprintf("Failed to restore permissions of %s: %v", target, err))
+			}
+		}
+		if file.RemoteModTime != nil {
+			if err := r.sftpClient.Chtimes(target, *file.RemoteModTime, *file.RemoteModTime); err != nil {
+				r.logToDatabase("WARNING", fmt.Sprintf("Failed to restore modification time of %s: %v", target, err))
+			}
+		}
+	}
+	r.logToDatabase("DEBUG", fmt.Sprintf("Restored %s (%.2f KB)", target, float64(file.FileSize)/1024))
+	return true, nil
+}
+
+// copyToRemote streams a stored file to the server, replicas are used when the primary
+// copy is not readable. The content is checked against the recorded checksum.
+func (r *restorer) copyToRemote(file *entity.BackupFile, target string) (int64, error) {
+	reader, _, err := OpenBackupFileReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open backup file: %v", err)
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	return r.writeRemote(target, io.TeeReader(reader, hasher), func() error {
+		if checksum := hex.EncodeToString(hasher.Sum(nil)); file.Checksum != "" && checksum != file.Checksum {
+			return fmt.Errorf("restored content of %s does not match the recorded checksum", target)
+		}
+		return nil
+	})
+}
+
+// writeRemote uploads content to a temporary file next to target and renames it over
+// target once verify, when given, accepts it. A failed or corrupt restore leaves an
+// existing file intact, an overwritten file passes its permissions and owner on.
+func (r *restorer) writeRemote(target string, content io.Reader, verify func() error) (int64, error) {
+	tmpPath := path.Join(path.Dir(target), fmt.Sprintf(".backapp-restore-%d-%s", r.job.ID, path.Base(target)))
+	remote, err := r.sftpClient.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", tmpPath, err)
+	}
+	written, err := io.Copy(remote, content)
+	if closeErr := remote.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		r.sftpClient.Remove(tmpPath)
+		return written, fmt.Errorf("failed to write %s: %v", target, err)
+	}
+	if verify != nil {
+		if err := verify(); err != nil {
+			r.sftpClient.Remove(tmpPath)
+			return written, err
+		}
+	}
+
+	if existing, err := r.sftpClient.Stat(target); err == nil {
+		r.sftpClient.Chmod(tmpPath, existing.Mode().Perm())
+		if stat, ok := existing.Sys().(*sftp.FileStat); ok {
+			r.sftpClient.Chown(tmpPath, int(stat.UID), int(stat.GID)) // only succeeds as root, best effort
+		}
+	}
+	if err := r.sftpClient.PosixRename(tmpPath, target); err != nil {
+		r.sftpClient.Remove(tmpPath)
+		return written, fmt.Errorf("failed to replace %s: %v", target, err)
+	}
+	r.job.TotalSizeBytes += written
+	return written, nil
+}
+
+// extract uploads an archive into destDir and extracts it there on the server
+func (r *restorer) extract(file *entity.BackupFile, destDir, format, password string) error {
+	if err := r.sftpClient.MkdirAll(destDir); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", destDir, err)
+	}
+	tmpArchive := path.Join(destDir, fmt.Sprintf(".backapp-restore-%d-%d.%s", r.job.ID, file.ID, format))
+	defer r.sftpClient.Remove(tmpArchive)
+	if _, err := r.copyToRemote(file, tmpArchive); err != nil {
+		return err
+	}
+
+	output, err := r.sshClient.RunCommand(buildExtractCommand(format, tmpArchive, destDir, password, r.job.ConflictPolicy))
+	if err != nil {
+		return fmt.Errorf("failed to extract archive: %s", formatCommandFailure(err, strings.TrimSpace(output)))
+	}
+	r.logToDatabase("INFO", fmt.Sprintf("Extracted %s into %s", path.Base(file.LocalPath), destDir))
+	return nil
+}
+
+// restoreArchiveEntries extracts the selected entries of an archive on this host and
+// uploads only them, next to the original directory or into the target path. Each entry
+// counts as one file of the job.
+func (r *restorer) restoreArchiveEntries(file *entity.BackupFile) {
+	fail := func(err error) {
+		r.job.FailedFiles++
+		r.logToDatabase("ERROR", fmt.Sprintf("Failed to restore entries of %s: %v", path.Base(file.LocalPath), err))
+	}
+	rule := r.rule(file.FileRuleID)
+	if rule == nil {
+		fail(ErrNotAnArchive)
+		return
+	}
+	archive, err := openStoredArchive(file)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer archive.Close()
+	all, err := archive.entries()
+	if err != nil {
+		fail(err)
+		return
+	}
+	entries, err := selectArchiveEntries(all, r.job.ArchiveEntries)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	destDir := r.job.TargetPath
+	if destDir == "" {
+		destDir = path.Dir(rule.RemotePath)
+	}
+	r.job.TotalFiles = len(entries)
+	DB.Save(r.job)
+	r.logToDatabase("INFO", fmt.Sprintf("Restoring %d entries of %s into %s", len(entries), path.Base(file.LocalPath), destDir))
+	for i := range entries {
+		restored, err := r.restoreArchiveEntry(archive, &entries[i], destDir)
+		switch {
+		case err != nil:
+			r.job.FailedFiles++
+			r.logToDatabase("ERROR", fmt.Sprintf("Failed to restore %s: %v", entries[i].Path, err))
+		case restored:
+			r.job.RestoredFiles++
+		default:
+			r.job.SkippedFiles++
+		}
+	}
+}
+
+func (r *restorer) restoreArchiveEntry(archive *storedArchive, entry *ArchiveEntry, destDir string) (bool, error) {
+	entryPath, err := safeEntryPath(entry.Path)
+	if err != nil {
+		return false, err
+	}
+	target, err := r.prepareTarget(path.Join(destDir, entryPath))
+	if err != nil || target == "" {
+		return false, err
+	}
+
+	reader, err := archive.open(entry)
+	if err != nil {
+		return false, err
+	}
+	written, err := r.writeRemote(target, reader, nil)
+	reader.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if r.job.PreservePermissions {
+		if entry.Mode != 0 {
+			if err := r.sftpClient.Chmod(target, os.FileMode(entry.Mode)); err != nil {
+				r.logToDatabase("WARNING", fmt.Sprintf("Failed to restore permissions of %s: %v", target, err))
+			}
+		}
+		if entry.ModTime != nil {
+			if err := r.sftpClient.Chtimes(target, *entry.ModTime, *entry.ModTime); err != nil {
+				r.logToDatabase("WARNING", fmt.Sprintf("Failed to restore modification time of %s: %v", target, err))
+			}
+		}
+	}
+	r.logToDatabase("DEBUG", fmt.Sprintf("Restored %s (%.2f KB)", target, float64(written)/1024))
+	return true, nil
+}
+
+// buildExtractCommand returns the command extracting an archive into destDir. Zip archives
+// are extracted with unzip, renaming conflicting files requires 7z.
+func buildExtractCommand(format, archive, destDir, password, conflictPolicy string) string {
+	if format == "zip" && conflictPolicy != restoreConflictRename {
+		flag := "-n"
+		if conflictPolicy == restoreConflictOverwrite {
+			flag = "-o"
+		}
+		return fmt.Sprintf("unzip -q %s %s -d %s", flag, shellQuote(archive), shellQuote(destDir))
+	}
+
+	overwriteMode := map[string]string{
+		restoreConflictOverwrite: "-aoa",
+		restoreConflictSkip:      "-aos",
+		restoreConflictRename:    "-aou",
+	}[conflictPolicy]
+	passwordArgs := ""
+	if password != "" {
+		passwordArgs = " -p" + shellQuote(password)
+	}
+	return fmt.Sprintf("7z x -bd -y %s%s -o%s %s", overwriteMode, passwordArgs, shellQuote(destDir), shellQuote(archive))
+}
+
+// freePath returns a name next to target that does not exist yet
+func (r *restorer) freePath(target string) (string, error) {
+	ext := path.Ext(target)
+	if ext == path.Base(target) {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(target, ext)
+	for i := 1; i <= 1000; i++ {
+		candidate := fmt.Sprintf("%s.restored-%d%s", stem, i, ext)
+		if _, err := r.sftpClient.Stat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		} else if err != nil {
+			return "", fmt.Errorf("failed to check %s: %v", candidate, err)
+		}
+	}
+	return "", fmt.Errorf("no free name found for %s", target)
+}
@@ -0,0 +1,544 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+)
+
+const (
+	restoreDrillStatusPending = "pending"
+	restoreDrillStatusRunning = "running"
+	restoreDrillStatusPassed  = "passed"
+	restoreDrillStatusFailed  = "failed"
+	restoreDrillStatusError   = "error"
+
+	// restoreDrillCommandTimeout bounds local validation commands
+	restoreDrillCommandTimeout = time.Hour
+	restoreDrillMaxFailures    = 50
+	restoreDrillMaxOutput      = 64 * 1024
+)
+
+// restoreDrillMu runs one restore drill at a time, they read and write complete runs
+var restoreDrillMu sync.Mutex
+
+// ServiceStartRestoreDrill starts a restore drill of a profile in the background. The
+// returned record is updated with the outcome.
+func ServiceStartRestoreDrill(profileID uint) (*entity.RestoreDrill, error) {
+	drill, err := prepareRestoreDrill(profileID, verificationTriggerManual)
+	if err != nil {
+		return nil, err
+	}
+	started := *drill
+	go runRestoreDrill(drill)
+	return &started, nil
+}
+
+// ServiceListRestoreDrills lists the drills of a profile, or of all profiles for 0
+func ServiceListRestoreDrills(profileID uint) ([]entity.RestoreDrill, error) {
+	query := DB.Model(&entity.RestoreDrill{})
+	if profileID != 0 {
+		query = query.Where("backup_profile_id = ?", profileID)
+	}
+	var drills []entity.RestoreDrill
+	if err := query.Order("start_time DESC").Find(&drills).Error; err != nil {
+		return nil, err
+	}
+	return drills, nil
+}
+
+func ServiceGetRestoreDrill(id uint) (*entity.RestoreDrill, error) {
+	var drill entity.RestoreDrill
+	if err := DB.First(&drill, id).Error; err != nil {
+		return nil, err
+	}
+	return &drill, nil
+}
+
+// prepareRestoreDrill creates the drill record from the drill settings of the profile
+func prepareRestoreDrill(profileID uint, trigger string) (*entity.RestoreDrill, error) {
+	profile, err := ServiceGetBackupProfile(profileID)
+	if err != nil {
+		return nil, err
+	}
+	drill := &entity.RestoreDrill{
+		BackupProfileID:   profile.ID,
+		ServerID:          profile.RestoreDrillServerID,
+		Trigger:           trigger,
+		Status:            restoreDrillStatusPending,
+		StartTime:         time.Now(),
+		ValidationCommand: strings.TrimSpace(profile.RestoreDrillCommand),
+	}
+	if err := DB.Create(drill).Error; err != nil {
+		return nil, fmt.Errorf("failed to create restore drill: %v", err)
+	}
+	return drill, nil
+}
+
+// runRestoreDrill restores the latest successful run of the profile to a scratch location,
+// checks the restored files against the recorded checksums and file count and runs the
+// validation command. The scratch location is removed afterwards.
+func runRestoreDrill(drill *entity.RestoreDrill) {
+	restoreDrillMu.Lock()
+	defer restoreDrillMu.Unlock()
+
+	drill.Status = restoreDrillStatusRunning
+	drill.StartTime = time.Now()
+	DB.Save(drill)
+
+	err := executeRestoreDrill(drill)
+	drill.EndTime = time.Now()
+	switch {
+	case err != nil:
+		drill.Status = restoreDrillStatusError
+		drill.ErrorMessage = err.Error()
+		log.Printf("Restore drill %d failed: %v", drill.ID, err)
+	case drill.FailedFiles > 0 || drill.RestoredFiles != drill.ExpectedFiles || (drill.ValidationPassed != nil && !*drill.ValidationPassed):
+		drill.Status = restoreDrillStatusFailed
+		drill.ErrorMessage = restoreDrillSummary(drill)
+		log.Printf("Restore drill %d failed: %s", drill.ID, drill.ErrorMessage)
+	default:
+		drill.Status = restoreDrillStatusPassed
+		log.Printf("Restore drill %d passed, %d files restored and %d verified", drill.ID, drill.RestoredFiles, drill.VerifiedFiles)
+	}
+	if err := DB.Save(drill).Error; err != nil {
+		log.Printf("Failed to save restore drill %d: %v", drill.ID, err)
+	}
+
+	if drill.Status != restoreDrillStatusPassed && NotificationSvc != nil {
+		name := fmt.Sprintf("profile %d", drill.BackupProfileID)
+		if profile, err := ServiceGetBackupProfile(drill.BackupProfileID); err == nil {
+			name = profile.Name
+		}
+		NotificationSvc.NotifyRestoreDrillFailed(drill.ID, drill.BackupProfileID, name, drill.ErrorMessage)
+	}
+}
+
+func restoreDrillSummary(drill *entity.RestoreDrill) string {
+	var problems []string
+	if drill.RestoredFiles != drill.ExpectedFiles {
+		problems = append(problems, fmt.Sprintf("%d of %d files restored", drill.RestoredFiles, drill.ExpectedFiles))
+	}
+	if drill.FailedFiles > 0 {
+		problems = append(problems, fmt.Sprintf("%d files failed", drill.FailedFiles))
+	}
+	if drill.ValidationPassed != nil && !*drill.ValidationPassed {
+		problems = append(problems, "validation command failed")
+	}
+	return strings.Join(problems, ", ")
+}
+
+func executeRestoreDrill(drill *entity.RestoreDrill) error {
+	profile, err := ServiceGetBackupProfile(drill.BackupProfileID)
+	if err != nil {
+		return fmt.Errorf("failed to load backup profile: %v", err)
+	}
+
+	var runs []entity.BackupRun
+	if err := DB.Where("backup_profile_id = ? AND status = ? AND retention_cleaned_up = ?", profile.ID, "completed", false).
+		Order("start_time DESC").Limit(1).Find(&runs).Error; err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("no successful backup run to restore")
+	}
+	run := &runs[0]
+	drill.BackupRunID = &run.ID
+
+	var count int64
+	if err := DB.Model(&entity.BackupFile{}).Where("backup_run_id = ? AND deleted = ?", run.ID, false).Count(&count).Error; err != nil {
+		return err
+	}
+	drill.ExpectedFiles = int(count)
+	DB.Save(drill)
+
+	drill.ScratchPath = profile.RestoreDrillPath
+	if drill.ServerID != nil {
+		return executeRemoteRestoreDrill(drill, run)
+	}
+	return executeLocalRestoreDrill(drill, run)
+}
+
+// addRestoreDrillFailure records a problem of the drill, the list is capped
+func addRestoreDrillFailure(drill *entity.RestoreDrill, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("Restore drill %d: %s", drill.ID, message)
+	if len(drill.Failures) < restoreDrillMaxFailures {
+		drill.Failures = append(drill.Failures, message)
+	}
+}
+
+// executeLocalRestoreDrill restores the run into a temporary directory on this host with
+// the layout of a restore to a target path, archives of compressed rules are extracted.
+func executeLocalRestoreDrill(drill *entity.RestoreDrill, run *entity.BackupRun) error {
+	parent := drill.ScratchPath
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", parent, err)
+	}
+	scratch, err := os.MkdirTemp(parent, fmt.Sprintf("backapp-drill-%d-", drill.ID))
+	if err != nil {
+		return fmt.Errorf("failed to create scratch directory: %v", err)
+	}
+	defer os.RemoveAll(scratch)
+	drill.ScratchPath = scratch
+	DB.Save(drill)
+
+	location, err := GetStorageLocationForRun(run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load storage location: %v", err)
+	}
+	var files []entity.BackupFile
+	if err := DB.Where("backup_run_id = ? AND deleted = ?", run.ID, false).Order("id").Find(&files).Error; err != nil {
+		return err
+	}
+
+	rules := make(map[uint]*entity.FileRule)
+	for i := range files {
+		file := &files[i]
+		snapshot, err := snapshotPath(location, run, file)
+		if err != nil {
+			drill.FailedFiles++
+			addRestoreDrillFailure(drill, "%s: %v", file.RemotePath, err)
+			continue
+		}
+		target := filepath.Join(scratch, filepath.FromSlash(strings.ReplaceAll(snapshot, "\\", "/")))
+		if err := restoreDrillFileLocal(drill, file, target); err != nil {
+			drill.FailedFiles++
+			addRestoreDrillFailure(drill, "%s: %v", snapshot, err)
+			continue
+		}
+
+		rule, ok := rules[file.FileRuleID]
+		if !ok && file.FileRuleID != 0 {
+			var found []entity.FileRule
+			if err := DB.Where("id = ?", file.FileRuleID).Limit(1).Find(&found).Error; err == nil && len(found) > 0 {
+				rule = &found[0]
+			}
+			rules[file.FileRuleID] = rule
+		}
+		if rule == nil || !rule.Compress {
+			continue
+		}
+		format := storedArchiveFormat(file.LocalPath)
+		if format == "" {
+			continue
+		}
+		password := ""
+		if format == "7z" {
+			password = rule.CompressPassword
+		}
+		err = extractLocalArchive(target, format, password, scratch)
+		os.Remove(target)
+		if err != nil {
+			drill.FailedFiles++
+			addRestoreDrillFailure(drill, "%s: %v", snapshot, err)
+		}
+	}
+	DB.Save(drill)
+
+	if drill.ValidationCommand == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), restoreDrillCommandTimeout)
+	defer cancel()
+	cmd := localShellCommand(ctx, drill.ValidationCommand)
+	cmd.Dir = scratch
+	cmd.Env = append(os.Environ(), "BACKAPP_DRILL_DIR="+scratch, fmt.Sprintf("BACKAPP_RUN_ID=%d", run.ID))
+	output, err := cmd.CombinedOutput()
+	recordRestoreDrillValidation(drill, string(output), err)
+	return nil
+}
+
+// restoreDrillFileLocal copies a stored file to target and verifies the written copy
+// against the recorded checksum
+func restoreDrillFileLocal(drill *entity.RestoreDrill, file *entity.BackupFile, target string) error {
+	reader, _, err := OpenBackupFileReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %v", err)
+	}
+	defer reader.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(out, reader)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write: %v", err)
+	}
+	drill.RestoredFiles++
+	drill.BytesRestored += written
+
+	if file.Checksum == "" {
+		return nil
+	}
+	checksum, err := localFileChecksum(target)
+	if err != nil {
+		return fmt.Errorf("failed to read restored file: %v", err)
+	}
+	if checksum != file.Checksum {
+		return fmt.Errorf("checksum %s does not match the recorded %s", checksum, file.Checksum)
+	}
+	drill.VerifiedFiles++
+	return nil
+}
+
+// extractLocalArchive extracts an archive into destDir, zip entries are checked against
+// their CRC while they are written
+func extractLocalArchive(archivePath, format, password, destDir string) error {
+	if format == "7z" {
+		if _, err := exec.LookPath("7z"); err != nil {
+			return fmt.Errorf("7z is required to extract 7z archives")
+		}
+		args := []string{"x", "-bd", "-y", "-o" + destDir}
+		if password != "" {
+			args = append(args, "-p"+password)
+		}
+		output, err := exec.Command("7z", append(args, archivePath)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("7z extract failed: %s", formatCommandFailure(err, lastLines(string(output), 3)))
+		}
+		return nil
+	}
+
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %v", err)
+	}
+	defer reader.Close()
+	for _, entry := range reader.File {
+		name, err := safeEntryPath(entry.Name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, filepath.FromSlash(name))
+		if entry.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := extractZipEntry(entry, target); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(entry *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode().Perm()|0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, rc)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// localShellCommand runs a command line with the shell of this host
+func localShellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// executeRemoteRestoreDrill restores the run with a restore job into a scratch directory on
+// the test server, verifies the files there with sha256sum and removes the directory.
+func executeRemoteRestoreDrill(drill *entity.RestoreDrill, run *entity.BackupRun) error {
+	var server entity.Server
+	if err := DB.First(&server, *drill.ServerID).Error; err != nil {
+		return fmt.Errorf("failed to load test server: %v", err)
+	}
+	parent := drill.ScratchPath
+	if parent == "" {
+		parent = "/tmp"
+	}
+	if !path.IsAbs(parent) {
+		return fmt.Errorf("restore drill path must be absolute on a test server")
+	}
+	scratch := path.Join(parent, fmt.Sprintf("backapp-drill-%d", drill.ID))
+	drill.ScratchPath = scratch
+
+	job := &entity.RestoreJob{
+		BackupRunID:     run.ID,
+		ServerID:        server.ID,
+		TargetPath:      scratch,
+		ConflictPolicy:  restoreConflictOverwrite,
+		ExtractArchives: true,
+		Status:          restoreStatusPending,
+		StartTime:       time.Now(),
+	}
+	if err := DB.Create(job).Error; err != nil {
+		return fmt.Errorf("failed to create restore job: %v", err)
+	}
+	drill.RestoreJobID = &job.ID
+	DB.Save(drill)
+
+	sshClient, err := NewSSHClient(&server)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+	defer func() {
+		if output, err := sshClient.RunCommand("rm -rf " + shellQuote(scratch)); err != nil {
+			log.Printf("Failed to remove restore drill directory %s on %s: %v %s", scratch, server.Name, err, strings.TrimSpace(output))
+		}
+	}()
+
+	runRestore(job)
+	drill.RestoredFiles = job.RestoredFiles
+	drill.FailedFiles = job.FailedFiles
+	drill.BytesRestored = job.TotalSizeBytes
+	if job.Status != restoreStatusCompleted {
+		addRestoreDrillFailure(drill, "restore job %d: %s", job.ID, job.ErrorMessage)
+		return nil
+	}
+
+	if err := verifyRemoteRestoreDrill(drill, run, sshClient, scratch); err != nil {
+		return err
+	}
+	DB.Save(drill)
+
+	if drill.ValidationCommand == "" {
+		return nil
+	}
+	command := fmt.Sprintf("BACKAPP_DRILL_DIR=%s BACKAPP_RUN_ID=%d sh -c %s", shellQuote(scratch), run.ID, shellQuote(drill.ValidationCommand))
+	output, err := sshClient.RunCommandInDir(command, scratch)
+	recordRestoreDrillValidation(drill, output, err)
+	return nil
+}
+
+// verifyRemoteRestoreDrill checks the restored files against their recorded checksums on
+// the server, archives are extracted and no longer there
+func verifyRemoteRestoreDrill(drill *entity.RestoreDrill, run *entity.BackupRun, sshClient *SSHClient, scratch string) error {
+	location, err := GetStorageLocationForRun(run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load storage location: %v", err)
+	}
+	var files []entity.BackupFile
+	if err := DB.Where("backup_run_id = ? AND deleted = ? AND checksum <> ''", run.ID, false).Order("id").Find(&files).Error; err != nil {
+		return err
+	}
+	var manifest strings.Builder
+	listed := 0
+	for i := range files {
+		if format := storedArchiveFormat(files[i].LocalPath); format != "" && files[i].FileRuleID != 0 {
+			var rules []entity.FileRule
+			if err := DB.Where("id = ?", files[i].FileRuleID).Limit(1).Find(&rules).Error; err == nil && len(rules) > 0 && rules[0].Compress {
+				// Extracted archives were checked against their checksum while streaming
+				drill.VerifiedFiles++
+				continue
+			}
+		}
+		snapshot, err := snapshotPath(location, run, &files[i])
+		if err != nil {
+			drill.FailedFiles++
+			addRestoreDrillFailure(drill, "%s: %v", files[i].RemotePath, err)
+			continue
+		}
+		fmt.Fprintf(&manifest, "%s  %s\n", files[i].Checksum, strings.ReplaceAll(snapshot, "\\", "/"))
+		listed++
+	}
+	if manifest.Len() == 0 {
+		return nil
+	}
+
+	sftpClient, err := sshClient.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer sftpClient.Close()
+	manifestPath := path.Join(scratch, ".backapp-drill.sha256")
+	remote, err := sftpClient.Create(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to write checksum list: %v", err)
+	}
+	_, err = io.WriteString(remote, manifest.String())
+	if closeErr := remote.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write checksum list: %v", err)
+	}
+	defer sftpClient.Remove(manifestPath)
+
+	// shasum is the fallback on BSD and macOS hosts, both print "path: OK" per file
+	list := shellQuote(path.Base(manifestPath))
+	output, err := sshClient.RunCommandInDir(fmt.Sprintf(
+		"if command -v sha256sum >/dev/null 2>&1; then sha256sum -c %s; else shasum -a 256 -c %s; fi", list, list), scratch)
+	verified, failed := 0, 0
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		name, result, ok := strings.Cut(line, ": ")
+		switch {
+		case !ok:
+			continue
+		case result == "OK":
+			verified++
+		case strings.HasPrefix(result, "FAILED"):
+			failed++
+			addRestoreDrillFailure(drill, "%s: checksum verification %s", name, strings.ToLower(result))
+		}
+	}
+	drill.VerifiedFiles += verified
+	drill.FailedFiles += failed
+
+	// The command also exits with an error on mismatches, without any it did not check the files
+	if err != nil && failed == 0 {
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		return fmt.Errorf("checksum verification failed: %v: %s", err, lines[len(lines)-1])
+	}
+	if missing := listed - verified - failed; missing > 0 {
+		drill.FailedFiles += missing
+		addRestoreDrillFailure(drill, "checksum verification reported %d of %d files", verified+failed, listed)
+	}
+	return nil
+}
+
+// recordRestoreDrillValidation stores the outcome of the validation command, long output
+// is cut to its end
+func recordRestoreDrillValidation(drill *entity.RestoreDrill, output string, err error) {
+	if len(output) > restoreDrillMaxOutput {
+		output = "..." + output[len(output)-restoreDrillMaxOutput:]
+	}
+	drill.ValidationOutput = output
+	passed := err == nil
+	drill.ValidationPassed = &passed
+	if !passed {
+		addRestoreDrillFailure(drill, "validation command failed: %v", err)
+	}
+}
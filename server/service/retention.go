@@ -2,11 +2,17 @@ package service
 
 import (
 	"log"
+	"sort"
+	"sync"
 	"time"
 
 	"backapp-server/entity"
 )
 
+// retentionMu keeps the hourly cleanup and the free space checks after backups from
+// pruning the same runs at once
+var retentionMu sync.Mutex
+
 // RetentionCleanup handles automatic deletion of old backup files
 type RetentionCleanup struct{}
 
@@ -15,9 +21,12 @@ func NewRetentionCleanup() *RetentionCleanup {
 	return &RetentionCleanup{}
 }
 
-// RunCleanup checks all backup profiles and deletes files older than retention period
+// RunCleanup applies the retention policy of every backup profile
 func (r *RetentionCleanup) RunCleanup() {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
 	log.Println("Starting retention cleanup...")
+	releaseExpiredHolds()
 
 	var profiles []entity.BackupProfile
 	if err := DB.Find(&profiles).Error; err != nil {
@@ -26,7 +35,7 @@ func (r *RetentionCleanup) RunCleanup() {
 	}
 
 	for _, profile := range profiles {
-		if profile.RetentionDays == nil || *profile.RetentionDays <= 0 {
+		if !retentionPolicyForProfile(&profile).Enabled() {
 			// No retention policy, skip
 			continue
 		}
@@ -34,32 +43,81 @@ func (r *RetentionCleanup) RunCleanup() {
 		r.cleanupProfile(&profile)
 	}
 
+	var locations []entity.StorageLocation
+	if err := DB.Where("enabled = ? AND min_free_percent > 0", true).Find(&locations).Error; err != nil {
+		log.Printf("Failed to load storage locations for free space retention: %v", err)
+	}
+	for i := range locations {
+		r.freeSpace(&locations[i])
+	}
+
+	r.collectGarbage()
+
 	log.Println("Retention cleanup completed")
 }
 
-// cleanupProfile deletes old backup files for a specific profile
-func (r *RetentionCleanup) cleanupProfile(profile *entity.BackupProfile) {
-	retentionDays := *profile.RetentionDays
-	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
+// collectGarbage removes chunks of deduplicated storage locations that no file references anymore
+func (r *RetentionCleanup) collectGarbage() {
+	var locationIDs []uint
+	if err := DB.Model(&entity.DedupChunk{}).Distinct("storage_location_id").Pluck("storage_location_id", &locationIDs).Error; err != nil {
+		log.Printf("Failed to load deduplicated storage locations: %v", err)
+		return
+	}
 
-	log.Printf("Cleaning up profile %s (ID: %d) - retention: %d days, cutoff: %s",
-		profile.Name, profile.ID, retentionDays, cutoffTime.Format(time.RFC3339))
+	for _, locationID := range locationIDs {
+		location, err := ServiceGetStorageLocation(locationID)
+		if err != nil {
+			log.Printf("Failed to load storage location %d for garbage collection: %v", locationID, err)
+			continue
+		}
+		if !location.Enabled {
+			continue
+		}
+		removed, freed, err := collectDedupGarbage(location)
+		if err != nil {
+			log.Printf("Failed to collect unreferenced chunks of storage location %s: %v", location.Name, err)
+		}
+		if removed > 0 {
+			log.Printf("Removed %d unreferenced chunks (%.2f MB) from storage location %s",
+				removed, float64(freed)/(1024*1024), location.Name)
+		}
+	}
+}
 
-	// Find backup runs older than the retention period that haven't been cleaned up yet
-	var oldRuns []entity.BackupRun
-	if err := DB.Where("backup_profile_id = ? AND end_time < ? AND status = ? AND retention_cleaned_up = ?",
-		profile.ID, cutoffTime, "completed", false).
-		Preload("BackupFiles").
-		Find(&oldRuns).Error; err != nil {
-		log.Printf("Failed to find old backup runs for profile %d: %v", profile.ID, err)
+// cleanupProfile deletes the files of the runs of a profile that no retention rule keeps
+func (r *RetentionCleanup) cleanupProfile(profile *entity.BackupProfile) {
+	policy := retentionPolicyForProfile(profile)
+	log.Printf("Cleaning up profile %s (ID: %d) - retention: within %d days, last %d, daily %d, weekly %d, monthly %d, yearly %d, max %d bytes",
+		profile.Name, profile.ID, policy.KeepWithinDays, policy.KeepLast, policy.KeepDaily, policy.KeepWeekly, policy.KeepMonthly, policy.KeepYearly, policy.MaxSizeBytes)
+
+	runs, err := retentionCandidates(profile.ID)
+	if err != nil {
+		log.Printf("Failed to find backup runs for profile %d: %v", profile.ID, err)
+		return
+	}
+	sizes, err := storedRunSizes(runs)
+	if err != nil {
+		log.Printf("Failed to calculate stored sizes for profile %d: %v", profile.ID, err)
 		return
 	}
 
-	if len(oldRuns) == 0 {
+	var pruned []uint
+	for _, decision := range evaluateRetention(runs, sizes, policy, time.Now()) {
+		if !decision.Keep {
+			pruned = append(pruned, decision.BackupRunID)
+		}
+	}
+	if len(pruned) == 0 {
 		log.Printf("No old backup runs found for profile %d", profile.ID)
 		return
 	}
 
+	var oldRuns []entity.BackupRun
+	if err := DB.Where("id IN ?", pruned).Preload("BackupFiles").Find(&oldRuns).Error; err != nil {
+		log.Printf("Failed to load old backup runs for profile %d: %v", profile.ID, err)
+		return
+	}
+
 	log.Printf("Found %d old backup runs to clean up for profile %d", len(oldRuns), profile.ID)
 
 	for _, run := range oldRuns {
@@ -67,8 +125,115 @@ func (r *RetentionCleanup) cleanupProfile(profile *entity.BackupProfile) {
 	}
 }
 
-// cleanupRun deletes all files associated with a backup run using existing service function
-func (r *RetentionCleanup) cleanupRun(run *entity.BackupRun) {
+// EnforceFreeSpace prunes runs when the storage location has less free space than its
+// min_free_percent, it is checked before and after each backup besides the hourly cleanup
+func EnforceFreeSpace(locationID uint) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	location, err := ServiceGetStorageLocation(locationID)
+	if err != nil {
+		log.Printf("Failed to load storage location %d for free space retention: %v", locationID, err)
+		return
+	}
+	if !location.Enabled || location.MinFreePercent <= 0 {
+		return
+	}
+	NewRetentionCleanup().freeSpace(location)
+}
+
+// freeSpace prunes the oldest prunable runs across all profiles of the location until the
+// free space reported by GetStorageLocationUsage reaches min_free_percent. The newest
+// completed run of every profile is kept, so a profile never loses its last backup. Usage
+// is measured again after every run, pruning stops when it did not free any space.
+func (r *RetentionCleanup) freeSpace(location *entity.StorageLocation) {
+	if location.Dedup {
+		// Pruned runs only release chunks, garbage collection removes them later
+		log.Printf("Storage location %s is deduplicated, min_free_percent is not enforced", location.Name)
+		return
+	}
+	usage, err := GetStorageLocationUsage(location.ID)
+	if err != nil {
+		log.Printf("Failed to get usage of storage location %s: %v", location.Name, err)
+		return
+	}
+	if usage.TotalBytes <= 0 {
+		log.Printf("Storage location %s does not report its capacity, min_free_percent is not enforced", location.Name)
+		return
+	}
+	if usage.FreePercent >= location.MinFreePercent {
+		return
+	}
+
+	var profiles []entity.BackupProfile
+	if err := DB.Where("storage_location_id = ?", location.ID).Find(&profiles).Error; err != nil {
+		log.Printf("Failed to load backup profiles of storage location %s: %v", location.Name, err)
+		return
+	}
+	var candidates []entity.BackupRun
+	for _, profile := range profiles {
+		runs, err := retentionCandidates(profile.ID)
+		if err != nil {
+			log.Printf("Failed to find backup runs for profile %d: %v", profile.ID, err)
+			return
+		}
+		// Runs are ordered newest first, the newest one, held and locked ones are never pruned
+		for i := 1; i < len(runs); i++ {
+			if checkRunRemovable(&runs[i]) == nil {
+				candidates = append(candidates, runs[i])
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].EndTime.Before(candidates[j].EndTime) })
+
+	needed := int64(location.MinFreePercent/100*float64(usage.TotalBytes)) - usage.FreeBytes
+	log.Printf("Storage location %s has %.1f%% free space, below %.1f%%: pruning up to %.2f MB",
+		location.Name, usage.FreePercent, location.MinFreePercent, float64(needed)/(1024*1024))
+
+	initialFree := usage.FreeBytes
+	prunedRuns := 0
+	for i := range candidates {
+		if usage.FreePercent >= location.MinFreePercent {
+			break
+		}
+		var run entity.BackupRun
+		if err := DB.Preload("BackupFiles").First(&run, candidates[i].ID).Error; err != nil {
+			log.Printf("Failed to load backup run %d: %v", candidates[i].ID, err)
+			continue
+		}
+		if !r.cleanupRun(&run) {
+			continue
+		}
+		prunedRuns++
+
+		before := usage.FreeBytes
+		usage, err = GetStorageLocationUsage(location.ID)
+		if err != nil {
+			log.Printf("Failed to get usage of storage location %s: %v", location.Name, err)
+			break
+		}
+		if usage.FreeBytes <= before {
+			// Its files are shared with newer runs or the storage has not released the space yet
+			log.Printf("Pruning backup run %d freed no space on storage location %s, not pruning further", run.ID, location.Name)
+			break
+		}
+	}
+	if usage.FreePercent < location.MinFreePercent {
+		log.Printf("Storage location %s is still below %.1f%% free space, no more runs can be pruned",
+			location.Name, location.MinFreePercent)
+	}
+	if prunedRuns > 0 && NotificationSvc != nil {
+		go NotificationSvc.NotifySpacePruned(location.Name, prunedRuns, max(usage.FreeBytes-initialFree, 0))
+	}
+}
+
+// cleanupRun deletes all files associated with a backup run using existing service function,
+// it reports whether the run was cleaned up
+func (r *RetentionCleanup) cleanupRun(run *entity.BackupRun) bool {
+	if err := checkRunRemovable(run); err != nil {
+		log.Printf("Keeping backup run %d: %v", run.ID, err)
+		return false
+	}
 	log.Printf("Cleaning up backup run %d (ended: %s)", run.ID, run.EndTime.Format(time.RFC3339))
 
 	deletedFiles := 0
@@ -96,6 +261,7 @@ func (r *RetentionCleanup) cleanupRun(run *entity.BackupRun) {
 
 	log.Printf("Deleted %d files (%.2f MB) from backup run %d",
 		deletedFiles, float64(deletedBytes)/(1024*1024), run.ID)
+	return deletedFiles > 0
 }
 
 // StartRetentionScheduler starts a goroutine that runs retention cleanup periodically
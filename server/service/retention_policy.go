@@ -0,0 +1,292 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"backapp-server/entity"
+)
+
+// RetentionPolicy decides which completed runs of a profile are kept. Like restic's forget,
+// every age rule keeps runs on its own and a run is pruned when no rule keeps it. The size
+// limit then prunes the oldest kept runs until the profile fits, the newest run always stays.
+type RetentionPolicy struct {
+	KeepWithinDays int   `json:"keep_within_days"`
+	KeepLast       int   `json:"keep_last"`
+	KeepDaily      int   `json:"keep_daily"`
+	KeepWeekly     int   `json:"keep_weekly"`
+	KeepMonthly    int   `json:"keep_monthly"`
+	KeepYearly     int   `json:"keep_yearly"`
+	MaxSizeBytes   int64 `json:"max_size_bytes"`
+}
+
+// RetentionDecision explains what retention does with a run. StoredSizeBytes is the data
+// only this run and older ones reference, what pruning it frees at most.
+type RetentionDecision struct {
+	BackupRunID     uint      `json:"backup_run_id"`
+	EndTime         time.Time `json:"end_time"`
+	TotalSizeBytes  int64     `json:"total_size_bytes"`
+	StoredSizeBytes int64     `json:"stored_size_bytes"`
+	Held            bool      `json:"held"`
+	Locked          bool      `json:"locked"`
+	Keep            bool      `json:"keep"`
+	Reasons         []string  `json:"reasons"`
+}
+
+// RetentionPreview lists the decisions for the runs of a profile, newest first
+type RetentionPreview struct {
+	BackupProfileID uint                `json:"backup_profile_id"`
+	Policy          RetentionPolicy     `json:"policy"`
+	Runs            []RetentionDecision `json:"runs"`
+	KeepCount       int                 `json:"keep_count"`
+	PruneCount      int                 `json:"prune_count"`
+	PruneSizeBytes  int64               `json:"prune_size_bytes"`
+	StoredSizeBytes int64               `json:"stored_size_bytes"` // before pruning
+}
+
+// retentionPolicyForProfile returns the retention rules configured on a profile
+func retentionPolicyForProfile(profile *entity.BackupProfile) RetentionPolicy {
+	policy := RetentionPolicy{
+		KeepLast:     profile.KeepLast,
+		KeepDaily:    profile.KeepDaily,
+		KeepWeekly:   profile.KeepWeekly,
+		KeepMonthly:  profile.KeepMonthly,
+		KeepYearly:   profile.KeepYearly,
+		MaxSizeBytes: profile.MaxSizeBytes,
+	}
+	if profile.RetentionDays != nil {
+		policy.KeepWithinDays = *profile.RetentionDays
+	}
+	return policy
+}
+
+// Enabled reports whether the policy prunes anything, without rules all runs are kept
+func (p RetentionPolicy) Enabled() bool {
+	return p.hasAgeRules() || p.MaxSizeBytes > 0
+}
+
+func (p RetentionPolicy) hasAgeRules() bool {
+	return p.KeepWithinDays > 0 || p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.KeepYearly > 0
+}
+
+func (p RetentionPolicy) validate() error {
+	for name, value := range map[string]int{
+		"retention_days": p.KeepWithinDays,
+		"keep_last":      p.KeepLast,
+		"keep_daily":     p.KeepDaily,
+		"keep_weekly":    p.KeepWeekly,
+		"keep_monthly":   p.KeepMonthly,
+		"keep_yearly":    p.KeepYearly,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if p.MaxSizeBytes < 0 {
+		return fmt.Errorf("max_size_bytes must not be negative")
+	}
+	return nil
+}
+
+// retentionBucket keeps the newest run of each period until count periods are kept
+type retentionBucket struct {
+	name  string
+	count int
+	key   func(t time.Time) string
+	last  string
+}
+
+// evaluateRetention decides for each run whether the policy keeps it. Runs are judged by
+// their end time in local time, the newest run of a day, week, month or year represents it.
+// sizes holds the stored size of each run for the size limit.
+func evaluateRetention(runs []entity.BackupRun, sizes map[uint]int64, policy RetentionPolicy, now time.Time) []RetentionDecision {
+	sorted := make([]entity.BackupRun, len(runs))
+	copy(sorted, runs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].EndTime.After(sorted[j].EndTime) })
+
+	buckets := []*retentionBucket{
+		{name: "last", count: policy.KeepLast, key: func(t time.Time) string { return "" }},
+		{name: "daily", count: policy.KeepDaily, key: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{name: "weekly", count: policy.KeepWeekly, key: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{name: "monthly", count: policy.KeepMonthly, key: func(t time.Time) string { return t.Format("2006-01") }},
+		{name: "yearly", count: policy.KeepYearly, key: func(t time.Time) string { return t.Format("2006") }},
+	}
+	cutoff := now.AddDate(0, 0, -policy.KeepWithinDays)
+
+	decisions := make([]RetentionDecision, 0, len(sorted))
+	for i := range sorted {
+		run := &sorted[i]
+		decision := RetentionDecision{BackupRunID: run.ID, EndTime: run.EndTime, TotalSizeBytes: run.TotalSizeBytes, StoredSizeBytes: sizes[run.ID]}
+		// Held runs are kept without taking the place of another run in the rules
+		if runOnHold(run, now) {
+			decision.Held = true
+			decision.Keep = true
+			decision.Reasons = append(decision.Reasons, "held: "+run.HoldReason)
+			decisions = append(decisions, decision)
+			continue
+		}
+		if !policy.hasAgeRules() {
+			decision.Reasons = append(decision.Reasons, "no age rules")
+		}
+		if policy.KeepWithinDays > 0 && !run.EndTime.Before(cutoff) {
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("within %d days", policy.KeepWithinDays))
+		}
+		local := run.EndTime.Local()
+		for _, bucket := range buckets {
+			if bucket.count <= 0 {
+				continue
+			}
+			key := bucket.key(local)
+			if bucket.name == "last" {
+				bucket.count--
+				decision.Reasons = append(decision.Reasons, "last")
+				continue
+			}
+			if key == bucket.last {
+				continue
+			}
+			bucket.last = key
+			bucket.count--
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("%s %s", bucket.name, key))
+		}
+		decision.Keep = len(decision.Reasons) > 0
+		if !decision.Keep {
+			decision.Reasons = append(decision.Reasons, "not kept by any rule")
+		}
+		if runLocked(run, now) {
+			decision.Locked = true
+			if !decision.Keep {
+				decision.Keep = true
+				decision.Reasons = append(decision.Reasons, "locked until "+run.LockedUntil.Format(time.RFC3339))
+			}
+		}
+		decisions = append(decisions, decision)
+	}
+	if policy.MaxSizeBytes > 0 {
+		applySizeLimit(decisions, policy.MaxSizeBytes)
+	}
+	return decisions
+}
+
+// applySizeLimit prunes the oldest kept runs until the kept runs fit into maxBytes. The newest
+// run, held and locked runs are never pruned, a profile whose latest run alone exceeds the limit keeps it.
+func applySizeLimit(decisions []RetentionDecision, maxBytes int64) {
+	var kept int64
+	for _, decision := range decisions {
+		if decision.Keep {
+			kept += decision.StoredSizeBytes
+		}
+	}
+	for i := len(decisions) - 1; i > 0 && kept > maxBytes; i-- {
+		decision := &decisions[i]
+		if !decision.Keep || decision.Held || decision.Locked {
+			continue
+		}
+		kept -= decision.StoredSizeBytes
+		decision.Keep = false
+		decision.Reasons = append(decision.Reasons, "pruned to stay under "+formatSize(maxBytes))
+	}
+}
+
+// formatSize renders a byte count with a binary unit for retention reasons
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// storedRunSizes attributes the stored data of the given runs to the newest run referencing
+// it. Incremental runs share unchanged files, pruning an older run frees nothing a newer run
+// still uses, so this is what pruning the runs from the oldest one frees.
+func storedRunSizes(runs []entity.BackupRun) (map[uint]int64, error) {
+	sizes := make(map[uint]int64, len(runs))
+	if len(runs) == 0 {
+		return sizes, nil
+	}
+	endTimes := make(map[uint]time.Time, len(runs))
+	runIDs := make([]uint, 0, len(runs))
+	for _, run := range runs {
+		endTimes[run.ID] = run.EndTime
+		runIDs = append(runIDs, run.ID)
+	}
+
+	var files []entity.BackupFile
+	if err := DB.Select("backup_run_id", "local_path", "size_bytes").
+		Where("backup_run_id IN ? AND deleted = ?", runIDs, false).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	owners := make(map[string]*entity.BackupFile, len(files))
+	for i := range files {
+		file := &files[i]
+		owner, ok := owners[file.LocalPath]
+		if !ok || endTimes[file.BackupRunID].After(endTimes[owner.BackupRunID]) {
+			owners[file.LocalPath] = file
+		}
+	}
+	for _, file := range owners {
+		sizes[file.BackupRunID] += file.SizeBytes
+	}
+	return sizes, nil
+}
+
+// retentionCandidates returns the runs retention decides on: completed runs whose files
+// were not removed yet. Failed and running runs are left alone.
+func retentionCandidates(profileID uint) ([]entity.BackupRun, error) {
+	var runs []entity.BackupRun
+	if err := DB.Where("backup_profile_id = ? AND status = ? AND retention_cleaned_up = ?", profileID, "completed", false).
+		Order("end_time DESC").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ServicePreviewRetention shows which runs of a profile the retention cleanup would prune
+// and why. A policy given by the caller is previewed instead of the saved one.
+func ServicePreviewRetention(profileID uint, policy *RetentionPolicy) (*RetentionPreview, error) {
+	profile, err := ServiceGetBackupProfile(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		saved := retentionPolicyForProfile(profile)
+		policy = &saved
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	runs, err := retentionCandidates(profile.ID)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := storedRunSizes(runs)
+	if err != nil {
+		return nil, err
+	}
+	preview := &RetentionPreview{
+		BackupProfileID: profile.ID,
+		Policy:          *policy,
+		Runs:            evaluateRetention(runs, sizes, *policy, time.Now()),
+	}
+	for _, decision := range preview.Runs {
+		preview.StoredSizeBytes += decision.StoredSizeBytes
+		if decision.Keep {
+			preview.KeepCount++
+		} else {
+			preview.PruneCount++
+			preview.PruneSizeBytes += decision.StoredSizeBytes
+		}
+	}
+	return preview, nil
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"backapp-server/entity"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	runArchiveZip    = "zip"
+	runArchiveTar    = "tar"
+	runArchiveTarGz  = "tar.gz"
+	runArchiveTarZst = "tar.zst"
+
+	tarBlockSize = 512
+)
+
+var ErrUnsupportedArchiveFormat = errors.New("unsupported format, expected zip, tar, tar.gz or tar.zst")
+
+// RunArchive streams the stored files of a backup run as one archive, built on the fly.
+// Entries are named after the remote paths of the files. Uncompressed tar archives have a
+// known size and can be served in ranges, which lets clients resume interrupted downloads.
+type RunArchive struct {
+	Format  string
+	Name    string
+	ETag    string
+	size    int64
+	entries []runArchiveEntry
+	backend StorageBackend
+}
+
+type runArchiveEntry struct {
+	header  *tar.Header
+	raw     []byte // encoded tar header
+	file    *entity.BackupFile
+	content []byte // generated entries have no backup file
+}
+
+// ServiceOpenRunArchive prepares the download of a run as an archive of the given format.
+// The caller closes the archive.
+func ServiceOpenRunArchive(runID uint, format string) (*RunArchive, error) {
+	switch format {
+	case runArchiveZip, runArchiveTar, runArchiveTarGz, runArchiveTarZst:
+	default:
+		return nil, ErrUnsupportedArchiveFormat
+	}
+
+	var run entity.BackupRun
+	if err := DB.First(&run, runID).Error; err != nil {
+		return nil, err
+	}
+	var files []entity.BackupFile
+	if err := DB.Where("backup_run_id = ? AND deleted = ?", run.ID, false).Order("id").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	location, err := GetStorageLocationForRun(run.ID)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := NewStorageBackend(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage location: %v", err)
+	}
+
+	archive := &RunArchive{Format: format, backend: backend}
+	if err := archive.addFiles(location, &run, files); err != nil {
+		backend.Close()
+		return nil, err
+	}
+
+	name := path.Base(strings.ReplaceAll(run.LocalBackupPath, "\\", "/"))
+	if name == "." || name == "/" {
+		name = fmt.Sprintf("backup-run-%d", run.ID)
+	}
+	archive.Name = name + "." + format
+	return archive, nil
+}
+
+// addFiles lays out the archive entries and a SHA256SUMS file for the archive paths
+func (a *RunArchive) addFiles(location *entity.StorageLocation, run *entity.BackupRun, files []entity.BackupFile) error {
+	var sums strings.Builder
+	var entries []runArchiveEntry
+	seen := make(map[string]bool)
+	for i := range files {
+		file := &files[i]
+		if file.FileRuleID == 0 && file.RemotePath == checksumManifestName {
+			continue
+		}
+		name, err := runArchiveEntryName(location, run, file)
+		if err != nil {
+			return err
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		info, err := a.backend.Stat(file.LocalPath)
+		if err != nil {
+			return fmt.Errorf("backup file %s not found on storage: %v", name, err)
+		}
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     info.Size(),
+			Mode:     0644,
+			ModTime:  file.CreatedAt.Truncate(1e9),
+		}
+		if file.RemoteMode != 0 {
+			header.Mode = int64(file.RemoteMode)
+		}
+		if file.RemoteModTime != nil {
+			header.ModTime = file.RemoteModTime.Truncate(1e9)
+		}
+		entries = append(entries, runArchiveEntry{header: header, file: file})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].header.Name < entries[j].header.Name })
+	for _, entry := range entries {
+		if entry.file.Checksum != "" {
+			fmt.Fprintf(&sums, "%s  %s\n", entry.file.Checksum, entry.header.Name)
+		}
+	}
+
+	if sums.Len() > 0 && !seen[checksumManifestName] {
+		content := []byte(sums.String())
+		entries = append([]runArchiveEntry{{
+			header: &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     checksumManifestName,
+				Size:     int64(len(content)),
+				Mode:     0644,
+				ModTime:  run.EndTime.Truncate(1e9),
+			},
+			content: content,
+		}}, entries...)
+	}
+
+	// Identical layouts produce identical tar streams, the ETag validates resumed downloads
+	etag := sha256.New()
+	a.size = 2 * tarBlockSize
+	for i := range entries {
+		var buf bytes.Buffer
+		if err := tar.NewWriter(&buf).WriteHeader(entries[i].header); err != nil {
+			return fmt.Errorf("invalid archive entry %s: %v", entries[i].header.Name, err)
+		}
+		entries[i].raw = buf.Bytes()
+		a.size += int64(len(entries[i].raw)) + paddedTarSize(entries[i].header.Size)
+		etag.Write(entries[i].raw)
+		if entries[i].file != nil {
+			fmt.Fprintf(etag, "%d:%s\n", entries[i].file.ID, entries[i].file.Checksum)
+		} else {
+			etag.Write(entries[i].content)
+		}
+	}
+	a.entries = entries
+	a.ETag = fmt.Sprintf("\"%s-%s\"", a.Format, hex.EncodeToString(etag.Sum(nil))[:32])
+	return nil
+}
+
+// runArchiveEntryName keeps the remote path structure, archives of compressed rules and
+// files without an absolute remote path are named after their path in the run directory.
+func runArchiveEntryName(location *entity.StorageLocation, run *entity.BackupRun, file *entity.BackupFile) (string, error) {
+	name := file.RemotePath
+	if !path.IsAbs(name) {
+		snapshot, err := snapshotPath(location, run, file)
+		if err != nil {
+			return "", err
+		}
+		name = strings.ReplaceAll(snapshot, "\\", "/")
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "", fmt.Errorf("invalid archive path for backup file %d", file.ID)
+	}
+	return name, nil
+}
+
+func paddedTarSize(size int64) int64 {
+	return (size + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+}
+
+// Size returns the length of the archive, or -1 when it is compressed and only known
+// after streaming it.
+func (a *RunArchive) Size() int64 {
+	if a.Format == runArchiveTar {
+		return a.size
+	}
+	return -1
+}
+
+func (a *RunArchive) ContentType() string {
+	switch a.Format {
+	case runArchiveZip:
+		return "application/zip"
+	case runArchiveTar:
+		return "application/x-tar"
+	case runArchiveTarGz:
+		return "application/gzip"
+	default:
+		return "application/zstd"
+	}
+}
+
+func (a *RunArchive) Close() error {
+	return a.backend.Close()
+}
+
+// Stream writes the complete archive
+func (a *RunArchive) Stream(w io.Writer) error {
+	switch a.Format {
+	case runArchiveZip:
+		return a.writeZip(w)
+	case runArchiveTar:
+		return a.WriteRange(w, 0, a.size)
+	case runArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		if err := a.WriteRange(gz, 0, a.size); err != nil {
+			return err
+		}
+		return gz.Close()
+	default:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if err := a.WriteRange(zw, 0, a.size); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	}
+}
+
+// WriteRange writes length bytes of the tar stream starting at offset. Stored files
+// before the offset are skipped without being read.
+func (a *RunArchive) WriteRange(w io.Writer, offset, length int64) error {
+	end := offset + length
+	pos := int64(0)
+	// write emits the part of a segment at pos that lies in the requested range
+	write := func(size int64, emit func(skip, n int64) error) error {
+		segStart, segEnd := pos, pos+size
+		pos = segEnd
+		if segEnd <= offset || segStart >= end {
+			return nil
+		}
+		skip := max(offset-segStart, 0)
+		n := min(segEnd, end) - segStart - skip
+		return emit(skip, n)
+	}
+	zeros := func(skip, n int64) error {
+		_, err := io.CopyN(w, zeroReader{}, n)
+		return err
+	}
+
+	for i := range a.entries {
+		entry := &a.entries[i]
+		if err := write(int64(len(entry.raw)), func(skip, n int64) error {
+			_, err := w.Write(entry.raw[skip : skip+n])
+			return err
+		}); err != nil {
+			return err
+		}
+		if err := write(entry.header.Size, func(skip, n int64) error {
+			return a.copyEntry(w, entry, skip, n)
+		}); err != nil {
+			return err
+		}
+		if err := write(paddedTarSize(entry.header.Size)-entry.header.Size, zeros); err != nil {
+			return err
+		}
+	}
+	return write(2*tarBlockSize, zeros)
+}
+
+// copyEntry writes n bytes of an entry's content starting at skip
+func (a *RunArchive) copyEntry(w io.Writer, entry *runArchiveEntry, skip, n int64) error {
+	if entry.file == nil {
+		_, err := w.Write(entry.content[skip : skip+n])
+		return err
+	}
+	reader, err := a.openEntry(entry)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if _, err := io.CopyN(io.Discard, reader, skip); err != nil {
+		return fmt.Errorf("failed to read %s: %v", entry.header.Name, err)
+	}
+	if _, err := io.CopyN(w, reader, n); err != nil {
+		return fmt.Errorf("failed to read %s: %v", entry.header.Name, err)
+	}
+	return nil
+}
+
+// openEntry reads a file from the storage location of the run, falling back to replicas
+func (a *RunArchive) openEntry(entry *runArchiveEntry) (io.ReadCloser, error) {
+	reader, err := openStoredFile(a.backend, entry.file.LocalPath, entry.file.Encrypted)
+	if err == nil {
+		return reader, nil
+	}
+	log.Printf("Failed to open backup file %d for archive download: %v", entry.file.ID, err)
+	reader, _, err = OpenBackupFileReader(entry.file)
+	return reader, err
+}
+
+func (a *RunArchive) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for i := range a.entries {
+		entry := &a.entries[i]
+		header := &zip.FileHeader{
+			Name:     entry.header.Name,
+			Method:   zip.Deflate,
+			Modified: entry.header.ModTime,
+		}
+		header.SetMode(os.FileMode(entry.header.Mode))
+		writer, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := a.copyEntry(writer, entry, 0, entry.header.Size); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// zeroReader produces zero bytes, used for tar padding
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -11,10 +11,11 @@ import (
 
 // BackupScheduler manages scheduled backup executions
 type BackupScheduler struct {
-	cron     *cron.Cron
-	jobs     map[uint]cron.EntryID // profileID -> cronEntryID
-	executor *BackupExecutor
-	mu       sync.RWMutex
+	cron       *cron.Cron
+	jobs       map[uint]cron.EntryID // profileID -> cronEntryID
+	verifyJobs map[uint]cron.EntryID // profileID -> cronEntryID of the verification
+	drillJobs  map[uint]cron.EntryID // profileID -> cronEntryID of the restore drill
+	mu         sync.RWMutex
 }
 
 var (
@@ -26,9 +27,10 @@ var (
 func GetScheduler() *BackupScheduler {
 	schedulerOnce.Do(func() {
 		scheduler = &BackupScheduler{
-			cron:     cron.New(),
-			jobs:     make(map[uint]cron.EntryID),
-			executor: NewBackupExecutor(),
+			cron:       cron.New(),
+			jobs:       make(map[uint]cron.EntryID),
+			verifyJobs: make(map[uint]cron.EntryID),
+			drillJobs:  make(map[uint]cron.EntryID),
 		}
 		scheduler.cron.Start()
 	})
@@ -40,6 +42,13 @@ func (s *BackupScheduler) ScheduleProfile(profile *entity.BackupProfile) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.scheduleVerification(profile); err != nil {
+		log.Printf("Failed to schedule verification of profile %d: %v", profile.ID, err)
+	}
+	if err := s.scheduleRestoreDrill(profile); err != nil {
+		log.Printf("Failed to schedule restore drill of profile %d: %v", profile.ID, err)
+	}
+
 	// Remove existing schedule if any
 	if entryID, exists := s.jobs[profile.ID]; exists {
 		s.cron.Remove(entryID)
@@ -53,9 +62,9 @@ func (s *BackupScheduler) ScheduleProfile(profile *entity.BackupProfile) error {
 
 	// Add new schedule
 	entryID, err := s.cron.AddFunc(profile.ScheduleCron, func() {
-		log.Printf("Running scheduled backup for profile %d: %s", profile.ID, profile.Name)
+		log.Printf("Queueing scheduled backup for profile %d: %s", profile.ID, profile.Name)
 		// Scheduled jobs must respect the enabled flag (allowDisabled=false)
-		if err := s.executor.ExecuteBackup(profile.ID, false); err != nil {
+		if _, err := enqueueBackup(profile.ID, false, jobTriggerScheduled); err != nil {
 			log.Printf("Scheduled backup failed for profile %d: %v", profile.ID, err)
 		}
 	})
@@ -70,11 +79,80 @@ func (s *BackupScheduler) ScheduleProfile(profile *entity.BackupProfile) error {
 	return nil
 }
 
+// scheduleVerification schedules the integrity verification of the runs of a profile.
+// Stored runs are verified even when the profile itself is disabled.
+func (s *BackupScheduler) scheduleVerification(profile *entity.BackupProfile) error {
+	if entryID, exists := s.verifyJobs[profile.ID]; exists {
+		s.cron.Remove(entryID)
+		delete(s.verifyJobs, profile.ID)
+	}
+	if profile.VerifyCron == "" {
+		return nil
+	}
+
+	profileID := profile.ID
+	entryID, err := s.cron.AddFunc(profile.VerifyCron, func() {
+		log.Printf("Running scheduled verification for profile %d", profileID)
+		verification, err := prepareVerification(verificationScopeProfile, profileID, verificationTriggerScheduled)
+		if err != nil {
+			log.Printf("Scheduled verification failed for profile %d: %v", profileID, err)
+			return
+		}
+		runVerification(verification)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.verifyJobs[profile.ID] = entryID
+	log.Printf("Scheduled verification of profile %d (%s) with cron: %s", profile.ID, profile.Name, profile.VerifyCron)
+	return nil
+}
+
+// scheduleRestoreDrill schedules the restore drills of a profile, like verifications they
+// test stored runs and also run for disabled profiles
+func (s *BackupScheduler) scheduleRestoreDrill(profile *entity.BackupProfile) error {
+	if entryID, exists := s.drillJobs[profile.ID]; exists {
+		s.cron.Remove(entryID)
+		delete(s.drillJobs, profile.ID)
+	}
+	if profile.RestoreDrillCron == "" {
+		return nil
+	}
+
+	profileID := profile.ID
+	entryID, err := s.cron.AddFunc(profile.RestoreDrillCron, func() {
+		log.Printf("Running scheduled restore drill for profile %d", profileID)
+		drill, err := prepareRestoreDrill(profileID, verificationTriggerScheduled)
+		if err != nil {
+			log.Printf("Scheduled restore drill failed for profile %d: %v", profileID, err)
+			return
+		}
+		runRestoreDrill(drill)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.drillJobs[profile.ID] = entryID
+	log.Printf("Scheduled restore drill of profile %d (%s) with cron: %s", profile.ID, profile.Name, profile.RestoreDrillCron)
+	return nil
+}
+
 // UnscheduleProfile removes a backup profile from the schedule
 func (s *BackupScheduler) UnscheduleProfile(profileID uint) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if entryID, exists := s.verifyJobs[profileID]; exists {
+		s.cron.Remove(entryID)
+		delete(s.verifyJobs, profileID)
+	}
+	if entryID, exists := s.drillJobs[profileID]; exists {
+		s.cron.Remove(entryID)
+		delete(s.drillJobs, profileID)
+	}
+
 	if entryID, exists := s.jobs[profileID]; exists {
 		s.cron.Remove(entryID)
 		delete(s.jobs, profileID)
@@ -83,9 +161,10 @@ func (s *BackupScheduler) UnscheduleProfile(profileID uint) {
 }
 
 // LoadAllSchedules loads and schedules all enabled backup profiles with cron expressions
+// and all profiles with a verification or restore drill schedule
 func (s *BackupScheduler) LoadAllSchedules() error {
 	var profiles []entity.BackupProfile
-	if err := DB.Where("enabled = ? AND schedule_cron != ''", true).Find(&profiles).Error; err != nil {
+	if err := DB.Where("(enabled = ? AND schedule_cron != '') OR verify_cron != '' OR restore_drill_cron != ''", true).Find(&profiles).Error; err != nil {
 		return err
 	}
 
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"backapp-server/entity"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Secret columns are tagged with `gorm:"serializer:secret"` and stored as
+//
+//	enc:v1:<key id>:<base64(nonce | AES-256-GCM ciphertext)>
+//
+// where the key id is derived from the master key. Values without the prefix are
+// legacy plaintext and get encrypted by migrateSecrets on startup.
+const (
+	secretPrefix         = "enc:v1:"
+	masterKeySize        = 32
+	masterKeyEnv         = "BACKAPP_MASTER_KEY"
+	masterKeyFileEnv     = "BACKAPP_MASTER_KEY_FILE"
+	defaultMasterKeyFile = "master.key"
+)
+
+// masterKeyring holds the key used for new values and all keys that may decrypt stored values.
+type masterKeyring struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+	// keyFile is empty when the key was supplied through the environment
+	keyFile string
+}
+
+var secretKeys *masterKeyring
+
+// secretModels lists every model with secret columns, used for migration and rotation.
+var secretModels = []interface{}{
+	&entity.Server{},
+	&entity.StorageLocation{},
+	&entity.FileRule{},
+	&entity.EncryptionKey{},
+}
+
+type secretSerializer struct{}
+
+func init() {
+	schema.RegisterSerializer("secret", secretSerializer{})
+}
+
+func (secretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	}
+	plain, err := decryptSecret(stored)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %v", field.DBName, err)
+	}
+	return field.Set(ctx, dst, plain)
+}
+
+func (secretSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plain, _ := fieldValue.(string)
+	return encryptSecret(plain)
+}
+
+// LoadMasterKey loads the master key from BACKAPP_MASTER_KEY, from the key file given by
+// keyFile or BACKAPP_MASTER_KEY_FILE, or from master.key next to the database. A new key
+// file is generated when none exists yet.
+func LoadMasterKey(keyFile, dbPath string) error {
+	if encoded := strings.TrimSpace(os.Getenv(masterKeyEnv)); encoded != "" {
+		key, err := decodeMasterKey(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", masterKeyEnv, err)
+		}
+		secretKeys = newMasterKeyring(key, "")
+		return nil
+	}
+
+	if keyFile == "" {
+		keyFile = os.Getenv(masterKeyFileEnv)
+	}
+	if keyFile == "" {
+		keyFile = filepath.Join(filepath.Dir(dbPath), defaultMasterKeyFile)
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key := make([]byte, masterKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("failed to generate master key: %v", err)
+		}
+		if err := writeMasterKeyFile(keyFile, key); err != nil {
+			return err
+		}
+		log.Printf("Generated new master key at %s - back it up, stored credentials cannot be decrypted without it", keyFile)
+		secretKeys = newMasterKeyring(key, keyFile)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read master key file: %v", err)
+	}
+	key, err := decodeMasterKey(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid master key file %s: %v", keyFile, err)
+	}
+	secretKeys = newMasterKeyring(key, keyFile)
+	return nil
+}
+
+func decodeMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("expected base64: %v", err)
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", masterKeySize, len(key))
+	}
+	return key, nil
+}
+
+func writeMasterKeyFile(keyFile string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return fmt.Errorf("failed to create master key directory: %v", err)
+	}
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write master key file: %v", err)
+	}
+	return nil
+}
+
+func newMasterKeyring(key []byte, keyFile string) *masterKeyring {
+	ring := &masterKeyring{keys: make(map[string]cipher.AEAD), keyFile: keyFile}
+	ring.currentID = ring.add(key)
+	return ring
+}
+
+// add registers a key for decryption and returns its id.
+func (k *masterKeyring) add(key []byte) string {
+	sum := sha256.Sum256(key)
+	id := hex.EncodeToString(sum[:4])
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	k.keys[id] = aead
+	return id
+}
+
+func encryptSecret(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	if secretKeys == nil {
+		return "", fmt.Errorf("master key not loaded")
+	}
+	aead := secretKeys.keys[secretKeys.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + secretKeys.currentID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, secretPrefix)
+	if !ok {
+		// Legacy plaintext value
+		return stored, nil
+	}
+	if secretKeys == nil {
+		return "", fmt.Errorf("master key not loaded")
+	}
+	keyID, data, ok := strings.Cut(encoded, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed secret")
+	}
+	aead, ok := secretKeys.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("secret was encrypted with an unknown master key (%s)", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed secret")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+	return string(plain), nil
+}
+
+// secretColumns returns the database columns of a model that use the secret serializer.
+func secretColumns(db *gorm.DB, model interface{}) (string, []string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", nil, err
+	}
+	var columns []string
+	for _, field := range stmt.Schema.Fields {
+		if field.TagSettings["SERIALIZER"] == "secret" {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return stmt.Schema.Table, columns, nil
+}
+
+// reencryptSecrets rewrites the secret columns of every row of the model. When onlyPlaintext
+// is set, only rows still holding legacy plaintext values are rewritten.
+func reencryptSecrets(tx *gorm.DB, model interface{}, onlyPlaintext bool) (int, error) {
+	table, columns, err := secretColumns(tx, model)
+	if err != nil || len(columns) == 0 {
+		return 0, err
+	}
+
+	query := tx.Model(model)
+	if onlyPlaintext {
+		var conditions []string
+		var args []interface{}
+		for _, column := range columns {
+			conditions = append(conditions, fmt.Sprintf("(`%s` <> '' AND `%s` NOT LIKE ?)", column, column))
+			args = append(args, secretPrefix+"%")
+		}
+		query = query.Where(strings.Join(conditions, " OR "), args...)
+	}
+
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem()))
+	if err := query.Find(rows.Interface()).Error; err != nil {
+		return 0, fmt.Errorf("failed to load %s: %v", table, err)
+	}
+	slice := rows.Elem()
+	for i := 0; i < slice.Len(); i++ {
+		row := slice.Index(i).Addr().Interface()
+		if err := tx.Model(row).Select(columns).Updates(row).Error; err != nil {
+			return 0, fmt.Errorf("failed to update %s: %v", table, err)
+		}
+	}
+	return slice.Len(), nil
+}
+
+// migrateSecrets encrypts credentials that were stored in plaintext by earlier versions.
+func migrateSecrets() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range secretModels {
+			count, err := reencryptSecrets(tx, model, true)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				log.Printf("Encrypted stored credentials of %d %T record(s)", count, model)
+			}
+		}
+		return nil
+	})
+}
+
+// RotateMasterKey re-encrypts all stored credentials with a newly generated master key.
+// With a key file the new key replaces it, the previous key is kept as <file>.old.
+// With BACKAPP_MASTER_KEY the new key is returned and must be configured before the next start.
+func RotateMasterKey() (string, error) {
+	if secretKeys == nil {
+		return "", fmt.Errorf("master key not loaded")
+	}
+	newKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(newKey); err != nil {
+		return "", fmt.Errorf("failed to generate master key: %v", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(newKey)
+
+	// Persist the new key before any row depends on it
+	pendingFile := ""
+	if secretKeys.keyFile != "" {
+		pendingFile = secretKeys.keyFile + ".new"
+		if err := writeMasterKeyFile(pendingFile, newKey); err != nil {
+			return "", err
+		}
+	}
+
+	previousID := secretKeys.currentID
+	secretKeys.currentID = secretKeys.add(newKey)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range secretModels {
+			if _, err := reencryptSecrets(tx, model, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		secretKeys.currentID = previousID
+		if pendingFile != "" {
+			os.Remove(pendingFile)
+		}
+		return "", err
+	}
+
+	if pendingFile != "" {
+		if err := os.Rename(secretKeys.keyFile, secretKeys.keyFile+".old"); err != nil {
+			return "", fmt.Errorf("credentials were re-encrypted but the key file could not be replaced, use %s: %v", pendingFile, err)
+		}
+		if err := os.Rename(pendingFile, secretKeys.keyFile); err != nil {
+			return "", fmt.Errorf("credentials were re-encrypted but the key file could not be replaced, use %s: %v", pendingFile, err)
+		}
+	}
+	return encoded, nil
+}
+
+// MasterKeyFromEnv reports whether the master key is supplied through the environment.
+func MasterKeyFromEnv() bool {
+	return secretKeys != nil && secretKeys.keyFile == ""
+}
@@ -68,6 +68,8 @@ func ServiceCreateServerFromJSON(input *entity.Server) (*entity.Server, error) {
 		Username: input.Username,
 		AuthType: input.AuthType,
 		Password: input.Password,
+
+		MaxConcurrentBackups: input.MaxConcurrentBackups,
 	}
 	if server.Port == 0 {
 		server.Port = 22
@@ -107,13 +109,16 @@ func ServiceUpdateServer(id uint, input *entity.Server) (*entity.Server, error)
 	if err != nil {
 		return nil, err
 	}
+	// A different endpoint presents a different host key, trust it again on first use
+	endpointChanged := server.Host != input.Host || (input.Port != 0 && server.Port != input.Port)
 	server.Name = input.Name
 	server.Host = input.Host
 	server.Port = input.Port
 	server.Username = input.Username
 	server.AuthType = input.AuthType
+	server.MaxConcurrentBackups = input.MaxConcurrentBackups
 	// Only update password if a new one is provided (non-empty)
-	if input.Password != "" {
+	if input.Password != "" && input.Password != entity.RedactedSecret {
 		server.Password = input.Password
 	}
 	if server.Port == 0 {
@@ -122,6 +127,11 @@ func ServiceUpdateServer(id uint, input *entity.Server) (*entity.Server, error)
 	if err := DB.Save(server).Error; err != nil {
 		return nil, err
 	}
+	if endpointChanged {
+		if err := ServiceResetServerHostKey(server.ID); err != nil {
+			return nil, err
+		}
+	}
 	return sanitizeServer(server), nil
 }
 
@@ -175,6 +185,10 @@ func ServiceDeleteServer(id uint) error {
 	if _, err := GetServerByID(id); err != nil {
 		return err
 	}
+	profileIDs := DB.Model(&entity.BackupProfile{}).Select("id").Where("server_id = ?", id)
+	if err := checkRunsRemovable("backup_profile_id IN (?)", profileIDs); err != nil {
+		return err
+	}
 
 	// Get all backup profiles for this server
 	var profiles []entity.BackupProfile
@@ -210,11 +224,21 @@ func ServiceDeleteServer(id uint) error {
 		}
 
 		// Delete the profile
+		if err := deleteScopesForProfiles(profile.ID); err != nil {
+			return err
+		}
 		if err := DB.Delete(&profile).Error; err != nil {
 			return err
 		}
 	}
 
+	if err := ServiceResetServerHostKey(id); err != nil {
+		return err
+	}
+	if err := DB.Where("server_id = ?", id).Delete(&entity.UserScope{}).Error; err != nil {
+		return err
+	}
+
 	// Finally, delete the server
 	return DB.Delete(&entity.Server{}, id).Error
 }
@@ -78,10 +78,11 @@ func buildSFTPSSHConfig(location *entity.StorageLocation) (*ssh.ClientConfig, er
 	if err != nil {
 		return nil, err
 	}
-	return &ssh.ClientConfig{
-		User:            location.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}, nil
+	config := &ssh.ClientConfig{
+		User:    location.Username,
+		Auth:    authMethods,
+		Timeout: 30 * time.Second,
+	}
+	applyHostKeyPolicy(config, storageLocationHostTarget(location))
+	return config, nil
 }
@@ -11,8 +11,13 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// TestSSHConnection tests an SSH connection with a private key
+// TestSSHConnection tests an SSH connection with a private key for a server that is not
+// stored yet. Its host key is recorded on the first connection after it was saved.
 func TestSSHConnection(hostname, username, keyContent string, port int) error {
+	return testSSHConnectionWithKey(hostname, username, keyContent, port, knownHostTarget{})
+}
+
+func testSSHConnectionWithKey(hostname, username, keyContent string, port int, target knownHostTarget) error {
 	signer, err := ssh.ParsePrivateKey([]byte(keyContent))
 	if err != nil {
 		return fmt.Errorf("failed to parse private key: %v", err)
@@ -23,9 +28,9 @@ func TestSSHConnection(hostname, username, keyContent string, port int) error {
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+		Timeout: 10 * time.Second,
 	}
+	applyHostKeyPolicy(config, target)
 
 	// Build address with port
 	address := hostname
@@ -60,14 +65,18 @@ func TestSSHConnection(hostname, username, keyContent string, port int) error {
 
 // TestSSHConnectionWithPassword tests an SSH connection using username/password
 func TestSSHConnectionWithPassword(hostname, username, password string, port int) error {
+	return testSSHConnectionWithPassword(hostname, username, password, port, knownHostTarget{})
+}
+
+func testSSHConnectionWithPassword(hostname, username, password string, port int, target knownHostTarget) error {
 	config := &ssh.ClientConfig{
 		User: username,
 		Auth: []ssh.AuthMethod{
 			ssh.Password(password),
 		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+		Timeout: 10 * time.Second,
 	}
+	applyHostKeyPolicy(config, target)
 
 	// Build address with port
 	address := hostname
@@ -97,7 +106,9 @@ func TestSSHConnectionWithPassword(hostname, username, password string, port int
 }
 
 // TestSSHConnectionUsingServer attempts an SSH connection using the server's auth type
+// and verifies its host key, trusting it if none is recorded yet.
 func TestSSHConnectionUsingServer(server *entity.Server) error {
+	target := serverHostTarget(server)
 	switch server.AuthType {
 	case "key":
 		if server.PrivateKeyPath == "" {
@@ -108,14 +119,14 @@ func TestSSHConnectionUsingServer(server *entity.Server) error {
 			if err != nil {
 				return fmt.Errorf("failed to read private key file: %v", err)
 			}
-			return TestSSHConnection(server.Host, server.Username, string(keyData), server.Port)
+			return testSSHConnectionWithKey(server.Host, server.Username, string(keyData), server.Port, target)
 		}
-		return TestSSHConnection(server.Host, server.Username, server.PrivateKeyPath, server.Port)
+		return testSSHConnectionWithKey(server.Host, server.Username, server.PrivateKeyPath, server.Port, target)
 	case "password":
 		if server.Password == "" {
 			return fmt.Errorf("server has no password configured")
 		}
-		return TestSSHConnectionWithPassword(server.Host, server.Username, server.Password, server.Port)
+		return testSSHConnectionWithPassword(server.Host, server.Username, server.Password, server.Port, target)
 	default:
 		return fmt.Errorf("unsupported auth_type: %s", server.AuthType)
 	}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -10,6 +11,7 @@ import (
 
 	"backapp-server/entity"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -18,10 +20,17 @@ type SSHClient struct {
 	client *ssh.Client
 	config *ssh.ClientConfig
 	addr   string
+	ctx    context.Context // cancelling it kills the remote commands and transfers in flight
 }
 
 // NewSSHClient creates a new SSH client for a server
 func NewSSHClient(server *entity.Server) (*SSHClient, error) {
+	return NewSSHClientContext(context.Background(), server)
+}
+
+// NewSSHClientContext creates a new SSH client for a server whose commands and transfers
+// are aborted when ctx is cancelled
+func NewSSHClientContext(ctx context.Context, server *entity.Server) (*SSHClient, error) {
 	var config *ssh.ClientConfig
 
 	switch server.AuthType {
@@ -41,8 +50,7 @@ func NewSSHClient(server *entity.Server) (*SSHClient, error) {
 			Auth: []ssh.AuthMethod{
 				ssh.PublicKeys(signer),
 			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         30 * time.Second,
+			Timeout: 30 * time.Second,
 		}
 
 	case "password":
@@ -51,13 +59,13 @@ func NewSSHClient(server *entity.Server) (*SSHClient, error) {
 			Auth: []ssh.AuthMethod{
 				ssh.Password(server.Password),
 			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         30 * time.Second,
+			Timeout: 30 * time.Second,
 		}
 
 	default:
 		return nil, fmt.Errorf("unsupported auth_type: %s", server.AuthType)
 	}
+	applyHostKeyPolicy(config, serverHostTarget(server))
 
 	// Build address with correct port
 	address := server.Host
@@ -70,18 +78,60 @@ func NewSSHClient(server *entity.Server) (*SSHClient, error) {
 		address = net.JoinHostPort(server.Host, fmt.Sprintf("%d", port))
 	}
 
-	client, err := ssh.Dial("tcp", address, config)
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
 	if err != nil {
 		return nil, fmt.Errorf("SSH connection failed: %v", err)
 	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH connection failed: %v", err)
+	}
 
 	return &SSHClient{
-		client: client,
+		client: ssh.NewClient(sshConn, chans, reqs),
 		config: config,
 		addr:   address,
+		ctx:    ctx,
 	}, nil
 }
 
+// WithContext returns a client sharing the connection whose commands are bound to ctx
+// instead, e.g. to clean up after the original context was cancelled. Only the original
+// client closes the connection.
+func (c *SSHClient) WithContext(ctx context.Context) *SSHClient {
+	clone := *c
+	clone.ctx = ctx
+	return &clone
+}
+
+// newSession opens a session that is killed when the client's context is cancelled, the
+// returned stop function must be called once the session is done
+func (c *SSHClient) newSession() (*ssh.Session, func() bool, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	session, err := c.client.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create session: %v", err)
+	}
+	stop := context.AfterFunc(c.ctx, func() {
+		// Servers that ignore the signal still hang up the command with the channel
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+	})
+	return session, stop, nil
+}
+
+// cancelled replaces the error of an operation aborted by the client's context
+func (c *SSHClient) cancelled(err error) error {
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
 // RunCommand executes a command on the remote server
 func (c *SSHClient) RunCommand(cmd string) (string, error) {
 	return c.RunCommandInDir(cmd, "")
@@ -89,10 +139,11 @@ func (c *SSHClient) RunCommand(cmd string) (string, error) {
 
 // RunCommandInDir executes a command on the remote server in a specific directory
 func (c *SSHClient) RunCommandInDir(cmd string, workingDir string) (string, error) {
-	session, err := c.client.NewSession()
+	session, stop, err := c.newSession()
 	if err != nil {
-		return "", fmt.Errorf("failed to create session: %v", err)
+		return "", err
 	}
+	defer stop()
 	defer session.Close()
 
 	// If working directory is specified and not root, prepend cd command
@@ -103,7 +154,7 @@ func (c *SSHClient) RunCommandInDir(cmd string, workingDir string) (string, erro
 
 	output, err := session.CombinedOutput(fullCmd)
 	if err != nil {
-		return string(output), fmt.Errorf("command failed: %v", err)
+		return string(output), c.cancelled(fmt.Errorf("command failed: %v", err))
 	}
 
 	return string(output), nil
@@ -126,10 +177,11 @@ func (c *SSHClient) CopyFileFromRemote(remotePath, localPath string) error {
 
 // CopyFileFromRemoteToWriter streams a remote file into a writer.
 func (c *SSHClient) CopyFileFromRemoteToWriter(remotePath string, writer io.Writer) error {
-	session, err := c.client.NewSession()
+	session, stop, err := c.newSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		return err
 	}
+	defer stop()
 	defer session.Close()
 
 	stdout, err := session.StdoutPipe()
@@ -142,11 +194,11 @@ func (c *SSHClient) CopyFileFromRemoteToWriter(remotePath string, writer io.Writ
 	}
 
 	if _, err := io.Copy(writer, stdout); err != nil {
-		return fmt.Errorf("failed to copy file content: %v", err)
+		return c.cancelled(fmt.Errorf("failed to copy file content: %v", err))
 	}
 
 	if err := session.Wait(); err != nil {
-		return fmt.Errorf("cat command failed: %v", err)
+		return c.cancelled(fmt.Errorf("cat command failed: %v", err))
 	}
 
 	return nil
@@ -154,10 +206,11 @@ func (c *SSHClient) CopyFileFromRemoteToWriter(remotePath string, writer io.Writ
 
 // copyFileUsingCat downloads a file using cat (simpler and more reliable)
 func (c *SSHClient) copyFileUsingCat(remotePath, localPath string) error {
-	session, err := c.client.NewSession()
+	session, stop, err := c.newSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		return err
 	}
+	defer stop()
 	defer session.Close()
 
 	// Create local file
@@ -180,12 +233,12 @@ func (c *SSHClient) copyFileUsingCat(remotePath, localPath string) error {
 
 	// Copy content to local file
 	if _, err := io.Copy(localFile, stdout); err != nil {
-		return fmt.Errorf("failed to copy file content: %v", err)
+		return c.cancelled(fmt.Errorf("failed to copy file content: %v", err))
 	}
 
 	// Wait for command to finish
 	if err := session.Wait(); err != nil {
-		return fmt.Errorf("cat command failed: %v", err)
+		return c.cancelled(fmt.Errorf("cat command failed: %v", err))
 	}
 
 	return nil
@@ -193,10 +246,11 @@ func (c *SSHClient) copyFileUsingCat(remotePath, localPath string) error {
 
 // copyFileUsingSCP downloads a file from the remote server using SCP
 func (c *SSHClient) copyFileUsingSCP(remotePath, localPath string) error {
-	session, err := c.client.NewSession()
+	session, stop, err := c.newSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		return err
 	}
+	defer stop()
 	defer session.Close()
 
 	// Create local file
@@ -240,7 +294,7 @@ func (c *SSHClient) copyFileUsingSCP(remotePath, localPath string) error {
 
 	// Read file content
 	if _, err := io.Copy(localFile, stdout); err != nil {
-		return fmt.Errorf("failed to copy file: %v", err)
+		return c.cancelled(fmt.Errorf("failed to copy file: %v", err))
 	}
 
 	// Send final acknowledgment
@@ -249,6 +303,9 @@ func (c *SSHClient) copyFileUsingSCP(remotePath, localPath string) error {
 	}
 
 	if err := session.Wait(); err != nil {
+		if ctxErr := c.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		// SCP might return error even on success, check if file was created
 		if stat, statErr := os.Stat(localPath); statErr == nil && stat.Size() > 0 {
 			return nil
@@ -259,6 +316,15 @@ func (c *SSHClient) copyFileUsingSCP(remotePath, localPath string) error {
 	return nil
 }
 
+// NewSFTPClient opens an SFTP session on the connection, the caller closes it
+func (c *SSHClient) NewSFTPClient() (*sftp.Client, error) {
+	client, err := sftp.NewClient(c.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sftp session: %v", err)
+	}
+	return client, nil
+}
+
 // Close closes the SSH connection
 func (c *SSHClient) Close() error {
 	if c.client != nil {
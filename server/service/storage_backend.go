@@ -11,8 +11,11 @@ import (
 )
 
 const (
-	storageTypeLocal = "local"
-	storageTypeSFTP  = "sftp"
+	storageTypeLocal  = "local"
+	storageTypeSFTP   = "sftp"
+	storageTypeS3     = "s3"
+	storageTypeWebDAV = "webdav"
+	storageTypeFTP    = "ftp"
 )
 
 // StorageBackend abstracts where backups are written/read.
@@ -26,14 +29,36 @@ type StorageBackend interface {
 	Close() error
 }
 
-type localStorageBackend struct{}
+// abortableWriter is implemented by writers that can discard what was written instead of
+// committing it on Close
+type abortableWriter interface {
+	Abort(err error) error
+}
+
+// abortWriter discards a file after a failed or cancelled copy. Writers that cannot abort
+// are closed, which may leave the partial file behind.
+func abortWriter(writer io.WriteCloser, err error) {
+	if abortable, ok := writer.(abortableWriter); ok {
+		abortable.Abort(err)
+		return
+	}
+	writer.Close()
+}
+
+type localStorageBackend struct {
+	lockDays int // WORM lock period, files are locked when their writer is closed
+}
 
 func (b *localStorageBackend) EnsureDir(dirPath string) error {
 	return os.MkdirAll(dirPath, 0755)
 }
 
 func (b *localStorageBackend) OpenWriter(filePath string) (io.WriteCloser, error) {
-	return os.Create(filePath)
+	file, err := os.Create(filePath)
+	if err != nil || b.lockDays <= 0 {
+		return file, err
+	}
+	return &lockedFileWriter{File: file}, nil
 }
 
 func (b *localStorageBackend) OpenReader(filePath string) (io.ReadCloser, error) {
@@ -41,6 +66,10 @@ func (b *localStorageBackend) OpenReader(filePath string) (io.ReadCloser, error)
 }
 
 func (b *localStorageBackend) Remove(filePath string) error {
+	if b.lockDays > 0 {
+		// Only reached once the lock of the run expired
+		unlockLocalFile(filePath)
+	}
 	return os.Remove(filePath)
 }
 
@@ -70,7 +99,8 @@ func NormalizeStorageType(location *entity.StorageLocation) string {
 
 // StorageBasePath returns the base path/prefix for a storage location.
 func StorageBasePath(location *entity.StorageLocation) string {
-	if NormalizeStorageType(location) == storageTypeSFTP {
+	switch NormalizeStorageType(location) {
+	case storageTypeSFTP, storageTypeS3, storageTypeWebDAV, storageTypeFTP:
 		return location.RemotePath
 	}
 	return location.BasePath
@@ -78,16 +108,41 @@ func StorageBasePath(location *entity.StorageLocation) string {
 
 // JoinStoragePath joins path elements based on storage type.
 func JoinStoragePath(location *entity.StorageLocation, elements ...string) string {
-	if NormalizeStorageType(location) == storageTypeSFTP {
+	switch NormalizeStorageType(location) {
+	case storageTypeSFTP, storageTypeS3, storageTypeWebDAV, storageTypeFTP:
 		return path.Join(elements...)
 	}
 	return filepath.Join(elements...)
 }
 
-// NewStorageBackend creates a storage backend for the location.
+// NewStorageBackend creates a storage backend for the location, including the
+// encryption layer when the location uses client-side encryption and the
+// deduplication layer on top of it when the location deduplicates files.
 func NewStorageBackend(location *entity.StorageLocation) (StorageBackend, error) {
-	if NormalizeStorageType(location) == storageTypeSFTP {
+	backend, err := newBaseStorageBackend(location)
+	if err != nil {
+		return nil, err
+	}
+	backend, err = wrapEncryption(location, backend)
+	if err != nil {
+		return nil, err
+	}
+	return wrapDedup(location, backend)
+}
+
+func newBaseStorageBackend(location *entity.StorageLocation) (StorageBackend, error) {
+	if location == nil {
+		return &localStorageBackend{}, nil
+	}
+	switch NormalizeStorageType(location) {
+	case storageTypeSFTP:
 		return NewSFTPStorageBackend(location)
+	case storageTypeS3:
+		return NewS3StorageBackend(location)
+	case storageTypeWebDAV:
+		return NewWebDAVStorageBackend(location)
+	case storageTypeFTP:
+		return NewFTPStorageBackend(location)
 	}
-	return &localStorageBackend{}, nil
+	return &localStorageBackend{lockDays: location.WormLockDays}, nil
 }
@@ -0,0 +1,383 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+
+	"github.com/jlaffaye/ftp"
+)
+
+const (
+	ftpTLSModeNone     = "none"
+	ftpTLSModeExplicit = "explicit"
+	ftpTLSModeImplicit = "implicit"
+)
+
+// ftpStorageBackend stores backups on an FTP/FTPS server. Data connections
+// are always passive (EPSV, falling back to PASV).
+//
+// FTP only allows one command at a time per control connection, so metadata
+// operations share one connection while every open reader/writer uses its own.
+// The last transfer connection is kept idle for reuse by the next transfer.
+type ftpStorageBackend struct {
+	location entity.StorageLocation
+
+	mu   sync.Mutex
+	conn *ftp.ServerConn
+
+	idleMu sync.Mutex
+	idle   *ftp.ServerConn
+}
+
+// ftpFileInfo implements os.FileInfo for FTP entries.
+type ftpFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *ftpFileInfo) Name() string       { return fi.name }
+func (fi *ftpFileInfo) Size() int64        { return fi.size }
+func (fi *ftpFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *ftpFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *ftpFileInfo) Sys() interface{}   { return nil }
+func (fi *ftpFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ftpObjectWriter streams writes into a STOR running in the background.
+type ftpObjectWriter struct {
+	pipe    *io.PipeWriter
+	done    chan error
+	backend *ftpStorageBackend
+	path    string
+}
+
+func (w *ftpObjectWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *ftpObjectWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+// Abort fails the transfer and deletes what the server stored of it
+func (w *ftpObjectWriter) Abort(err error) error {
+	if err == nil {
+		err = fmt.Errorf("upload aborted")
+	}
+	w.pipe.CloseWithError(err)
+	<-w.done
+	if err := w.backend.Remove(w.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove partial upload %s: %v", w.path, err)
+	}
+	return nil
+}
+
+// ftpObjectReader returns its transfer connection to the backend when closed.
+type ftpObjectReader struct {
+	resp    *ftp.Response
+	conn    *ftp.ServerConn
+	backend *ftpStorageBackend
+}
+
+func (r *ftpObjectReader) Read(p []byte) (int, error) {
+	return r.resp.Read(p)
+}
+
+func (r *ftpObjectReader) Close() error {
+	err := r.resp.Close()
+	if err != nil {
+		r.conn.Quit()
+		return err
+	}
+	r.backend.releaseTransferConn(r.conn)
+	return nil
+}
+
+// NewFTPStorageBackend connects to an FTP storage location.
+func NewFTPStorageBackend(location *entity.StorageLocation) (StorageBackend, error) {
+	if location == nil {
+		return nil, fmt.Errorf("storage location is required")
+	}
+	conn, err := dialFTP(location)
+	if err != nil {
+		return nil, err
+	}
+	return &ftpStorageBackend{
+		location: *location,
+		conn:     conn,
+	}, nil
+}
+
+// TestFTPConnection verifies that the FTP storage can be reached.
+func TestFTPConnection(location *entity.StorageLocation) error {
+	conn, err := dialFTP(location)
+	if err != nil {
+		return err
+	}
+	defer conn.Quit()
+
+	if location.RemotePath != "" {
+		info, err := ftpStat(conn, location.RemotePath)
+		if err != nil {
+			return fmt.Errorf("remote path not accessible: %v", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("remote path is not a directory: %s", location.RemotePath)
+		}
+	}
+	return nil
+}
+
+func resolveFTPAddress(location *entity.StorageLocation) (string, error) {
+	address := strings.TrimSpace(location.Address)
+	if address == "" {
+		return "", fmt.Errorf("ftp address is required")
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address, nil
+	}
+	port := location.Port
+	if port == 0 {
+		port = 21
+		if normalizeFTPTLSMode(location.TLSMode) == ftpTLSModeImplicit {
+			port = 990
+		}
+	}
+	return net.JoinHostPort(address, fmt.Sprintf("%d", port)), nil
+}
+
+func normalizeFTPTLSMode(mode string) string {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		return ftpTLSModeNone
+	}
+	return mode
+}
+
+func dialFTP(location *entity.StorageLocation) (*ftp.ServerConn, error) {
+	if location == nil {
+		return nil, fmt.Errorf("storage location is required")
+	}
+	addr, err := resolveFTPAddress(location)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []ftp.DialOption{ftp.DialWithTimeout(30 * time.Second)}
+	host, _, _ := net.SplitHostPort(addr)
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: location.TLSSkipVerify,
+		// Data connections must resume the control connection session on most servers
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	switch normalizeFTPTLSMode(location.TLSMode) {
+	case ftpTLSModeNone:
+	case ftpTLSModeExplicit:
+		options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+	case ftpTLSModeImplicit:
+		options = append(options, ftp.DialWithTLS(tlsConfig))
+	default:
+		return nil, fmt.Errorf("unsupported ftp tls_mode: %s", location.TLSMode)
+	}
+
+	conn, err := ftp.Dial(addr, options...)
+	if err != nil {
+		return nil, fmt.Errorf("ftp connection failed: %v", err)
+	}
+
+	username := location.Username
+	if username == "" {
+		username = "anonymous"
+	}
+	if err := conn.Login(username, location.Password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("ftp login failed: %v", err)
+	}
+	return conn, nil
+}
+
+// ftpNotExist maps "file unavailable" replies to os.ErrNotExist.
+func ftpNotExist(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// ftpStat uses MLST where supported and falls back to listing the parent directory.
+func ftpStat(conn *ftp.ServerConn, filePath string) (os.FileInfo, error) {
+	filePath = path.Clean(filePath)
+	entry, err := conn.GetEntry(filePath)
+	if err == nil {
+		return ftpEntryInfo(path.Base(filePath), entry), nil
+	}
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != ftp.StatusNotImplemented {
+		return nil, ftpNotExist(err)
+	}
+
+	if filePath == "/" || filePath == "." {
+		return &ftpFileInfo{name: filePath, isDir: true}, nil
+	}
+	entries, err := conn.List(path.Dir(filePath))
+	if err != nil {
+		return nil, ftpNotExist(err)
+	}
+	name := path.Base(filePath)
+	for _, entry := range entries {
+		if entry.Name == name {
+			return ftpEntryInfo(name, entry), nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func ftpEntryInfo(name string, entry *ftp.Entry) os.FileInfo {
+	return &ftpFileInfo{
+		name:    name,
+		size:    int64(entry.Size),
+		modTime: entry.Time,
+		isDir:   entry.Type == ftp.EntryTypeFolder,
+	}
+}
+
+func (b *ftpStorageBackend) acquireTransferConn() (*ftp.ServerConn, error) {
+	b.idleMu.Lock()
+	conn := b.idle
+	b.idle = nil
+	b.idleMu.Unlock()
+
+	if conn != nil {
+		if err := conn.NoOp(); err == nil {
+			return conn, nil
+		}
+		conn.Quit()
+	}
+	return dialFTP(&b.location)
+}
+
+func (b *ftpStorageBackend) releaseTransferConn(conn *ftp.ServerConn) {
+	b.idleMu.Lock()
+	defer b.idleMu.Unlock()
+	if b.idle == nil {
+		b.idle = conn
+		return
+	}
+	conn.Quit()
+}
+
+func (b *ftpStorageBackend) EnsureDir(dirPath string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dirPath = path.Clean(dirPath)
+	if info, err := ftpStat(b.conn, dirPath); err == nil && info.IsDir() {
+		return nil
+	}
+
+	current := ""
+	if strings.HasPrefix(dirPath, "/") {
+		current = "/"
+	}
+	for _, segment := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current = path.Join(current, segment)
+		if err := b.conn.MakeDir(current); err != nil {
+			// MKD fails for existing directories, only report real failures
+			if info, statErr := ftpStat(b.conn, current); statErr != nil || !info.IsDir() {
+				return fmt.Errorf("failed to create directory %s: %v", current, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *ftpStorageBackend) OpenWriter(filePath string) (io.WriteCloser, error) {
+	conn, err := b.acquireTransferConn()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		err := conn.Stor(filePath, reader)
+		reader.CloseWithError(err)
+		if err != nil {
+			conn.Quit()
+		} else {
+			b.releaseTransferConn(conn)
+		}
+		done <- err
+	}()
+
+	return &ftpObjectWriter{pipe: writer, done: done, backend: b, path: filePath}, nil
+}
+
+func (b *ftpStorageBackend) OpenReader(filePath string) (io.ReadCloser, error) {
+	conn, err := b.acquireTransferConn()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.Retr(filePath)
+	if err != nil {
+		b.releaseTransferConn(conn)
+		return nil, ftpNotExist(err)
+	}
+	return &ftpObjectReader{resp: resp, conn: conn, backend: b}, nil
+}
+
+func (b *ftpStorageBackend) Remove(filePath string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return ftpNotExist(b.conn.Delete(filePath))
+}
+
+func (b *ftpStorageBackend) Stat(filePath string) (os.FileInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return ftpStat(b.conn, filePath)
+}
+
+func (b *ftpStorageBackend) IsLocal() bool {
+	return false
+}
+
+func (b *ftpStorageBackend) Close() error {
+	b.idleMu.Lock()
+	if b.idle != nil {
+		b.idle.Quit()
+		b.idle = nil
+	}
+	b.idleMu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		return b.conn.Quit()
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"backapp-server/entity"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3UploadPartSize is the part size used for streaming multipart uploads.
+// With the 10,000 part limit this allows objects of up to ~640 GB.
+const s3UploadPartSize = 64 << 20
+
+// s3AbortTimeout is how long an aborted upload may take to abort its multipart upload
+// before its request is cancelled
+const s3AbortTimeout = 30 * time.Second
+
+type s3StorageBackend struct {
+	client   *minio.Client
+	bucket   string
+	lockDays int // WORM retention applied to every uploaded object
+}
+
+// s3FileInfo implements os.FileInfo for S3 objects and prefixes.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *s3FileInfo) Name() string       { return fi.name }
+func (fi *s3FileInfo) Size() int64        { return fi.size }
+func (fi *s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *s3FileInfo) IsDir() bool        { return fi.isDir }
+func (fi *s3FileInfo) Sys() interface{}   { return nil }
+func (fi *s3FileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// s3ObjectWriter streams writes into a multipart upload running in the background.
+type s3ObjectWriter struct {
+	pipe   *io.PipeWriter
+	done   chan error
+	cancel context.CancelFunc
+}
+
+func (w *s3ObjectWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *s3ObjectWriter) Close() error {
+	defer w.cancel()
+	w.pipe.Close()
+	return <-w.done
+}
+
+// Abort fails the upload so no object is stored. The failing read lets PutObject abort its
+// multipart upload, the request is cancelled when that does not happen in time.
+func (w *s3ObjectWriter) Abort(err error) error {
+	defer w.cancel()
+	if err == nil {
+		err = fmt.Errorf("upload aborted")
+	}
+	w.pipe.CloseWithError(err)
+	select {
+	case <-w.done:
+	case <-time.After(s3AbortTimeout):
+		w.cancel()
+		<-w.done
+	}
+	return nil
+}
+
+// NewS3StorageBackend connects to an S3-compatible storage location.
+func NewS3StorageBackend(location *entity.StorageLocation) (StorageBackend, error) {
+	client, err := newS3Client(location)
+	if err != nil {
+		return nil, err
+	}
+	return &s3StorageBackend{
+		client:   client,
+		bucket:   location.Bucket,
+		lockDays: location.WormLockDays,
+	}, nil
+}
+
+// TestS3Connection verifies that the bucket of an S3 storage location can be reached.
+func TestS3Connection(location *entity.StorageLocation) error {
+	client, err := newS3Client(location)
+	if err != nil {
+		return err
+	}
+	exists, err := client.BucketExists(context.Background(), location.Bucket)
+	if err != nil {
+		return fmt.Errorf("s3 connection failed: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket does not exist: %s", location.Bucket)
+	}
+	if location.WormLockDays > 0 {
+		return checkS3ObjectLock(location)
+	}
+	return nil
+}
+
+func newS3Client(location *entity.StorageLocation) (*minio.Client, error) {
+	if location == nil {
+		return nil, fmt.Errorf("storage location is required")
+	}
+	if strings.TrimSpace(location.Bucket) == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	host, secure, err := parseS3Endpoint(location.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := minio.BucketLookupDNS
+	if location.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(host, &minio.Options{
+		Creds:        credentials.NewStaticV4(location.AccessKey, location.SecretKey, ""),
+		Secure:       secure,
+		Region:       location.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %v", err)
+	}
+	return client, nil
+}
+
+// parseS3Endpoint splits an endpoint like "https://minio.local:9000" into host and TLS flag.
+// Endpoints without a scheme default to TLS.
+func parseS3Endpoint(endpoint string) (string, bool, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return "", false, fmt.Errorf("s3 endpoint is required")
+	}
+	if !strings.Contains(endpoint, "://") {
+		return strings.TrimSuffix(endpoint, "/"), true, nil
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid s3 endpoint: %v", err)
+	}
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host, true, nil
+	case "http":
+		return parsed.Host, false, nil
+	default:
+		return "", false, fmt.Errorf("unsupported s3 endpoint scheme: %s", parsed.Scheme)
+	}
+}
+
+// s3ObjectKey converts a storage path into an object key without a leading slash.
+func s3ObjectKey(filePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filePath), "/")
+}
+
+func (b *s3StorageBackend) EnsureDir(dirPath string) error {
+	// Object storage has no directories, prefixes are created implicitly.
+	return nil
+}
+
+func (b *s3StorageBackend) OpenWriter(filePath string) (io.WriteCloser, error) {
+	key := s3ObjectKey(filePath)
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+
+	opts := minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s3UploadPartSize,
+	}
+	if b.lockDays > 0 {
+		// Compliance mode cannot be shortened or removed, not even with the root account
+		opts.Mode = minio.Compliance
+		opts.RetainUntilDate = time.Now().AddDate(0, 0, b.lockDays)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := b.client.PutObject(ctx, b.bucket, key, reader, -1, opts)
+		reader.CloseWithError(err)
+		done <- err
+	}()
+
+	return &s3ObjectWriter{pipe: writer, done: done, cancel: cancel}, nil
+}
+
+func (b *s3StorageBackend) OpenReader(filePath string) (io.ReadCloser, error) {
+	object, err := b.client.GetObject(context.Background(), b.bucket, s3ObjectKey(filePath), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, stat it so missing objects fail here instead of on first read.
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, s3NotExist(err)
+	}
+	return object, nil
+}
+
+func (b *s3StorageBackend) Remove(filePath string) error {
+	return b.client.RemoveObject(context.Background(), b.bucket, s3ObjectKey(filePath), minio.RemoveObjectOptions{})
+}
+
+func (b *s3StorageBackend) Stat(filePath string) (os.FileInfo, error) {
+	key := s3ObjectKey(filePath)
+	info, err := b.client.StatObject(context.Background(), b.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return &s3FileInfo{
+			name:    path.Base(key),
+			size:    info.Size,
+			modTime: info.LastModified,
+		}, nil
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, err
+	}
+
+	// Not an object, check whether it is a non-empty prefix.
+	prefix := key
+	if prefix != "" {
+		prefix += "/"
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for object := range b.client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{Prefix: prefix, MaxKeys: 1}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		return &s3FileInfo{name: path.Base(key), isDir: true}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (b *s3StorageBackend) IsLocal() bool {
+	return false
+}
+
+func (b *s3StorageBackend) Close() error {
+	return nil
+}
+
+// s3NotExist maps missing-object errors to os.ErrNotExist.
+func s3NotExist(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return os.ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,582 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+)
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getlastmodified/>
+    <d:quota-available-bytes/>
+    <d:quota-used-bytes/>
+  </d:prop>
+</d:propfind>`
+
+// webdavClient is a minimal WebDAV client supporting streaming uploads
+// with basic or digest authentication.
+type webdavClient struct {
+	baseURL    *url.URL
+	username   string
+	password   string
+	authType   string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	digest *webdavDigestChallenge
+	nc     int
+}
+
+type webdavDigestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+type webdavMultistatus struct {
+	Responses []webdavResponse `xml:"DAV: response"`
+}
+
+type webdavResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webdavPropstat `xml:"DAV: propstat"`
+}
+
+type webdavPropstat struct {
+	Status string     `xml:"DAV: status"`
+	Prop   webdavProp `xml:"DAV: prop"`
+}
+
+type webdavProp struct {
+	ContentLength  string `xml:"DAV: getcontentlength"`
+	LastModified   string `xml:"DAV: getlastmodified"`
+	QuotaAvailable string `xml:"DAV: quota-available-bytes"`
+	QuotaUsed      string `xml:"DAV: quota-used-bytes"`
+	ResourceType   struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+}
+
+// webdavFileInfo implements os.FileInfo for WebDAV resources.
+type webdavFileInfo struct {
+	name           string
+	path           string
+	size           int64
+	modTime        time.Time
+	isDir          bool
+	quotaAvailable int64
+	quotaUsed      int64
+	hasQuota       bool
+}
+
+func (fi *webdavFileInfo) Name() string       { return fi.name }
+func (fi *webdavFileInfo) Size() int64        { return fi.size }
+func (fi *webdavFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *webdavFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *webdavFileInfo) Sys() interface{}   { return nil }
+func (fi *webdavFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+type webdavStorageBackend struct {
+	client *webdavClient
+}
+
+// webdavObjectWriter streams writes into a PUT request running in the background.
+type webdavObjectWriter struct {
+	pipe    *io.PipeWriter
+	done    chan error
+	backend *webdavStorageBackend
+	path    string
+}
+
+func (w *webdavObjectWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *webdavObjectWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+// Abort fails the transfer and deletes what the server stored of it
+func (w *webdavObjectWriter) Abort(err error) error {
+	if err == nil {
+		err = fmt.Errorf("upload aborted")
+	}
+	w.pipe.CloseWithError(err)
+	<-w.done
+	if err := w.backend.Remove(w.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove partial upload %s: %v", w.path, err)
+	}
+	return nil
+}
+
+// NewWebDAVStorageBackend connects to a WebDAV storage location.
+func NewWebDAVStorageBackend(location *entity.StorageLocation) (StorageBackend, error) {
+	client, err := newWebDAVClient(location)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavStorageBackend{client: client}, nil
+}
+
+// TestWebDAVConnection verifies that the WebDAV share can be reached.
+func TestWebDAVConnection(location *entity.StorageLocation) error {
+	client, err := newWebDAVClient(location)
+	if err != nil {
+		return err
+	}
+	remotePath := location.RemotePath
+	if remotePath == "" {
+		remotePath = "/"
+	}
+	info, err := client.stat(remotePath)
+	if err != nil {
+		return fmt.Errorf("remote path not accessible: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("remote path is not a collection: %s", remotePath)
+	}
+	return nil
+}
+
+func newWebDAVClient(location *entity.StorageLocation) (*webdavClient, error) {
+	if location == nil {
+		return nil, fmt.Errorf("storage location is required")
+	}
+	endpoint := strings.TrimSpace(location.Endpoint)
+	if endpoint == "" {
+		return nil, fmt.Errorf("webdav endpoint is required")
+	}
+	baseURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav endpoint: %v", err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported webdav endpoint scheme: %s", baseURL.Scheme)
+	}
+	baseURL.Path = strings.TrimSuffix(baseURL.Path, "/")
+
+	authType := strings.ToLower(strings.TrimSpace(location.AuthType))
+	switch authType {
+	case "", "basic", "digest":
+	default:
+		return nil, fmt.Errorf("unsupported webdav auth_type: %s", authType)
+	}
+
+	return &webdavClient{
+		baseURL:  baseURL,
+		username: location.Username,
+		password: location.Password,
+		authType: authType,
+		// No overall timeout, uploads and downloads can take hours
+		httpClient: &http.Client{},
+	}, nil
+}
+
+func (c *webdavClient) resourceURL(resourcePath string) string {
+	u := *c.baseURL
+	u.Path = c.baseURL.Path + path.Clean("/"+resourcePath)
+	return u.String()
+}
+
+// do sends a request. Bodies that can be replayed (nil or newBody != nil)
+// are retried once if the server asks for digest authentication.
+func (c *webdavClient) do(method, resourcePath string, newBody func() io.Reader, headers map[string]string) (*http.Response, error) {
+	resp, err := c.send(method, resourcePath, newBody, headers)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || c.authType == "basic" || c.username == "" {
+		return resp, nil
+	}
+	challenge := parseWebDAVDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if challenge == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	c.mu.Lock()
+	c.digest = challenge
+	c.nc = 0
+	c.mu.Unlock()
+
+	return c.send(method, resourcePath, newBody, headers)
+}
+
+func (c *webdavClient) send(method, resourcePath string, newBody func() io.Reader, headers map[string]string) (*http.Response, error) {
+	var body io.Reader
+	if newBody != nil {
+		body = newBody()
+	}
+	req, err := http.NewRequest(method, c.resourceURL(resourcePath), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	return c.httpClient.Do(req)
+}
+
+func (c *webdavClient) authorize(req *http.Request) error {
+	if c.username == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.digest == nil {
+		if c.authType == "digest" {
+			// Digest needs a challenge first, the request will be retried on 401
+			return nil
+		}
+		req.SetBasicAuth(c.username, c.password)
+		return nil
+	}
+
+	c.nc++
+	header, err := c.digest.authorization(c.username, c.password, req.Method, req.URL.RequestURI(), c.nc)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", header)
+	return nil
+}
+
+// primeAuth fetches a digest challenge so that non-replayable requests
+// like streaming uploads can be authorized up front.
+func (c *webdavClient) primeAuth() {
+	c.mu.Lock()
+	needsChallenge := c.digest == nil && c.authType != "basic" && c.username != ""
+	c.mu.Unlock()
+	if !needsChallenge {
+		return
+	}
+	if resp, err := c.do("OPTIONS", "/", nil, nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (c *webdavClient) propfind(resourcePath string, depth string) ([]*webdavFileInfo, error) {
+	resp, err := c.do("PROPFIND", resourcePath, func() io.Reader {
+		return strings.NewReader(webdavPropfindBody)
+	}, map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s failed: %s", resourcePath, resp.Status)
+	}
+
+	var result webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %v", err)
+	}
+
+	infos := make([]*webdavFileInfo, 0, len(result.Responses))
+	for _, r := range result.Responses {
+		info := &webdavFileInfo{path: c.hrefToPath(r.Href)}
+		info.name = path.Base(info.path)
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			info.isDir = ps.Prop.ResourceType.Collection != nil
+			if size, err := strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64); err == nil {
+				info.size = size
+			}
+			if modTime, err := http.ParseTime(strings.TrimSpace(ps.Prop.LastModified)); err == nil {
+				info.modTime = modTime
+			}
+			available, availErr := strconv.ParseInt(strings.TrimSpace(ps.Prop.QuotaAvailable), 10, 64)
+			used, usedErr := strconv.ParseInt(strings.TrimSpace(ps.Prop.QuotaUsed), 10, 64)
+			if availErr == nil && usedErr == nil && available >= 0 {
+				info.quotaAvailable = available
+				info.quotaUsed = used
+				info.hasQuota = true
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// hrefToPath converts a href from a multistatus response into a storage path.
+func (c *webdavClient) hrefToPath(href string) string {
+	parsed, err := url.Parse(href)
+	if err != nil {
+		return path.Clean("/" + href)
+	}
+	resourcePath := strings.TrimPrefix(parsed.Path, c.baseURL.Path)
+	return path.Clean("/" + resourcePath)
+}
+
+func (c *webdavClient) stat(resourcePath string) (*webdavFileInfo, error) {
+	infos, err := c.propfind(resourcePath, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return infos[0], nil
+}
+
+// readDir lists the direct children of a collection.
+func (c *webdavClient) readDir(resourcePath string) ([]*webdavFileInfo, error) {
+	infos, err := c.propfind(resourcePath, "1")
+	if err != nil {
+		return nil, err
+	}
+	self := path.Clean("/" + resourcePath)
+	children := make([]*webdavFileInfo, 0, len(infos))
+	for _, info := range infos {
+		if info.path == self {
+			continue
+		}
+		children = append(children, info)
+	}
+	return children, nil
+}
+
+func (b *webdavStorageBackend) EnsureDir(dirPath string) error {
+	dirPath = path.Clean("/" + dirPath)
+	if info, err := b.client.stat(dirPath); err == nil && info.IsDir() {
+		return nil
+	}
+
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current += "/" + segment
+		resp, err := b.client.do("MKCOL", current, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusCreated, http.StatusOK, http.StatusMethodNotAllowed:
+			// 405 means the collection already exists
+		default:
+			return fmt.Errorf("MKCOL %s failed: %s", current, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (b *webdavStorageBackend) OpenWriter(filePath string) (io.WriteCloser, error) {
+	b.client.primeAuth()
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		// The pipe cannot be replayed, so this must not go through the digest retry
+		resp, err := b.client.send(http.MethodPut, filePath, func() io.Reader { return reader }, map[string]string{
+			"Content-Type": "application/octet-stream",
+		})
+		if err == nil {
+			resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+			default:
+				err = fmt.Errorf("PUT %s failed: %s", filePath, resp.Status)
+			}
+		}
+		reader.CloseWithError(err)
+		done <- err
+	}()
+
+	return &webdavObjectWriter{pipe: writer, done: done, backend: b, path: filePath}, nil
+}
+
+func (b *webdavStorageBackend) OpenReader(filePath string) (io.ReadCloser, error) {
+	resp, err := b.client.do(http.MethodGet, filePath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s failed: %s", filePath, resp.Status)
+	}
+}
+
+func (b *webdavStorageBackend) Remove(filePath string) error {
+	resp, err := b.client.do(http.MethodDelete, filePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	default:
+		return fmt.Errorf("DELETE %s failed: %s", filePath, resp.Status)
+	}
+}
+
+func (b *webdavStorageBackend) Stat(filePath string) (os.FileInfo, error) {
+	return b.client.stat(filePath)
+}
+
+func (b *webdavStorageBackend) IsLocal() bool {
+	return false
+}
+
+func (b *webdavStorageBackend) Close() error {
+	b.client.httpClient.CloseIdleConnections()
+	return nil
+}
+
+func parseWebDAVDigestChallenge(headers []string) *webdavDigestChallenge {
+	for _, header := range headers {
+		if len(header) < 7 || !strings.EqualFold(header[:7], "digest ") {
+			continue
+		}
+		params := parseWebDAVAuthParams(header[7:])
+		challenge := &webdavDigestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+		}
+		for _, qop := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				challenge.qop = "auth"
+			}
+		}
+		return challenge
+	}
+	return nil
+}
+
+// parseWebDAVAuthParams parses comma separated key=value pairs with optional quotes.
+func parseWebDAVAuthParams(value string) map[string]string {
+	params := make(map[string]string)
+	for len(value) > 0 {
+		value = strings.TrimLeft(value, " ,")
+		eq := strings.IndexByte(value, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(value[:eq]))
+		value = value[eq+1:]
+		var param string
+		if strings.HasPrefix(value, `"`) {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				param, value = value[1:], ""
+			} else {
+				param, value = value[1:end+1], value[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(value, ',')
+			if end < 0 {
+				param, value = value, ""
+			} else {
+				param, value = value[:end], value[end:]
+			}
+		}
+		params[key] = strings.TrimSpace(param)
+	}
+	return params
+}
+
+func (d *webdavDigestChallenge) authorization(username, password, method, uri string, nc int) (string, error) {
+	var newHash func() hash.Hash
+	algorithm := strings.ToUpper(d.algorithm)
+	switch algorithm {
+	case "", "MD5", "MD5-SESS":
+		newHash = md5.New
+	case "SHA-256", "SHA-256-SESS":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm: %s", d.algorithm)
+	}
+	digest := func(s string) string {
+		h := newHash()
+		io.WriteString(h, s)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 8)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	ncValue := fmt.Sprintf("%08x", nc)
+
+	ha1 := digest(username + ":" + d.realm + ":" + password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = digest(ha1 + ":" + d.nonce + ":" + cnonce)
+	}
+	ha2 := digest(method + ":" + uri)
+
+	var response string
+	if d.qop == "auth" {
+		response = digest(ha1 + ":" + d.nonce + ":" + ncValue + ":" + cnonce + ":auth:" + ha2)
+	} else {
+		response = digest(ha1 + ":" + d.nonce + ":" + ha2)
+	}
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		username, d.realm, d.nonce, uri, response)
+	if d.algorithm != "" {
+		header += ", algorithm=" + d.algorithm
+	}
+	if d.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, d.opaque)
+	}
+	if d.qop == "auth" {
+		header += fmt.Sprintf(", qop=auth, nc=%s, cnonce=\"%s\"", ncValue, cnonce)
+	}
+	return header, nil
+}
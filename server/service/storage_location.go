@@ -26,6 +26,12 @@ func ServiceGetStorageLocation(id uint) (*entity.StorageLocation, error) {
 }
 
 func ServiceCreateStorageLocation(input *entity.StorageLocation) (*entity.StorageLocation, error) {
+	if err := validateMinFreePercent(input); err != nil {
+		return nil, err
+	}
+	if err := validateWorm(input); err != nil {
+		return nil, err
+	}
 	input.Enabled = true
 	if input.Type == "" {
 		input.Type = storageTypeLocal
@@ -40,9 +46,26 @@ func ServiceCreateStorageLocation(input *entity.StorageLocation) (*entity.Storag
 	if err := DB.Create(input).Error; err != nil {
 		return nil, err
 	}
+	if input.Encrypted {
+		// Create the key up front so the recovery key can be exported before the first backup
+		if _, err := ServiceRotateEncryptionKey(input.ID); err != nil {
+			return nil, err
+		}
+	}
 	return input, nil
 }
 
+func validateMinFreePercent(location *entity.StorageLocation) error {
+	if location.MinFreePercent < 0 || location.MinFreePercent >= 100 {
+		return fmt.Errorf("min_free_percent must be between 0 and 100")
+	}
+	if location.MinFreePercent > 0 && location.Dedup {
+		// Pruned runs only release chunks, the disk space returns with the next garbage collection
+		return fmt.Errorf("min_free_percent cannot be combined with dedup")
+	}
+	return nil
+}
+
 // StorageLocationMoveImpact represents what will be affected by moving a storage location
 type StorageLocationMoveImpact struct {
 	BackupProfiles int      `json:"backup_profiles"`
@@ -144,8 +167,9 @@ func ServiceGetStorageLocationDeletionImpact(id uint) (*DeletionImpact, error) {
 	return impact, nil
 }
 
-// ServiceUpdateStorageLocation updates a storage location and moves files if path changed
-func ServiceUpdateStorageLocation(id uint, input *entity.StorageLocation, setEnabled bool) (*entity.StorageLocation, error) {
+// ServiceUpdateStorageLocation updates a storage location and moves files if path changed.
+// Boolean fields are only applied when their JSON key is present in setFields.
+func ServiceUpdateStorageLocation(id uint, input *entity.StorageLocation, setFields map[string]bool) (*entity.StorageLocation, error) {
 	var location entity.StorageLocation
 	if err := DB.First(&location, id).Error; err != nil {
 		return nil, err
@@ -153,6 +177,7 @@ func ServiceUpdateStorageLocation(id uint, input *entity.StorageLocation, setEna
 
 	oldStorageType := NormalizeStorageType(&location)
 	oldBasePath := StorageBasePath(&location)
+	oldAddress, oldPort := location.Address, location.Port
 
 	if input.Name != "" {
 		location.Name = input.Name
@@ -175,25 +200,86 @@ func ServiceUpdateStorageLocation(id uint, input *entity.StorageLocation, setEna
 	if input.Username != "" {
 		location.Username = input.Username
 	}
-	if input.Password != "" {
+	if input.Password != "" && input.Password != entity.RedactedSecret {
 		location.Password = input.Password
 	}
-	if input.SSHKey != "" {
+	if input.SSHKey != "" && input.SSHKey != entity.RedactedSecret {
 		location.SSHKey = input.SSHKey
 	}
 	if input.AuthType != "" {
 		location.AuthType = input.AuthType
 	}
-	if setEnabled {
+	if input.Endpoint != "" {
+		location.Endpoint = input.Endpoint
+	}
+	if input.Bucket != "" {
+		location.Bucket = input.Bucket
+	}
+	if input.Region != "" {
+		location.Region = input.Region
+	}
+	if input.AccessKey != "" {
+		location.AccessKey = input.AccessKey
+	}
+	if input.SecretKey != "" && input.SecretKey != entity.RedactedSecret {
+		location.SecretKey = input.SecretKey
+	}
+	if input.TLSMode != "" {
+		location.TLSMode = input.TLSMode
+	}
+	if setFields["path_style"] {
+		location.PathStyle = input.PathStyle
+	}
+	if setFields["tls_skip_verify"] {
+		location.TLSSkipVerify = input.TLSSkipVerify
+	}
+	if setFields["enabled"] {
 		location.Enabled = input.Enabled
 	}
-	shouldDisableProfiles := setEnabled && location.Enabled == false
+	if setFields["encrypted"] && input.Encrypted && !location.Encrypted {
+		keys, err := loadEncryptionKeys(location.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			if _, err := ServiceRotateEncryptionKey(location.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if setFields["encrypted"] {
+		location.Encrypted = input.Encrypted
+	}
+	if setFields["dedup"] {
+		location.Dedup = input.Dedup
+	}
+	if setFields["min_free_percent"] {
+		location.MinFreePercent = input.MinFreePercent
+	}
+	if setFields["max_concurrent_backups"] {
+		location.MaxConcurrentBackups = input.MaxConcurrentBackups
+	}
+	oldLockDays := location.WormLockDays
+	if setFields["worm_lock_days"] {
+		location.WormLockDays = input.WormLockDays
+	}
+	if err := validateMinFreePercent(&location); err != nil {
+		return nil, err
+	}
+	if err := validateWorm(&location); err != nil {
+		return nil, err
+	}
+	shouldDisableProfiles := setFields["enabled"] && location.Enabled == false
 
 	newStorageType := NormalizeStorageType(&location)
 	newBasePath := StorageBasePath(&location)
 
 	// If path changed, move files to the new location (local only)
 	if oldStorageType == storageTypeLocal && newStorageType == storageTypeLocal && newBasePath != "" && newBasePath != oldBasePath {
+		// Moving would remove locked files from their place
+		if err := checkRunsRemovable("backup_profile_id IN (?)", DB.Model(&entity.BackupProfile{}).Select("id").Where("storage_location_id = ?", id)); err != nil {
+			return nil, fmt.Errorf("cannot move storage location: %w", err)
+		}
 		// Track directories that may become empty after moving files
 		dirsToCleanup := make(map[string]bool)
 
@@ -246,7 +332,10 @@ func ServiceUpdateStorageLocation(id uint, input *entity.StorageLocation, setEna
 							return nil, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(newLocalPath), err)
 						}
 
-						// Move the file
+						// Move the file, files of expired WORM locks are still immutable
+						if oldLockDays > 0 {
+							unlockLocalFile(file.LocalPath)
+						}
 						if err := os.Rename(file.LocalPath, newLocalPath); err != nil {
 							// If rename fails (e.g., cross-device), try copy+delete
 							if err := copyFile(file.LocalPath, newLocalPath); err != nil {
@@ -277,6 +366,11 @@ func ServiceUpdateStorageLocation(id uint, input *entity.StorageLocation, setEna
 			}
 		}
 
+		// Deduplicated locations keep file contents in a chunk store next to the runs
+		if err := moveDedupChunkStore(oldBasePath, newBasePath); err != nil {
+			return nil, err
+		}
+
 		// Clean up empty directories in the old path
 		for dir := range dirsToCleanup {
 			removeEmptyDirs(dir)
@@ -296,6 +390,11 @@ func ServiceUpdateStorageLocation(id uint, input *entity.StorageLocation, setEna
 			return nil, err
 		}
 	}
+	if location.Address != oldAddress || location.Port != oldPort {
+		if err := ServiceResetStorageLocationHostKey(location.ID); err != nil {
+			return nil, err
+		}
+	}
 	return &location, nil
 }
 
@@ -361,6 +460,32 @@ func ServiceDeleteStorageLocation(id string) error {
 	if count > 0 {
 		return fmt.Errorf("cannot delete storage location: %d backup profile(s) still reference it", count)
 	}
+	if err := DB.Model(&entity.BackupProfileReplica{}).Where("storage_location_id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("cannot delete storage location: %d backup profile(s) still use it as a replica", count)
+	}
+	// Replica copies of held and locked runs must survive as well
+	replicatedRuns := DB.Model(&entity.BackupRunReplica{}).Select("backup_run_id").Where("storage_location_id = ?", id)
+	if err := checkRunsRemovable("id IN (?)", replicatedRuns); err != nil {
+		return fmt.Errorf("cannot delete storage location: %w", err)
+	}
 
+	var locations []entity.StorageLocation
+	if err := DB.Where("id = ?", id).Limit(1).Find(&locations).Error; err != nil {
+		return err
+	}
+	if len(locations) > 0 {
+		if err := purgeDedupChunks(&locations[0]); err != nil {
+			return err
+		}
+	}
+	if err := DB.Where("storage_location_id = ?", id).Delete(&entity.EncryptionKey{}).Error; err != nil {
+		return err
+	}
+	if err := DB.Where("storage_location_id = ?", id).Delete(&entity.KnownHost{}).Error; err != nil {
+		return err
+	}
 	return DB.Delete(&entity.StorageLocation{}, "id = ?", id).Error
 }
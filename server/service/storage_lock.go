@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+)
+
+// Storage locations with worm_lock_days keep every file written to them undeletable for that
+// many days. S3 locations use Object Lock in compliance mode, local locations make the files
+// read-only and immutable (chattr +i, when BackApp may set it). On top of that each run
+// records when its lock expires and BackApp refuses to remove it before.
+
+var ErrBackupRunLocked = errors.New("backup run is locked by its storage location")
+
+// chattrWarning reports only once that the immutable attribute cannot be set
+var chattrWarning sync.Once
+
+func validateWorm(location *entity.StorageLocation) error {
+	if location.WormLockDays < 0 {
+		return fmt.Errorf("worm_lock_days must not be negative")
+	}
+	if location.WormLockDays == 0 {
+		return nil
+	}
+	switch NormalizeStorageType(location) {
+	case storageTypeLocal, storageTypeS3:
+	default:
+		return fmt.Errorf("worm_lock_days is only supported on local and S3 storage locations")
+	}
+	if location.Dedup {
+		// Chunks are shared between runs and removed by garbage collection
+		return fmt.Errorf("worm_lock_days cannot be combined with dedup")
+	}
+	return nil
+}
+
+// runLocked reports whether the lock of the run's storage location has not expired yet
+func runLocked(run *entity.BackupRun, now time.Time) bool {
+	return run.LockedUntil != nil && run.LockedUntil.After(now)
+}
+
+func lockedRunError(run *entity.BackupRun) error {
+	return fmt.Errorf("%w: run %d is locked until %s", ErrBackupRunLocked, run.ID, run.LockedUntil.Format(time.RFC3339))
+}
+
+// checkRunRemovable refuses removing the files of a run that is held or still locked
+func checkRunRemovable(run *entity.BackupRun) error {
+	now := time.Now()
+	if runOnHold(run, now) {
+		return heldRunError(run)
+	}
+	if runLocked(run, now) {
+		return lockedRunError(run)
+	}
+	return nil
+}
+
+// checkRunsRemovable refuses when one of the runs matching the condition is held or locked
+func checkRunsRemovable(query string, args ...interface{}) error {
+	if err := checkNoHeldRuns(DB.Model(&entity.BackupRun{}).Where(query, args...)); err != nil {
+		return err
+	}
+	var runs []entity.BackupRun
+	if err := DB.Where(query, args...).Where("locked_until > ?", time.Now()).Limit(1).Find(&runs).Error; err != nil {
+		return err
+	}
+	if len(runs) > 0 {
+		return lockedRunError(&runs[0])
+	}
+	return nil
+}
+
+// runLockUntil returns until when a run that finishes now stays locked, the longest lock of
+// its storage location and replica locations. Files are locked as they are written, so the
+// run lock ends after the lock of its last file.
+func runLockUntil(profile *entity.BackupProfile) *time.Time {
+	days := 0
+	if profile.StorageLocation != nil {
+		days = profile.StorageLocation.WormLockDays
+	}
+	replicas, err := ServiceListReplicasForProfile(profile.ID)
+	if err == nil {
+		for _, replica := range replicas {
+			if location, err := ServiceGetStorageLocation(replica.StorageLocationID); err == nil && location.WormLockDays > days {
+				days = location.WormLockDays
+			}
+		}
+	}
+	if days <= 0 {
+		return nil
+	}
+	until := time.Now().AddDate(0, 0, days)
+	return &until
+}
+
+// lockedFileWriter locks a local file once it is completely written. An aborted file is
+// left unlocked so the partial copy can be removed.
+type lockedFileWriter struct {
+	*os.File
+}
+
+func (w *lockedFileWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	return lockLocalFile(w.Name())
+}
+
+func (w *lockedFileWriter) Abort(err error) error {
+	return w.File.Close()
+}
+
+// lockLocalFile makes a file read-only and, where permitted, immutable so that not even
+// the owner can delete it
+func lockLocalFile(filePath string) error {
+	if err := os.Chmod(filePath, 0444); err != nil {
+		return fmt.Errorf("failed to make %s read-only: %v", filePath, err)
+	}
+	if _, err := exec.LookPath("chattr"); err != nil {
+		chattrWarning.Do(func() { log.Printf("chattr not found, WORM files are only read-only") })
+		return nil
+	}
+	if output, err := exec.Command("chattr", "+i", filePath).CombinedOutput(); err != nil {
+		chattrWarning.Do(func() {
+			log.Printf("Failed to set the immutable attribute, WORM files are only read-only: %s", formatCommandFailure(err, lastLines(string(output), 1)))
+		})
+	}
+	return nil
+}
+
+// unlockLocalFile clears the immutable attribute of a file whose lock expired
+func unlockLocalFile(filePath string) {
+	if _, err := exec.LookPath("chattr"); err == nil {
+		exec.Command("chattr", "-i", filePath).Run()
+	}
+}
+
+// checkS3ObjectLock verifies that the bucket of a WORM location has Object Lock enabled,
+// without it every upload with a retention period is rejected
+func checkS3ObjectLock(location *entity.StorageLocation) error {
+	client, err := newS3Client(location)
+	if err != nil {
+		return err
+	}
+	enabled, _, _, _, err := client.GetObjectLockConfig(context.Background(), location.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to read object lock configuration of bucket %s: %v", location.Bucket, err)
+	}
+	if enabled != "Enabled" {
+		return fmt.Errorf("bucket %s does not have object lock enabled", location.Bucket)
+	}
+	return nil
+}
@@ -38,53 +38,12 @@ func GetStorageUsage() (*entity.TotalStorageUsage, error) {
 			continue
 		}
 
-		storageType := NormalizeStorageType(&loc)
-		if storageType == storageTypeLocal {
-			if disk, err := getDiskUsage(loc.BasePath); err == nil && disk.Ok {
-				usage.TotalBytes = disk.Total
-				usage.FreeBytes = disk.Free
-				usage.UsedBytes = disk.Used
-
-				if usage.TotalBytes > 0 {
-					usage.UsedPercent = float64(usage.UsedBytes) / float64(usage.TotalBytes) * 100
-					usage.FreePercent = float64(usage.FreeBytes) / float64(usage.TotalBytes) * 100
-				}
-
-				result.TotalBytes += usage.TotalBytes
-				result.FreeBytes += usage.FreeBytes
-				result.UsedBytes += usage.UsedBytes
-			}
-		}
-
-		if storageType == storageTypeLocal {
-			// Calculate backup size for this location
-			backupSize, backupCount := calculateBackupSize(loc.BasePath)
-			usage.BackupSizeBytes = backupSize
-			usage.BackupCount = backupCount
-			result.TotalBackupSize += backupSize
-			result.TotalBackups += backupCount
-		} else if storageType == storageTypeSFTP {
-			if backupSize, backupCount, err := getSFTPBackupSize(&loc); err == nil {
-				usage.BackupSizeBytes = backupSize
-				usage.BackupCount = backupCount
-				result.TotalBackupSize += backupSize
-				result.TotalBackups += backupCount
-			}
-			if disk, err := getSFTPDiskUsage(&loc); err == nil && disk.Ok {
-				usage.TotalBytes = disk.Total
-				usage.FreeBytes = disk.Free
-				usage.UsedBytes = disk.Used
-
-				if usage.TotalBytes > 0 {
-					usage.UsedPercent = float64(usage.UsedBytes) / float64(usage.TotalBytes) * 100
-					usage.FreePercent = float64(usage.FreeBytes) / float64(usage.TotalBytes) * 100
-				}
-
-				result.TotalBytes += usage.TotalBytes
-				result.FreeBytes += usage.FreeBytes
-				result.UsedBytes += usage.UsedBytes
-			}
-		}
+		collectStorageUsage(&loc, &usage)
+		result.TotalBytes += usage.TotalBytes
+		result.FreeBytes += usage.FreeBytes
+		result.UsedBytes += usage.UsedBytes
+		result.TotalBackupSize += usage.BackupSizeBytes
+		result.TotalBackups += usage.BackupCount
 
 		result.Locations = append(result.Locations, usage)
 	}
@@ -116,41 +75,78 @@ func GetStorageLocationUsage(locationID uint) (*entity.StorageUsage, error) {
 		return usage, nil
 	}
 
-	storageType := NormalizeStorageType(&loc)
-	if storageType == storageTypeLocal {
+	collectStorageUsage(&loc, usage)
+
+	return usage, nil
+}
+
+// collectStorageUsage fills disk and backup usage for an enabled location
+// based on its storage type.
+func collectStorageUsage(loc *entity.StorageLocation, usage *entity.StorageUsage) {
+	if loc.Dedup {
+		collectDedupUsage(loc, usage)
+	}
+	switch NormalizeStorageType(loc) {
+	case storageTypeLocal:
 		if disk, err := getDiskUsage(loc.BasePath); err == nil && disk.Ok {
-			usage.TotalBytes = disk.Total
-			usage.FreeBytes = disk.Free
-			usage.UsedBytes = disk.Used
-
-			if usage.TotalBytes > 0 {
-				usage.UsedPercent = float64(usage.UsedBytes) / float64(usage.TotalBytes) * 100
-				usage.FreePercent = float64(usage.FreeBytes) / float64(usage.TotalBytes) * 100
-			}
+			applyDiskUsage(usage, disk)
 		}
-	}
-
-	if storageType == storageTypeLocal {
-		// Calculate backup size
 		usage.BackupSizeBytes, usage.BackupCount = calculateBackupSize(loc.BasePath)
-	} else if storageType == storageTypeSFTP {
-		if backupSize, backupCount, err := getSFTPBackupSize(&loc); err == nil {
+	case storageTypeSFTP:
+		if backupSize, backupCount, err := getSFTPBackupSize(loc); err == nil {
+			usage.BackupSizeBytes = backupSize
+			usage.BackupCount = backupCount
+		}
+		if disk, err := getSFTPDiskUsage(loc); err == nil && disk.Ok {
+			applyDiskUsage(usage, disk)
+		}
+	case storageTypeS3:
+		// Buckets have no capacity, only the stored backups are reported
+		if backupSize, backupCount, err := getS3BackupSize(loc); err == nil {
+			usage.BackupSizeBytes = backupSize
+			usage.BackupCount = backupCount
+		}
+	case storageTypeWebDAV:
+		if backupSize, backupCount, err := getWebDAVBackupSize(loc); err == nil {
 			usage.BackupSizeBytes = backupSize
 			usage.BackupCount = backupCount
 		}
-		if disk, err := getSFTPDiskUsage(&loc); err == nil && disk.Ok {
-			usage.TotalBytes = disk.Total
-			usage.FreeBytes = disk.Free
-			usage.UsedBytes = disk.Used
-
-			if usage.TotalBytes > 0 {
-				usage.UsedPercent = float64(usage.UsedBytes) / float64(usage.TotalBytes) * 100
-				usage.FreePercent = float64(usage.FreeBytes) / float64(usage.TotalBytes) * 100
-			}
+		if disk, err := getWebDAVDiskUsage(loc); err == nil && disk.Ok {
+			applyDiskUsage(usage, disk)
+		}
+	case storageTypeFTP:
+		// FTP has no standard way to query free space
+		if backupSize, backupCount, err := getFTPBackupSize(loc); err == nil {
+			usage.BackupSizeBytes = backupSize
+			usage.BackupCount = backupCount
 		}
 	}
+}
 
-	return usage, nil
+// collectDedupUsage reports how much the chunks of a deduplicated location save
+func collectDedupUsage(loc *entity.StorageLocation, usage *entity.StorageUsage) {
+	logical, physical, _, err := DedupStats(loc.ID)
+	if err != nil {
+		return
+	}
+	usage.Dedup = true
+	usage.LogicalSizeBytes = logical
+	usage.PhysicalSizeBytes = physical
+	if physical > 0 {
+		usage.DedupRatio = float64(logical) / float64(physical)
+	}
+}
+
+// applyDiskUsage copies disk totals into the usage and derives percentages
+func applyDiskUsage(usage *entity.StorageUsage, disk diskUsage) {
+	usage.TotalBytes = disk.Total
+	usage.FreeBytes = disk.Free
+	usage.UsedBytes = disk.Used
+
+	if usage.TotalBytes > 0 {
+		usage.UsedPercent = float64(usage.UsedBytes) / float64(usage.TotalBytes) * 100
+		usage.FreePercent = float64(usage.FreeBytes) / float64(usage.TotalBytes) * 100
+	}
 }
 
 // calculateBackupSize recursively calculates the total size of backups in a directory
@@ -172,16 +168,27 @@ func calculateBackupSize(path string) (int64, int64) {
 	return totalSize, fileCount
 }
 
-// CheckLowStorage checks all storage locations for low storage and sends notifications
+// CheckLowStorage checks all storage locations for low storage, sends notifications and
+// prunes runs of locations that dropped below their min_free_percent
 func CheckLowStorage() {
 	usage, err := GetStorageUsage()
 	if err != nil {
 		return
 	}
 
+	var locations []entity.StorageLocation
+	DB.Where("min_free_percent > 0").Find(&locations)
+	minFree := make(map[uint]float64, len(locations))
+	for _, location := range locations {
+		minFree[location.ID] = location.MinFreePercent
+	}
+
 	for _, loc := range usage.Locations {
 		if loc.TotalBytes > 0 && NotificationSvc != nil {
 			NotificationSvc.NotifyLowStorage(loc.Name, loc.FreePercent)
 		}
+		if loc.Enabled && loc.TotalBytes > 0 && loc.FreePercent < minFree[loc.StorageLocationID] {
+			EnforceFreeSpace(loc.StorageLocationID)
+		}
 	}
 }
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"backapp-server/entity"
+
+	"github.com/jlaffaye/ftp"
+)
+
+func getFTPBackupSize(location *entity.StorageLocation) (int64, int64, error) {
+	if location == nil || strings.TrimSpace(location.RemotePath) == "" {
+		return 0, 0, fmt.Errorf("remote_path is required for ftp usage")
+	}
+
+	conn, err := dialFTP(location)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Quit()
+
+	var totalSize int64
+	var fileCount int64
+	walker := conn.Walk(location.RemotePath)
+	for walker.Next() {
+		if err := walker.Err(); err != nil {
+			continue
+		}
+		entry := walker.Stat()
+		if entry == nil || entry.Type != ftp.EntryTypeFile {
+			continue
+		}
+		totalSize += int64(entry.Size)
+		fileCount++
+	}
+
+	return totalSize, fileCount, nil
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+
+	"backapp-server/entity"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// getS3BackupSize sums the size of all objects below the location prefix.
+func getS3BackupSize(location *entity.StorageLocation) (int64, int64, error) {
+	client, err := newS3Client(location)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	prefix := s3ObjectKey(location.RemotePath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var totalSize int64
+	var fileCount int64
+	for object := range client.ListObjects(context.Background(), location.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return 0, 0, object.Err
+		}
+		totalSize += object.Size
+		fileCount++
+	}
+	return totalSize, fileCount, nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+
+	"backapp-server/entity"
+)
+
+// getWebDAVDiskUsage reads the RFC 4331 quota properties of the remote path.
+// Not every server exposes them, in which case an error is returned.
+func getWebDAVDiskUsage(location *entity.StorageLocation) (diskUsage, error) {
+	client, err := newWebDAVClient(location)
+	if err != nil {
+		return diskUsage{}, err
+	}
+	remotePath := location.RemotePath
+	if remotePath == "" {
+		remotePath = "/"
+	}
+
+	info, err := client.stat(remotePath)
+	if err != nil {
+		return diskUsage{}, err
+	}
+	if !info.hasQuota {
+		return diskUsage{}, fmt.Errorf("webdav server does not report quota")
+	}
+
+	return diskUsage{
+		Total: info.quotaUsed + info.quotaAvailable,
+		Free:  info.quotaAvailable,
+		Used:  info.quotaUsed,
+		Ok:    true,
+	}, nil
+}
+
+func getWebDAVBackupSize(location *entity.StorageLocation) (int64, int64, error) {
+	client, err := newWebDAVClient(location)
+	if err != nil {
+		return 0, 0, err
+	}
+	remotePath := location.RemotePath
+	if remotePath == "" {
+		remotePath = "/"
+	}
+
+	var totalSize int64
+	var fileCount int64
+	// Depth: infinity is disabled on most servers, walk collection by collection
+	pending := []string{remotePath}
+	for len(pending) > 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		entries, err := client.readDir(dir)
+		if err != nil {
+			if dir == remotePath {
+				return 0, 0, err
+			}
+			continue // Skip collections we can't access
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				pending = append(pending, entry.path)
+				continue
+			}
+			totalSize += entry.Size()
+			fileCount++
+		}
+	}
+
+	return totalSize, fileCount, nil
+}
@@ -0,0 +1,487 @@
+package service
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"backapp-server/entity"
+)
+
+const (
+	verificationScopeRun      = "run"
+	verificationScopeProfile  = "profile"
+	verificationScopeLocation = "location"
+
+	verificationTriggerManual    = "manual"
+	verificationTriggerScheduled = "scheduled"
+
+	verificationStatusPending = "pending"
+	verificationStatusRunning = "running"
+	verificationStatusPassed  = "passed"
+	verificationStatusFailed  = "failed"
+	verificationStatusError   = "error"
+
+	verificationResultMissing          = "missing"
+	verificationResultChecksumMismatch = "checksum_mismatch"
+	verificationResultArchiveCorrupt   = "archive_corrupt"
+	verificationResultUnreadable       = "unreadable"
+
+	integrityStatusOK         = "ok"
+	integrityStatusCorrupted  = "corrupted"
+	integrityStatusMissing    = "missing"
+	integrityStatusUnreadable = "unreadable"
+)
+
+// verificationMu runs one verification at a time, they read every stored file
+var verificationMu sync.Mutex
+
+// VerificationFilter selects verification records, zero values match everything
+type VerificationFilter struct {
+	BackupRunID       uint
+	BackupProfileID   uint
+	StorageLocationID uint
+}
+
+// ServiceVerifyBackupRun verifies the stored files of a run in the background
+func ServiceVerifyBackupRun(runID uint) (*entity.BackupVerification, error) {
+	return startVerification(verificationScopeRun, runID)
+}
+
+// ServiceVerifyBackupProfile verifies the stored files of all runs of a profile in the background
+func ServiceVerifyBackupProfile(profileID uint) (*entity.BackupVerification, error) {
+	return startVerification(verificationScopeProfile, profileID)
+}
+
+// ServiceVerifyStorageLocation verifies all files stored on a location in the background
+func ServiceVerifyStorageLocation(locationID uint) (*entity.BackupVerification, error) {
+	return startVerification(verificationScopeLocation, locationID)
+}
+
+// startVerification creates the verification record and runs it in the background.
+// The returned record is updated with the outcome.
+func startVerification(scope string, id uint) (*entity.BackupVerification, error) {
+	verification, err := prepareVerification(scope, id, verificationTriggerManual)
+	if err != nil {
+		return nil, err
+	}
+	started := *verification
+	go runVerification(verification)
+	return &started, nil
+}
+
+func ServiceListVerifications(filter VerificationFilter) ([]entity.BackupVerification, error) {
+	query := DB.Model(&entity.BackupVerification{})
+	if filter.BackupRunID != 0 {
+		query = query.Where("backup_run_id = ?", filter.BackupRunID)
+	}
+	if filter.BackupProfileID != 0 {
+		query = query.Where("backup_profile_id = ?", filter.BackupProfileID)
+	}
+	if filter.StorageLocationID != 0 {
+		query = query.Where("storage_location_id = ?", filter.StorageLocationID)
+	}
+	var verifications []entity.BackupVerification
+	if err := query.Order("start_time DESC").Find(&verifications).Error; err != nil {
+		return nil, err
+	}
+	return verifications, nil
+}
+
+func ServiceGetVerification(id uint) (*entity.BackupVerification, error) {
+	var verification entity.BackupVerification
+	if err := DB.Preload("Results").First(&verification, id).Error; err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
+
+// prepareVerification checks that the target exists and creates the verification record
+func prepareVerification(scope string, id uint, trigger string) (*entity.BackupVerification, error) {
+	verification := &entity.BackupVerification{
+		Scope:     scope,
+		Trigger:   trigger,
+		Status:    verificationStatusPending,
+		StartTime: time.Now(),
+	}
+
+	switch scope {
+	case verificationScopeRun:
+		var run entity.BackupRun
+		if err := DB.First(&run, id).Error; err != nil {
+			return nil, err
+		}
+		if run.Status == "running" {
+			return nil, fmt.Errorf("backup run is still running")
+		}
+		verification.BackupRunID = &run.ID
+		verification.BackupProfileID = &run.BackupProfileID
+	case verificationScopeProfile:
+		profile, err := ServiceGetBackupProfile(id)
+		if err != nil {
+			return nil, err
+		}
+		verification.BackupProfileID = &profile.ID
+		verification.StorageLocationID = &profile.StorageLocationID
+	case verificationScopeLocation:
+		location, err := ServiceGetStorageLocation(id)
+		if err != nil {
+			return nil, err
+		}
+		verification.StorageLocationID = &location.ID
+	default:
+		return nil, fmt.Errorf("unknown verification scope %q", scope)
+	}
+
+	if verification.StorageLocationID == nil {
+		location, err := GetStorageLocationForRun(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load storage location: %v", err)
+		}
+		verification.StorageLocationID = &location.ID
+	}
+
+	if err := DB.Create(verification).Error; err != nil {
+		return nil, fmt.Errorf("failed to create verification: %v", err)
+	}
+	return verification, nil
+}
+
+// runVerification re-reads every stored file in scope, compares its SHA-256 checksum with
+// the recorded one and tests the archives of compressed rules. Files that changed or
+// disappeared are marked and the verification record is completed.
+func runVerification(verification *entity.BackupVerification) {
+	verificationMu.Lock()
+	defer verificationMu.Unlock()
+
+	verification.Status = verificationStatusRunning
+	verification.StartTime = time.Now()
+	DB.Save(verification)
+
+	err := executeVerification(verification)
+	verification.EndTime = time.Now()
+	switch {
+	case err != nil:
+		verification.Status = verificationStatusError
+		verification.ErrorMessage = err.Error()
+		log.Printf("Verification %d failed: %v", verification.ID, err)
+	case verification.FilesFailed > 0:
+		verification.Status = verificationStatusFailed
+		log.Printf("Verification %d found %d of %d files failing", verification.ID, verification.FilesFailed, verification.FilesChecked)
+	default:
+		verification.Status = verificationStatusPassed
+		log.Printf("Verification %d passed, %d files checked", verification.ID, verification.FilesChecked)
+	}
+	if err := DB.Save(verification).Error; err != nil {
+		log.Printf("Failed to save verification %d: %v", verification.ID, err)
+	}
+
+	if verification.Status != verificationStatusPassed {
+		notifyVerificationFailed(verification)
+	}
+}
+
+func executeVerification(verification *entity.BackupVerification) error {
+	location, err := ServiceGetStorageLocation(*verification.StorageLocationID)
+	if err != nil {
+		return fmt.Errorf("failed to load storage location: %v", err)
+	}
+	if !location.Enabled {
+		return fmt.Errorf("storage location is disabled")
+	}
+
+	files, err := verificationFiles(verification)
+	if err != nil {
+		return fmt.Errorf("failed to load backup files: %v", err)
+	}
+
+	backend, err := NewStorageBackend(location)
+	if err != nil {
+		return fmt.Errorf("failed to open storage location: %v", err)
+	}
+	defer backend.Close()
+
+	verifier := &fileVerifier{
+		backend:  backend,
+		rules:    make(map[uint]*entity.FileRule),
+		outcomes: make(map[string]*verificationOutcome),
+	}
+	for i := range files {
+		file := &files[i]
+		outcome := verifier.verify(file)
+		verification.FilesChecked++
+
+		now := time.Now()
+		updates := map[string]interface{}{"integrity_status": outcome.integrityStatus(), "verified_at": now}
+		if outcome.status == "" && file.Checksum == "" && outcome.checksum != "" {
+			// Files stored before checksums were recorded get their current checksum as baseline
+			updates["checksum"] = outcome.checksum
+		}
+		if err := DB.Model(&entity.BackupFile{}).Where("id = ?", file.ID).Updates(updates).Error; err != nil {
+			log.Printf("Failed to update integrity status of backup file %d: %v", file.ID, err)
+		}
+
+		if outcome.status == "" {
+			continue
+		}
+		verification.FilesFailed++
+		result := entity.BackupVerificationResult{
+			BackupVerificationID: verification.ID,
+			BackupFileID:         file.ID,
+			BackupRunID:          file.BackupRunID,
+			LocalPath:            file.LocalPath,
+			Status:               outcome.status,
+			ExpectedChecksum:     file.Checksum,
+			ActualChecksum:       outcome.checksum,
+			Message:              outcome.message,
+		}
+		if err := DB.Create(&result).Error; err != nil {
+			log.Printf("Failed to save verification result for backup file %d: %v", file.ID, err)
+		}
+	}
+	verification.BytesRead = verifier.bytesRead
+	return nil
+}
+
+// verificationFiles returns the stored files in scope of a verification. Files of runs
+// that are still running are skipped.
+func verificationFiles(verification *entity.BackupVerification) ([]entity.BackupFile, error) {
+	query := DB.Model(&entity.BackupFile{}).
+		Joins("JOIN backup_runs ON backup_runs.id = backup_files.backup_run_id").
+		Where("backup_files.deleted = ? AND backup_files.local_path <> '' AND backup_runs.status <> ?", false, "running")
+
+	switch verification.Scope {
+	case verificationScopeRun:
+		query = query.Where("backup_files.backup_run_id = ?", *verification.BackupRunID)
+	case verificationScopeProfile:
+		query = query.Where("backup_runs.backup_profile_id = ?", *verification.BackupProfileID)
+	case verificationScopeLocation:
+		query = query.
+			Joins("JOIN backup_profiles ON backup_profiles.id = backup_runs.backup_profile_id").
+			Where("backup_profiles.storage_location_id = ?", *verification.StorageLocationID)
+	}
+
+	var files []entity.BackupFile
+	if err := query.Order("backup_files.id").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// verificationOutcome is the result of checking the data stored at one path. An empty
+// status means the data is intact.
+type verificationOutcome struct {
+	status   string
+	checksum string
+	message  string
+}
+
+func (o *verificationOutcome) integrityStatus() string {
+	switch o.status {
+	case "":
+		return integrityStatusOK
+	case verificationResultMissing:
+		return integrityStatusMissing
+	case verificationResultUnreadable:
+		return integrityStatusUnreadable
+	default:
+		return integrityStatusCorrupted
+	}
+}
+
+// fileVerifier checks stored files of one storage location. Incremental runs share
+// stored data, each path is read once per verification.
+type fileVerifier struct {
+	backend   StorageBackend
+	rules     map[uint]*entity.FileRule
+	outcomes  map[string]*verificationOutcome
+	bytesRead int64
+}
+
+func (v *fileVerifier) verify(file *entity.BackupFile) *verificationOutcome {
+	outcome, ok := v.outcomes[file.LocalPath]
+	if !ok {
+		outcome = v.check(file)
+		v.outcomes[file.LocalPath] = outcome
+	}
+	if outcome.status == "" && file.Checksum != "" && outcome.checksum != file.Checksum {
+		return &verificationOutcome{
+			status:   verificationResultChecksumMismatch,
+			checksum: outcome.checksum,
+			message:  fmt.Sprintf("checksum %s does not match the recorded %s", outcome.checksum, file.Checksum),
+		}
+	}
+	return outcome
+}
+
+// check reads the stored data of a file, returning its checksum and the outcome of the
+// archive test for files of compressed rules.
+func (v *fileVerifier) check(file *entity.BackupFile) *verificationOutcome {
+	if _, err := v.backend.Stat(file.LocalPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &verificationOutcome{status: verificationResultMissing, message: "file no longer exists in storage"}
+		}
+		return &verificationOutcome{status: verificationResultUnreadable, message: err.Error()}
+	}
+
+	reader, err := openStoredFile(v.backend, file.LocalPath, file.Encrypted)
+	if err != nil {
+		return &verificationOutcome{status: verificationResultUnreadable, message: err.Error()}
+	}
+	defer reader.Close()
+
+	// Archives are tested from a temporary copy, the storage may be remote or encrypted
+	format, password := v.archiveFormat(file)
+	var archive *os.File
+	writer := io.Writer(io.Discard)
+	if format != "" {
+		archive, err = os.CreateTemp("", "backapp-verify-*."+format)
+		if err != nil {
+			return &verificationOutcome{status: verificationResultUnreadable, message: fmt.Sprintf("failed to create temporary file: %v", err)}
+		}
+		defer os.Remove(archive.Name())
+		defer archive.Close()
+		writer = archive
+	}
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(writer, hasher), reader)
+	v.bytesRead += written
+	if err != nil {
+		return &verificationOutcome{status: verificationResultUnreadable, message: fmt.Sprintf("read failed: %v", err)}
+	}
+	outcome := &verificationOutcome{checksum: hex.EncodeToString(hasher.Sum(nil))}
+	if file.Checksum != "" && outcome.checksum != file.Checksum {
+		return outcome
+	}
+
+	if archive != nil {
+		if err := archive.Close(); err != nil {
+			return &verificationOutcome{status: verificationResultUnreadable, checksum: outcome.checksum, message: err.Error()}
+		}
+		if err := testArchive(archive.Name(), format, password); err != nil {
+			outcome.status = verificationResultArchiveCorrupt
+			outcome.message = err.Error()
+		}
+	}
+	return outcome
+}
+
+// archiveFormat returns the archive format and password of files stored by compressed rules
+func (v *fileVerifier) archiveFormat(file *entity.BackupFile) (string, string) {
+	if file.FileRuleID == 0 {
+		return "", ""
+	}
+	rule, ok := v.rules[file.FileRuleID]
+	if !ok {
+		var rules []entity.FileRule
+		if err := DB.Where("id = ?", file.FileRuleID).Limit(1).Find(&rules).Error; err == nil && len(rules) > 0 {
+			rule = &rules[0]
+		}
+		v.rules[file.FileRuleID] = rule
+	}
+	if rule == nil || !rule.Compress {
+		return "", ""
+	}
+	format := storedArchiveFormat(file.LocalPath)
+	if format == "7z" {
+		return format, rule.CompressPassword
+	}
+	return format, ""
+}
+
+// testArchive checks the integrity of an archive, zip CRCs are verified by reading every
+// entry and 7z archives are tested with 7z t.
+func testArchive(archivePath, format, password string) error {
+	if format == "zip" {
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return fmt.Errorf("zip test failed: %v", err)
+		}
+		defer reader.Close()
+		for _, entry := range reader.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("zip test failed for %s: %v", entry.Name, err)
+			}
+			_, err = io.Copy(io.Discard, rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("zip test failed for %s: %v", entry.Name, err)
+			}
+		}
+		return nil
+	}
+
+	if _, err := exec.LookPath("7z"); err != nil {
+		log.Printf("7z not available, skipping archive test of %s", archivePath)
+		return nil
+	}
+	args := []string{"t", "-bd", "-y"}
+	if password != "" {
+		args = append(args, "-p"+password)
+	}
+	args = append(args, archivePath)
+	output, err := exec.Command("7z", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("7z test failed: %s", formatCommandFailure(err, lastLines(string(output), 3)))
+	}
+	return nil
+}
+
+// lastLines returns the last n non-empty lines of command output
+func lastLines(output string, n int) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "; ")
+}
+
+// notifyVerificationFailed notifies subscribers about a failed or aborted verification
+func notifyVerificationFailed(verification *entity.BackupVerification) {
+	if NotificationSvc == nil {
+		return
+	}
+	name := verificationTargetName(verification)
+	message := verification.ErrorMessage
+	if verification.Status == verificationStatusFailed {
+		message = fmt.Sprintf("%d of %d files failed", verification.FilesFailed, verification.FilesChecked)
+	}
+	NotificationSvc.NotifyVerificationFailed(verification.ID, verification.BackupProfileID, name, message)
+}
+
+func verificationTargetName(verification *entity.BackupVerification) string {
+	switch verification.Scope {
+	case verificationScopeRun:
+		return fmt.Sprintf("backup run %d", *verification.BackupRunID)
+	case verificationScopeProfile:
+		var profiles []entity.BackupProfile
+		if err := DB.Where("id = ?", *verification.BackupProfileID).Limit(1).Find(&profiles).Error; err == nil && len(profiles) > 0 {
+			return profiles[0].Name
+		}
+	case verificationScopeLocation:
+		var locations []entity.StorageLocation
+		if err := DB.Where("id = ?", *verification.StorageLocationID).Limit(1).Find(&locations).Error; err == nil && len(locations) > 0 {
+			return locations[0].Name
+		}
+	}
+	return verification.Scope
+}
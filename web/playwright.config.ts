@@ -1,4 +1,5 @@
 import { defineConfig, devices } from '@playwright/test';
+import { AUTH_STATE_PATH, E2E_ADMIN_PASSWORD, E2E_ADMIN_USERNAME } from './tests/global-setup';
 
 /**
  * Read environment variables from file.
@@ -19,6 +20,8 @@ export default defineConfig({
   forbidOnly: !!process.env.CI,
   retries: 0,
   workers: 1,
+  /* Sign in once before all tests */
+  globalSetup: './tests/global-setup.ts',
   /* Reporter to use. See https://playwright.dev/docs/test-reporters */
   reporter: [
     ['list'],
@@ -30,6 +33,12 @@ export default defineConfig({
     /* Base URL to use in actions like `await page.goto('')`. */
     baseURL: 'http://localhost:8081',
 
+    /* Session created by the global setup, API requests must echo its CSRF token */
+    storageState: AUTH_STATE_PATH,
+    extraHTTPHeaders: {
+      'X-CSRF-Token': process.env.BACKAPP_CSRF_TOKEN ?? '',
+    },
+
     /* Collect trace when retrying the failed test. See https://playwright.dev/docs/trace-viewer */
     trace: 'on-first-retry',
   },
@@ -75,7 +84,11 @@ export default defineConfig({
   /* Run your local dev server before starting the tests */
   webServer: {
     command: 'cd ../build && ./backapp-server -port 8081 -test-mode=true',
-    url: 'http://localhost:8081',
+    env: {
+      BACKAPP_ADMIN_USERNAME: E2E_ADMIN_USERNAME,
+      BACKAPP_ADMIN_PASSWORD: E2E_ADMIN_PASSWORD,
+    },
+    url: 'http://localhost:8081/health',
     reuseExistingServer: !process.env.CI,
     timeout: 120 * 1000,
   },
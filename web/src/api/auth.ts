@@ -0,0 +1,90 @@
+import type { APIToken, APITokenCreated, APITokenCreateInput, AuthSession, User, UserCreateInput, UserRole, UserScope, UserScopeInput } from '../types/user';
+import { fetchJSON, fetchWithoutResponse } from './client';
+
+export const authApi = {
+  async me(): Promise<AuthSession> {
+    return fetchJSON<AuthSession>('/auth/me');
+  },
+
+  async logout(): Promise<boolean> {
+    return fetchWithoutResponse('/auth/logout', {
+      method: 'POST',
+    });
+  },
+
+  async changePassword(currentPassword: string, newPassword: string): Promise<boolean> {
+    return fetchWithoutResponse('/auth/password', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ current_password: currentPassword, new_password: newPassword }),
+    });
+  },
+
+  async listTokens(): Promise<APIToken[]> {
+    return fetchJSON<APIToken[]>('/auth/tokens');
+  },
+
+  async createToken(data: APITokenCreateInput): Promise<APITokenCreated> {
+    return fetchJSON<APITokenCreated>('/auth/tokens', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify(data),
+    });
+  },
+
+  async revokeToken(id: number): Promise<APIToken> {
+    return fetchJSON<APIToken>(`/auth/tokens/${id}`, {
+      method: 'DELETE',
+    });
+  },
+};
+
+export const userApi = {
+  async list(): Promise<User[]> {
+    return fetchJSON<User[]>('/users');
+  },
+
+  async create(data: UserCreateInput): Promise<User> {
+    return fetchJSON<User>('/users', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify(data),
+    });
+  },
+
+  async updateRole(id: number, role: UserRole): Promise<User> {
+    return fetchJSON<User>(`/users/${id}`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ role }),
+    });
+  },
+
+  async delete(id: number): Promise<boolean> {
+    return fetchWithoutResponse(`/users/${id}`, {
+      method: 'DELETE',
+    });
+  },
+
+  async getScopes(id: number): Promise<UserScope[]> {
+    return fetchJSON<UserScope[]>(`/users/${id}/scopes`);
+  },
+
+  async setScopes(id: number, scopes: UserScopeInput[]): Promise<UserScope[]> {
+    return fetchJSON<UserScope[]>(`/users/${id}/scopes`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify(scopes),
+    });
+  },
+};
@@ -54,8 +54,8 @@ export const backupProfileApi = {
     });
   },
 
-  async execute(id: number): Promise<{ message: string; profile_id: number }> {
-    return fetchJSON<{ message: string; profile_id: number }>(`/backup-profiles/${id}/execute`, {
+  async execute(id: number): Promise<{ message: string; profile_id: number; backup_run_id: number }> {
+    return fetchJSON<{ message: string; profile_id: number; backup_run_id: number }>(`/backup-profiles/${id}/execute`, {
       method: 'POST',
     });
   },
@@ -1,7 +1,29 @@
 const API_BASE_URL = '/api/v1';
+const CSRF_COOKIE = 'backapp_csrf';
+
+function csrfToken(): string {
+  const match = document.cookie.split('; ').find((cookie) => cookie.startsWith(`${CSRF_COOKIE}=`));
+  return match ? decodeURIComponent(match.substring(CSRF_COOKIE.length + 1)) : '';
+}
+
+// apiFetch adds the CSRF token to state-changing requests and sends the user
+// to the login page once the session has expired.
+async function apiFetch(endpoint: string, options?: RequestInit): Promise<Response> {
+  const method = (options?.method ?? 'GET').toUpperCase();
+  const headers = new Headers(options?.headers);
+  if (!['GET', 'HEAD', 'OPTIONS'].includes(method)) {
+    headers.set('X-CSRF-Token', csrfToken());
+  }
+  const response = await fetch(`${API_BASE_URL}${endpoint}`, { ...options, headers });
+  if (response.status === 401) {
+    const next = window.location.pathname + window.location.search;
+    window.location.assign(`/login?next=${encodeURIComponent(next)}`);
+  }
+  return response;
+}
 
 export async function fetchJSON<T>(endpoint: string, options?: RequestInit): Promise<T> {
-  const response = await fetch(`${API_BASE_URL}${endpoint}`, options);
+  const response = await apiFetch(endpoint, options);
   if (!response.ok) {
     throw new Error(`HTTP error! status: ${response.status}`);
   }
@@ -9,7 +31,7 @@ export async function fetchJSON<T>(endpoint: string, options?: RequestInit): Pro
 }
 
 export async function fetchWithoutResponse(endpoint: string, options?: RequestInit): Promise<boolean> {
-  const response = await fetch(`${API_BASE_URL}${endpoint}`, options);
+  const response = await apiFetch(endpoint, options);
   if (!response.ok) {
     throw new Error(`HTTP error! status: ${response.status}`);
   }
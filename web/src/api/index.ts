@@ -1,8 +1,10 @@
+export { authApi, userApi } from './auth';
 export { serverApi } from './servers';
 export { storageLocationApi } from './storage-locations';
 export { namingRuleApi } from './naming-rules';
 export { commandApi } from './commands';
 export { fileRuleApi } from './file-rules';
+export { replicaApi } from './replicas';
 export { backupProfileApi } from './backup-profiles';
 export { backupRunApi, backupFileApi } from './backup-runs';
 export { fileExplorerApi } from './file-explorer';
@@ -55,6 +55,10 @@ export interface StorageUsage {
   free_percent: number;
   backup_count: number;
   backup_size_bytes: number;
+  dedup?: boolean;
+  logical_size_bytes?: number;
+  physical_size_bytes?: number;
+  dedup_ratio?: number;
 }
 
 export interface TotalStorageUsage {
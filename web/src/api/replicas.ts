@@ -0,0 +1,28 @@
+import type { BackupProfileReplica, BackupProfileReplicaCreateInput, BackupRunReplica } from '../types/replica';
+import { fetchJSON, fetchWithoutResponse } from './client';
+
+export const replicaApi = {
+  async listByProfile(profileId: number): Promise<BackupProfileReplica[]> {
+    return fetchJSON<BackupProfileReplica[]>(`/backup-profiles/${profileId}/replicas`);
+  },
+
+  async create(profileId: number, data: BackupProfileReplicaCreateInput): Promise<BackupProfileReplica> {
+    return fetchJSON<BackupProfileReplica>(`/backup-profiles/${profileId}/replicas`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify(data),
+    });
+  },
+
+  async delete(id: number): Promise<boolean> {
+    return fetchWithoutResponse(`/replicas/${id}`, {
+      method: 'DELETE',
+    });
+  },
+
+  async listByRun(runId: number): Promise<BackupRunReplica[]> {
+    return fetchJSON<BackupRunReplica[]>(`/backup-runs/${runId}/replicas`);
+  },
+};
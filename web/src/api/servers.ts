@@ -1,5 +1,6 @@
 import type { Server, ServerCreateInput } from '../types/server';
 import type { DeletionImpact } from '../types/deletion-impact';
+import type { KnownHost } from '../types/known-host';
 import { fetchJSON, fetchWithoutResponse } from './client';
 
 export const serverApi = {
@@ -43,4 +44,20 @@ export const serverApi = {
       method: 'POST',
     });
   },
+
+  async getHostKey(id: number): Promise<KnownHost> {
+    return fetchJSON<KnownHost>(`/servers/${id}/host-key`);
+  },
+
+  async acceptHostKey(id: number): Promise<KnownHost> {
+    return fetchJSON<KnownHost>(`/servers/${id}/host-key/accept`, {
+      method: 'POST',
+    });
+  },
+
+  async resetHostKey(id: number): Promise<boolean> {
+    return fetchWithoutResponse(`/servers/${id}/host-key`, {
+      method: 'DELETE',
+    });
+  },
 };
@@ -1,5 +1,6 @@
-import type { StorageLocation, StorageLocationCreateInput } from '../types/storage-location';
+import type { EncryptionKey, StorageLocation, StorageLocationCreateInput } from '../types/storage-location';
 import type { DeletionImpact, StorageLocationMoveImpact } from '../types/deletion-impact';
+import type { KnownHost } from '../types/known-host';
 import { fetchJSON, fetchWithoutResponse } from './client';
 
 export const storageLocationApi = {
@@ -43,4 +44,34 @@ export const storageLocationApi = {
       method: 'POST',
     });
   },
+
+  async listEncryptionKeys(id: number): Promise<EncryptionKey[]> {
+    return fetchJSON<EncryptionKey[]>(`/storage-locations/${id}/encryption-keys`);
+  },
+
+  async rotateEncryptionKey(id: number): Promise<EncryptionKey> {
+    return fetchJSON<EncryptionKey>(`/storage-locations/${id}/encryption-keys/rotate`, {
+      method: 'POST',
+    });
+  },
+
+  async getRecoveryKey(id: number): Promise<{ recovery_key: string }> {
+    return fetchJSON<{ recovery_key: string }>(`/storage-locations/${id}/recovery-key`);
+  },
+
+  async getHostKey(id: number): Promise<KnownHost> {
+    return fetchJSON<KnownHost>(`/storage-locations/${id}/host-key`);
+  },
+
+  async acceptHostKey(id: number): Promise<KnownHost> {
+    return fetchJSON<KnownHost>(`/storage-locations/${id}/host-key/accept`, {
+      method: 'POST',
+    });
+  },
+
+  async resetHostKey(id: number): Promise<boolean> {
+    return fetchWithoutResponse(`/storage-locations/${id}/host-key`, {
+      method: 'DELETE',
+    });
+  },
 };
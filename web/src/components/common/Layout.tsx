@@ -2,6 +2,7 @@ import BackupIcon from '@mui/icons-material/Backup';
 import ComputerIcon from '@mui/icons-material/Computer';
 import DashboardIcon from '@mui/icons-material/Dashboard';
 import LabelIcon from '@mui/icons-material/Label';
+import LogoutIcon from '@mui/icons-material/Logout';
 import MenuIcon from '@mui/icons-material/Menu';
 import NotificationsIcon from '@mui/icons-material/Notifications';
 import PlayArrowIcon from '@mui/icons-material/PlayArrow';
@@ -18,12 +19,14 @@ import {
   ListItemIcon,
   ListItemText,
   Toolbar,
+  Tooltip,
   Typography,
   useMediaQuery,
   useTheme,
 } from '@mui/material';
 import { ReactNode, useState } from 'react';
 import { Link, useLocation } from 'react-router-dom';
+import { authApi } from '../../api';
 import { appVersion, buildNumber } from '../../buildInfo';
 
 const drawerWidth = 240;
@@ -42,6 +45,14 @@ function Layout({ children }: LayoutProps) {
     setMobileOpen(!mobileOpen);
   };
 
+  const handleLogout = async () => {
+    try {
+      await authApi.logout();
+    } finally {
+      window.location.assign('/login');
+    }
+  };
+
   const navItems = [
     { path: '/dashboard', label: 'Dashboard', icon: <DashboardIcon /> },
     { path: '/servers', label: 'Servers', icon: <ComputerIcon /> },
@@ -188,9 +199,14 @@ function Layout({ children }: LayoutProps) {
               <MenuIcon />
             </IconButton>
           )}
-          <Typography variant="h6" noWrap component="div">
+          <Typography variant="h6" noWrap component="div" sx={{ flexGrow: 1 }}>
             {getPageTitle()}
           </Typography>
+          <Tooltip title="Sign out">
+            <IconButton color="inherit" aria-label="sign out" onClick={handleLogout} data-testid="logout-btn">
+              <LogoutIcon />
+            </IconButton>
+          </Tooltip>
         </Toolbar>
       </AppBar>
 
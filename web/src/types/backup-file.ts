@@ -3,11 +3,28 @@ export interface BackupFile {
   backup_run_id: number;
   remote_path?: string;
   local_path: string;
+  snapshot_path?: string;
   size_bytes?: number;
   file_size?: number;
+  remote_mod_time?: string;
+  remote_mode?: number;
+  reused_from_run_id?: number;
   checksum?: string;
+  remote_checksum?: string;
+  checksum_mismatch?: boolean;
+  integrity_status?: 'ok' | 'corrupted' | 'missing' | 'unreadable';
+  verified_at?: string;
   deleted?: boolean;
   available?: boolean;
   deleted_at?: string;
   created_at: string;
 }
+
+export interface ArchiveEntry {
+  path: string;
+  size: number;
+  compressed_size?: number;
+  mod_time?: string;
+  mode?: number;
+  is_dir: boolean;
+}
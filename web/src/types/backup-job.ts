@@ -0,0 +1,16 @@
+export type BackupJobStatus = 'queued' | 'running' | 'completed' | 'failed' | 'skipped' | 'cancelled';
+
+export interface BackupJob {
+  id: number;
+  backup_profile_id: number;
+  server_id: number;
+  storage_location_id: number;
+  trigger: 'manual' | 'scheduled';
+  allow_disabled: boolean;
+  status: BackupJobStatus;
+  backup_run_id?: number;
+  message?: string;
+  queued_at: string;
+  started_at?: string;
+  finished_at?: string;
+}
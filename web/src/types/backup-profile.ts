@@ -4,6 +4,7 @@ import type { NamingRule } from './naming-rule';
 import type { Command } from './command';
 import type { FileRule } from './file-rule';
 import type { BackupRun } from './backup-run';
+import type { BackupProfileReplica } from './replica';
 
 export interface BackupProfile {
   id: number;
@@ -12,7 +13,19 @@ export interface BackupProfile {
   storage_location_id: number;
   naming_rule_id: number;
   schedule_cron?: string;
+  verify_cron?: string;
+  restore_drill_cron?: string;
+  restore_drill_server_id?: number | null;
+  restore_drill_path?: string;
+  restore_drill_command?: string;
   retention_days?: number | null;
+  keep_last?: number;
+  keep_daily?: number;
+  keep_weekly?: number;
+  keep_monthly?: number;
+  keep_yearly?: number;
+  max_size_bytes?: number;
+  concurrency_policy?: 'queue' | 'skip';
   enabled: boolean;
   created_at: string;
   server?: Server;
@@ -21,6 +34,7 @@ export interface BackupProfile {
   commands?: Command[];
   file_rules?: FileRule[];
   backup_runs?: BackupRun[];
+  replicas?: BackupProfileReplica[];
 }
 
 export interface BackupProfileCreateInput {
@@ -29,7 +43,19 @@ export interface BackupProfileCreateInput {
   storage_location_id: number;
   naming_rule_id: number;
   schedule_cron?: string;
+  verify_cron?: string;
+  restore_drill_cron?: string;
+  restore_drill_server_id?: number | null;
+  restore_drill_path?: string;
+  restore_drill_command?: string;
   retention_days?: number | null;
+  keep_last?: number;
+  keep_daily?: number;
+  keep_weekly?: number;
+  keep_monthly?: number;
+  keep_yearly?: number;
+  max_size_bytes?: number;
+  concurrency_policy?: 'queue' | 'skip';
   enabled: boolean;
 }
 
@@ -39,6 +65,49 @@ export interface BackupProfileUpdateInput {
   storage_location_id?: number;
   naming_rule_id?: number;
   schedule_cron?: string;
+  verify_cron?: string;
+  restore_drill_cron?: string;
+  restore_drill_server_id?: number | null;
+  restore_drill_path?: string;
+  restore_drill_command?: string;
   retention_days?: number | null;
+  keep_last?: number;
+  keep_daily?: number;
+  keep_weekly?: number;
+  keep_monthly?: number;
+  keep_yearly?: number;
+  max_size_bytes?: number;
+  concurrency_policy?: 'queue' | 'skip';
   enabled?: boolean;
 }
+
+export interface RetentionPolicy {
+  keep_within_days: number;
+  keep_last: number;
+  keep_daily: number;
+  keep_weekly: number;
+  keep_monthly: number;
+  keep_yearly: number;
+  max_size_bytes: number;
+}
+
+export interface RetentionDecision {
+  backup_run_id: number;
+  end_time: string;
+  total_size_bytes: number;
+  stored_size_bytes: number;
+  held: boolean;
+  locked: boolean;
+  keep: boolean;
+  reasons: string[];
+}
+
+export interface RetentionPreview {
+  backup_profile_id: number;
+  policy: RetentionPolicy;
+  runs: RetentionDecision[];
+  keep_count: number;
+  prune_count: number;
+  prune_size_bytes: number;
+  stored_size_bytes: number;
+}
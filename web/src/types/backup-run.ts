@@ -1,6 +1,7 @@
 import type { BackupFile } from './backup-file';
+import type { BackupRunReplica } from './replica';
 
-export type BackupRunStatus = 'pending' | 'running' | 'completed' | 'success' | 'failed';
+export type BackupRunStatus = 'pending' | 'running' | 'completed' | 'success' | 'failed' | 'cancelled';
 
 export interface BackupRun {
   id: number;
@@ -11,8 +12,21 @@ export interface BackupRun {
   local_backup_path?: string;
   total_files?: number;
   total_size_bytes?: number;
+  checksum_mismatches?: number;
   error_message?: string;
   log?: string;
   retention_cleaned_up?: boolean;
+  held?: boolean;
+  hold_reason?: string;
+  hold_by?: string;
+  held_at?: string;
+  hold_until?: string;
+  locked_until?: string;
   backup_files?: BackupFile[];
+  replicas?: BackupRunReplica[];
+}
+
+export interface BackupRunHoldInput {
+  reason: string;
+  hold_until?: string | null;
 }
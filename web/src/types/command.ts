@@ -4,7 +4,7 @@ export interface Command {
   command: string;
   working_directory: string;
   run_order: number;
-  run_stage: 'pre' | 'post';
+  run_stage: 'pre' | 'post' | 'cancel';
   created_at: string;
 }
 
@@ -12,12 +12,12 @@ export interface CommandCreateInput {
   command: string;
   working_directory?: string;
   run_order: number;
-  run_stage: 'pre' | 'post';
+  run_stage: 'pre' | 'post' | 'cancel';
 }
 
 export interface CommandUpdateInput {
   command?: string;
   working_directory?: string;
   run_order?: number;
-  run_stage?: 'pre' | 'post';
+  run_stage?: 'pre' | 'post' | 'cancel';
 }
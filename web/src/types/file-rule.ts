@@ -7,6 +7,8 @@ export interface FileRule {
   compress_format?: string;
   compress_password?: string;
   exclude_pattern?: string;
+  incremental?: boolean;
+  verify_checksum?: boolean;
   created_at: string;
 }
 
@@ -17,6 +19,8 @@ export interface FileRuleCreateInput {
   compress_format?: string;
   compress_password?: string;
   exclude_pattern?: string;
+  incremental?: boolean;
+  verify_checksum?: boolean;
 }
 
 export interface FileRuleUpdateInput {
@@ -26,4 +30,6 @@ export interface FileRuleUpdateInput {
   compress_format?: string;
   compress_password?: string;
   exclude_pattern?: string;
+  incremental?: boolean;
+  verify_checksum?: boolean;
 }
@@ -7,4 +7,11 @@ export * from './backup-file';
 export * from './backup-run';
 export * from './backup-run-log';
 export * from './backup-profile';
+export * from './backup-job';
 export * from './deletion-impact';
+export * from './replica';
+export * from './verification';
+export * from './restore-job';
+export * from './restore-drill';
+export * from './known-host';
+export * from './user';
@@ -0,0 +1,15 @@
+export interface KnownHost {
+  id: number;
+  server_id?: number;
+  storage_location_id?: number;
+  address: string;
+  key_type: string;
+  public_key: string;
+  fingerprint: string;
+  pending_key_type?: string;
+  pending_public_key?: string;
+  pending_fingerprint?: string;
+  mismatch_at?: string;
+  created_at: string;
+  updated_at: string;
+}
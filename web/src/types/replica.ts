@@ -0,0 +1,28 @@
+import type { StorageLocation } from './storage-location';
+
+export interface BackupProfileReplica {
+  id: number;
+  backup_profile_id: number;
+  storage_location_id: number;
+  created_at: string;
+  storage_location?: StorageLocation;
+}
+
+export interface BackupProfileReplicaCreateInput {
+  storage_location_id: number;
+}
+
+export type BackupRunReplicaStatus = 'running' | 'completed' | 'failed';
+
+export interface BackupRunReplica {
+  id: number;
+  backup_run_id: number;
+  storage_location_id: number;
+  status: BackupRunReplicaStatus;
+  backup_path?: string;
+  total_files: number;
+  total_size_bytes: number;
+  error_message?: string;
+  start_time?: string;
+  end_time?: string;
+}
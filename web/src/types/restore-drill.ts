@@ -0,0 +1,24 @@
+export type RestoreDrillStatus = 'pending' | 'running' | 'passed' | 'failed' | 'error';
+
+export interface RestoreDrill {
+  id: number;
+  backup_profile_id: number;
+  backup_run_id?: number;
+  restore_job_id?: number;
+  server_id?: number;
+  scratch_path?: string;
+  trigger: 'manual' | 'scheduled';
+  status: RestoreDrillStatus;
+  start_time: string;
+  end_time?: string;
+  expected_files: number;
+  restored_files: number;
+  verified_files: number;
+  failed_files: number;
+  bytes_restored: number;
+  validation_command?: string;
+  validation_output?: string;
+  validation_passed?: boolean;
+  failures?: string[];
+  error_message?: string;
+}
@@ -0,0 +1,48 @@
+export type RestoreJobStatus = 'pending' | 'running' | 'completed' | 'failed';
+
+export type RestoreConflictPolicy = 'overwrite' | 'skip' | 'rename';
+
+export interface RestoreJob {
+  id: number;
+  backup_run_id: number;
+  server_id: number;
+  file_ids?: number[];
+  target_path?: string;
+  conflict_policy: RestoreConflictPolicy;
+  preserve_permissions: boolean;
+  extract_archives: boolean;
+  archive_entries?: string[];
+  pre_commands?: string[];
+  post_commands?: string[];
+  status: RestoreJobStatus;
+  start_time: string;
+  end_time?: string;
+  total_files: number;
+  restored_files: number;
+  skipped_files: number;
+  failed_files: number;
+  total_size_bytes: number;
+  error_message?: string;
+  created_at: string;
+}
+
+export interface RestoreJobCreateInput {
+  server_id?: number;
+  file_ids?: number[];
+  target_path?: string;
+  conflict_policy?: RestoreConflictPolicy;
+  preserve_permissions?: boolean;
+  extract_archives?: boolean;
+  archive_entries?: string[];
+  pre_commands?: string[];
+  post_commands?: string[];
+}
+
+export interface RestoreJobLog {
+  id: number;
+  restore_job_id: number;
+  timestamp: string;
+  level: 'INFO' | 'WARNING' | 'ERROR' | 'DEBUG';
+  message: string;
+  created_at: string;
+}
@@ -8,6 +8,7 @@ export interface Server {
   auth_type: 'password' | 'key';
   password?: string;
   keyfile?: string;
+  max_concurrent_backups?: number;
   created_at: string;
 }
 
@@ -19,4 +20,5 @@ export interface ServerCreateInput {
   auth_type: 'password' | 'key';
   password?: string;
   keyfile?: string;
+  max_concurrent_backups?: number;
 }
@@ -2,28 +2,63 @@ export interface StorageLocation {
   id: number;
   name: string;
   base_path: string;
-  type?: 'local' | 'sftp';
+  type?: 'local' | 'sftp' | 's3' | 'webdav' | 'ftp';
   address?: string;
   port?: number;
   remote_path?: string;
   username?: string;
   password?: string;
   ssh_key?: string;
-  auth_type?: 'key' | 'password';
+  auth_type?: 'key' | 'password' | 'basic' | 'digest';
+  endpoint?: string;
+  bucket?: string;
+  region?: string;
+  access_key?: string;
+  secret_key?: string;
+  path_style?: boolean;
+  tls_mode?: 'none' | 'explicit' | 'implicit';
+  tls_skip_verify?: boolean;
+  encrypted?: boolean;
+  dedup?: boolean;
   enabled?: boolean;
+  min_free_percent?: number;
+  worm_lock_days?: number;
+  max_concurrent_backups?: number;
   created_at: string;
 }
 
 export interface StorageLocationCreateInput {
   name: string;
   base_path?: string;
-  type?: 'local' | 'sftp';
+  type?: 'local' | 'sftp' | 's3' | 'webdav' | 'ftp';
   address?: string;
   port?: number;
   remote_path?: string;
   username?: string;
   password?: string;
   ssh_key?: string;
-  auth_type?: 'key' | 'password';
+  auth_type?: 'key' | 'password' | 'basic' | 'digest';
+  endpoint?: string;
+  bucket?: string;
+  region?: string;
+  access_key?: string;
+  secret_key?: string;
+  path_style?: boolean;
+  tls_mode?: 'none' | 'explicit' | 'implicit';
+  tls_skip_verify?: boolean;
+  encrypted?: boolean;
+  dedup?: boolean;
   enabled?: boolean;
+  min_free_percent?: number;
+  worm_lock_days?: number;
+  max_concurrent_backups?: number;
+}
+
+export interface EncryptionKey {
+  id: number;
+  storage_location_id: number;
+  version: number;
+  fingerprint: string;
+  active: boolean;
+  created_at: string;
 }
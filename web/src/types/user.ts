@@ -0,0 +1,59 @@
+export type UserRole = 'admin' | 'operator' | 'viewer';
+
+export interface UserScope {
+  id: number;
+  user_id: number;
+  server_id?: number;
+  backup_profile_id?: number;
+  created_at: string;
+}
+
+export interface UserScopeInput {
+  server_id?: number;
+  backup_profile_id?: number;
+}
+
+export interface User {
+  id: number;
+  username: string;
+  role: UserRole;
+  last_login_at?: string;
+  created_at: string;
+  updated_at: string;
+  scopes?: UserScope[];
+}
+
+export interface UserCreateInput {
+  username: string;
+  password: string;
+  role: UserRole;
+}
+
+export interface AuthSession {
+  user: User;
+  csrf_token: string;
+}
+
+export interface APIToken {
+  id: number;
+  user_id: number;
+  name: string;
+  prefix: string;
+  role: UserRole;
+  last_used_at?: string;
+  expires_at?: string;
+  revoked_at?: string;
+  created_at: string;
+  user?: User;
+}
+
+export interface APITokenCreateInput {
+  name: string;
+  role?: UserRole;
+  expires_at?: string;
+}
+
+export interface APITokenCreated {
+  token: string;
+  api_token: APIToken;
+}
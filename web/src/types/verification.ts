@@ -0,0 +1,31 @@
+export type BackupVerificationStatus = 'pending' | 'running' | 'passed' | 'failed' | 'error';
+
+export interface BackupVerificationResult {
+  id: number;
+  backup_verification_id: number;
+  backup_file_id: number;
+  backup_run_id: number;
+  local_path: string;
+  status: 'missing' | 'checksum_mismatch' | 'archive_corrupt' | 'unreadable';
+  expected_checksum?: string;
+  actual_checksum?: string;
+  message?: string;
+  created_at: string;
+}
+
+export interface BackupVerification {
+  id: number;
+  scope: 'run' | 'profile' | 'location';
+  backup_run_id?: number;
+  backup_profile_id?: number;
+  storage_location_id?: number;
+  trigger: 'manual' | 'scheduled';
+  status: BackupVerificationStatus;
+  start_time: string;
+  end_time?: string;
+  files_checked: number;
+  files_failed: number;
+  bytes_read: number;
+  error_message?: string;
+  results?: BackupVerificationResult[];
+}
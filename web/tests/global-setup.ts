@@ -0,0 +1,31 @@
+/**
+ * Global setup
+ *
+ * Signs in with the e2e admin account once and stores the session so every test
+ * starts authenticated.
+ */
+import { request, type FullConfig } from '@playwright/test';
+
+/** Admin account created by the test server on first start */
+export const E2E_ADMIN_USERNAME = 'e2e-admin';
+export const E2E_ADMIN_PASSWORD = 'e2e-admin-password';
+
+/** Session cookies shared by all tests */
+export const AUTH_STATE_PATH = 'playwright/.auth/state.json';
+
+export default async function globalSetup(config: FullConfig): Promise<void> {
+  const context = await request.newContext({ baseURL: config.projects[0].use.baseURL });
+  const response = await context.post('/api/v1/auth/login', {
+    data: { username: E2E_ADMIN_USERNAME, password: E2E_ADMIN_PASSWORD },
+  });
+  if (!response.ok()) {
+    throw new Error(`Failed to sign in as ${E2E_ADMIN_USERNAME}: ${response.status()}`);
+  }
+  const session = await response.json();
+
+  // Read by playwright.config.ts in the worker processes
+  process.env.BACKAPP_CSRF_TOKEN = session.csrf_token;
+
+  await context.storageState({ path: AUTH_STATE_PATH });
+  await context.dispose();
+}